@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/shafigh75/Memorandum/server/audit"
+	"github.com/spf13/cobra"
+)
+
+var auditCmd = &cobra.Command{
+	Use:   "audit [command]",
+	Short: "Inspect the audit log",
+}
+
+var auditVerifyCmd = &cobra.Command{
+	Use:   "verify [audit-file]",
+	Short: "Verify the hash chain of the audit log",
+	Long: `Checks that no record of the audit log was modified, removed or reordered.
+The file defaults to audit_log_path from the config. Records removed from the
+end of the file are only detected by comparing the printed last hash with one
+kept elsewhere.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return auditVerify(args)
+	},
+}
+
+func init() {
+	auditCmd.AddCommand(auditVerifyCmd)
+	rootCmd.AddCommand(auditCmd)
+}
+
+func auditVerify(args []string) error {
+	var path string
+	if len(args) > 0 {
+		path = args[0]
+	} else {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		if path = cfg.AuditLogPath; path == "" {
+			return errors.New("audit_log_path is not set in the config")
+		}
+	}
+
+	count, last, err := audit.Verify(path)
+	if err != nil {
+		return fmt.Errorf("%s: %d valid records before the error: %w", path, count, err)
+	}
+	fmt.Printf(Green+"%s: %d records, chain intact"+Reset+"\n", path, count)
+	if last != "" {
+		fmt.Println("Last hash:", last)
+	}
+	return nil
+}
@@ -2,11 +2,16 @@ package main
 
 import (
 	"crypto/rand"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/rpc"
+	"os"
+	"os/signal"
 	"strings"
+	"time"
 
 	"github.com/shafigh75/Memorandum/config"
 
@@ -16,21 +21,102 @@ import (
 
 // RPCRequest and RPCResponse structures
 type RPCRequest struct {
-	Key   string `json:"key"`
-	Value string `json:"value,omitempty"`
-	TTL   int64  `json:"ttl"` // TTL in seconds
+	Key      string `json:"key"`
+	Value    string `json:"value,omitempty"`
+	TTL      int64  `json:"ttl"`                 // TTL in seconds (milliseconds for the P* methods)
+	ExpireAt int64  `json:"expire_at,omitempty"` // Unix time in seconds (milliseconds for RPCPExpireAt)
+}
+
+type AuthRequest struct {
+	Token    string
+	Username string
+	Password string
+}
+
+type ACLUser struct {
+	Name        string
+	Role        string
+	Commands    []string
+	KeyPatterns []string
+}
+
+type ACLUserRequest struct {
+	User     ACLUser
+	Password string
+}
+
+type ACLUsersResponse struct {
+	Users []ACLUser
+	Error string
+}
+
+type InfoRequest struct {
+	Section string
+}
+
+type SlowlogRequest struct {
+	Count int
+}
+
+type SlowlogEntry struct {
+	ID        int64
+	Time      time.Time
+	Duration  time.Duration
+	Protocol  string
+	Command   string
+	Key       string
+	ValueSize int
+	Client    string
+	TraceID   string
+}
+
+type SlowlogResponse struct {
+	Entries []SlowlogEntry
+	Len     int
+}
+
+type MonitorRequest struct {
+	MaxValue int
+}
+
+type MonitorResponse struct {
+	Lines []string
+}
+
+type KeysReportRequest struct {
+	Count int
+}
+
+type HotKey struct {
+	Key      string
+	Accesses int64
+}
+
+type HotKeysResponse struct {
+	Keys []HotKey
+}
+
+type BigKey struct {
+	Key   string
+	Bytes int
+	Shard int
+}
+
+type BigKeysResponse struct {
+	Keys []BigKey
 }
 
 type RPCResponse struct {
 	Success bool   `json:"success"`
 	Data    string `json:"data,omitempty"`
+	TTL     int64  `json:"ttl,omitempty"`
 	Error   string `json:"error,omitempty"`
 }
 
 var (
 	client          *rpc.Client
-	authToken       string
 	isAuthenticated bool
+	configPath      string // --config, resolved with config.Path
 )
 
 var rootCmd = &cobra.Command{
@@ -38,19 +124,32 @@ var rootCmd = &cobra.Command{
 	Short: "Memorandum command line interface",
 	Long:  `This is a CLI application for Memorandum project. developed by: Mohammad Shafighi`,
 	Run: func(cmd *cobra.Command, args []string) {
+		// Load configuration
+		cfg, err := config.Load(config.Path(configPath), nil)
+		if err != nil {
+			fmt.Println("Error loading config:", err)
+			return
+		}
+
+		// Connect to the RPC server
+		client, err = dialRPC(cfg)
+		if err != nil {
+			fmt.Println("Error connecting to RPC server:", err)
+			return
+		}
+		defer client.Close()
+
 		fmt.Println("Welcome to Memorandum CLI! Type 'help' for available commands.")
-		startREPL()
+		startREPL(cfg)
 	},
 }
 
-func startREPL() {
+func init() {
+	rootCmd.PersistentFlags().StringVar(&configPath, "config", "", "configuration file (default $"+config.EnvPrefix+"CONFIG, then "+config.DefaultPath+")")
+}
+
+func startREPL(cfg *config.Config) {
 	// check if auth is enabled
-	configFilePath := "config/config.json"
-	cfg, err := config.LoadConfig(configFilePath)
-	if err != nil {
-		fmt.Println("Error loading config:", err)
-		return
-	}
 	if !cfg.AuthEnabled {
 		isAuthenticated = true
 	}
@@ -59,10 +158,27 @@ func startREPL() {
 		readline.PcItem("help"),
 		readline.PcItem("exit"),
 		readline.PcItem("auth"),
+		readline.PcItem("acl", readline.PcItem("users"), readline.PcItem("setuser"), readline.PcItem("deluser")),
 		readline.PcItem("passwd"),
+		readline.PcItem("info", readline.PcItem("server"), readline.PcItem("clients"), readline.PcItem("memory"),
+			readline.PcItem("keyspace"), readline.PcItem("persistence"), readline.PcItem("replication")),
+		readline.PcItem("slowlog", readline.PcItem("get"), readline.PcItem("reset")),
+		readline.PcItem("monitor", readline.PcItem("max-value")),
+		readline.PcItem("hotkeys", readline.PcItem("count")),
+		readline.PcItem("bigkeys", readline.PcItem("count")),
 		readline.PcItem("set", readline.PcItem("key"), readline.PcItem("value"), readline.PcItem("ttl")),
+		readline.PcItem("pset", readline.PcItem("key"), readline.PcItem("value"), readline.PcItem("milliseconds")),
 		readline.PcItem("get", readline.PcItem("key")),
 		readline.PcItem("delete", readline.PcItem("key")),
+		readline.PcItem("ttl", readline.PcItem("key")),
+		readline.PcItem("pttl", readline.PcItem("key")),
+		readline.PcItem("expire", readline.PcItem("key"), readline.PcItem("seconds")),
+		readline.PcItem("pexpire", readline.PcItem("key"), readline.PcItem("milliseconds")),
+		readline.PcItem("expireat", readline.PcItem("key"), readline.PcItem("unix-seconds")),
+		readline.PcItem("pexpireat", readline.PcItem("key"), readline.PcItem("unix-milliseconds")),
+		readline.PcItem("persist", readline.PcItem("key")),
+		readline.PcItem("getex", readline.PcItem("key"), readline.PcItem("seconds")),
+		readline.PcItem("pgetex", readline.PcItem("key"), readline.PcItem("milliseconds")),
 	)
 
 	// Create readline instance
@@ -102,21 +218,69 @@ func handleCommand(input string) {
 	}
 
 	if !isAuthenticated && args[0] != "auth" {
-		fmt.Println("Authentication required. Please use 'auth <token>' to authenticate.")
+		fmt.Println("Authentication required. Please use 'auth <token>' or 'auth <user> <password>' to authenticate.")
 		return
 	}
 
 	switch args[0] {
 	case "help":
-		fmt.Println("Available commands: help, exit, auth [token], passwd, set [key] [value] [ttl], get [key], delete [key]")
+		fmt.Println("Available commands: help, exit, auth [token] | [user] [password], passwd, set [key] [value] [ttl], pset [key] [value] [ms], get [key], delete [key],")
+		fmt.Println("  ttl [key], pttl [key], expire [key] [seconds], pexpire [key] [ms], expireat [key] [unix-seconds],")
+		fmt.Println("  pexpireat [key] [unix-ms], persist [key], getex [key] [seconds], pgetex [key] [ms],")
+		fmt.Println("  acl users, acl setuser [name] [role] [password|-] [+command ...] [key-pattern ...], acl deluser [name],")
+		fmt.Println("  info [server|clients|memory|keyspace|persistence|replication], slowlog get [count], slowlog reset,")
+		fmt.Println("  monitor [max-value], hotkeys [count], bigkeys [count]")
 	case "auth":
-		if len(args) != 2 {
-			fmt.Println("Usage: auth [token]")
-			return
+		switch len(args) {
+		case 2:
+			authenticate(AuthRequest{Token: args[1]})
+		case 3:
+			authenticate(AuthRequest{Username: args[1], Password: args[2]})
+		default:
+			fmt.Println("Usage: auth [token] or auth [user] [password]")
 		}
-		authenticate(args[1])
+	case "acl":
+		handleACL(args[1:])
 	case "passwd":
 		generatePassword()
+	case "info":
+		if len(args) > 2 {
+			fmt.Println("Usage: info [section]")
+			return
+		}
+		req := InfoRequest{}
+		if len(args) == 2 {
+			req.Section = args[1]
+		}
+		showInfo(req)
+	case "slowlog":
+		handleSlowlog(args[1:])
+	case "monitor":
+		if len(args) > 2 {
+			fmt.Println("Usage: monitor [max-value]")
+			return
+		}
+		req := MonitorRequest{}
+		if len(args) == 2 {
+			if _, err := fmt.Sscanf(args[1], "%d", &req.MaxValue); err != nil {
+				fmt.Println("Error: expected a number of bytes, got", args[1])
+				return
+			}
+		}
+		monitor(req)
+	case "hotkeys", "bigkeys":
+		if len(args) > 2 {
+			fmt.Printf("Usage: %s [count]\n", args[0])
+			return
+		}
+		req := KeysReportRequest{}
+		if len(args) == 2 {
+			if _, err := fmt.Sscanf(args[1], "%d", &req.Count); err != nil {
+				fmt.Println("Error: expected a count, got", args[1])
+				return
+			}
+		}
+		showKeysReport(args[0], req)
 	case "set":
 		if len(args) < 3 {
 			fmt.Println("Usage: set [key] [value] [ttl-optional]")
@@ -135,6 +299,17 @@ func handleCommand(input string) {
 		}
 		value := strings.Join(args[2:len(args)-1], " ")
 		setKey(key, value, ttl)
+	case "pset":
+		if len(args) < 4 {
+			fmt.Println("Usage: pset [key] [value] [ttl-milliseconds]")
+			return
+		}
+		var ttl int64
+		if _, err := fmt.Sscanf(args[len(args)-1], "%d", &ttl); err != nil {
+			fmt.Println("Error: expected a TTL in milliseconds, got", args[len(args)-1])
+			return
+		}
+		psetKey(args[1], strings.Join(args[2:len(args)-1], " "), ttl)
 	case "get":
 		if len(args) != 2 {
 			fmt.Println("Usage: get [key]")
@@ -147,13 +322,45 @@ func handleCommand(input string) {
 			return
 		}
 		deleteKey(args[1])
+	case "ttl", "pttl", "persist":
+		if len(args) != 2 {
+			fmt.Printf("Usage: %s [key]\n", args[0])
+			return
+		}
+		if args[0] == "persist" {
+			persistKey(args[1])
+		} else {
+			showTTL(args[0], args[1])
+		}
+	case "expire", "pexpire", "expireat", "pexpireat", "getex", "pgetex":
+		if len(args) != 3 {
+			fmt.Printf("Usage: %s [key] [number]\n", args[0])
+			return
+		}
+		var n int64
+		if _, err := fmt.Sscanf(args[2], "%d", &n); err != nil {
+			fmt.Println("Error: expected a number, got", args[2])
+			return
+		}
+		if args[0] == "getex" || args[0] == "pgetex" {
+			getExKey(args[0], args[1], n)
+		} else {
+			expireKey(args[0], args[1], n)
+		}
 	default:
 		fmt.Printf("Unknown command: %s\n", input)
 	}
 }
 
-func authenticate(token string) {
-	if token == authToken {
+// authenticate sends the token or user credentials to the server, which
+// checks them and unlocks the connection.
+func authenticate(req AuthRequest) {
+	var resp RPCResponse
+	if err := client.Call("RPCService.Auth", &req, &resp); err != nil {
+		fmt.Println("Error calling RPC:", err)
+		return
+	}
+	if resp.Success {
 		isAuthenticated = true
 		fmt.Println("Authentication successful.")
 	} else {
@@ -161,6 +368,180 @@ func authenticate(token string) {
 	}
 }
 
+// showInfo prints the server information returned by RPCService.Info.
+func showInfo(req InfoRequest) {
+	var resp RPCResponse
+	if err := client.Call("RPCService.Info", &req, &resp); err != nil {
+		fmt.Println("Error calling RPC:", err)
+		return
+	}
+	if !resp.Success {
+		fmt.Println("Error:", resp.Error)
+		return
+	}
+	fmt.Print(resp.Data)
+}
+
+// handleSlowlog runs the slowlog subcommands: get prints the newest slow
+// operations and reset empties the slow log.
+func handleSlowlog(args []string) {
+	switch {
+	case len(args) >= 1 && len(args) <= 2 && args[0] == "get":
+		req := SlowlogRequest{}
+		if len(args) == 2 {
+			if _, err := fmt.Sscanf(args[1], "%d", &req.Count); err != nil {
+				fmt.Println("Error: expected a count, got", args[1])
+				return
+			}
+		}
+		var resp SlowlogResponse
+		if err := client.Call("RPCService.SlowlogGet", &req, &resp); err != nil {
+			fmt.Println("Error calling RPC:", err)
+			return
+		}
+		for _, entry := range resp.Entries {
+			fmt.Printf("%d\t%s\t%s\t%s\t%s\t%q\tsize=%d\t%s", entry.ID, entry.Time.Format("2006-01-02 15:04:05.000"),
+				entry.Duration, entry.Protocol, entry.Command, entry.Key, entry.ValueSize, entry.Client)
+			if entry.TraceID != "" {
+				fmt.Printf("\ttrace=%s", entry.TraceID)
+			}
+			fmt.Println()
+		}
+		fmt.Printf("(%d of %d entries)\n", len(resp.Entries), resp.Len)
+	case len(args) == 1 && args[0] == "reset":
+		var resp RPCResponse
+		if err := client.Call("RPCService.SlowlogReset", &SlowlogRequest{}, &resp); err != nil {
+			fmt.Println("Error calling RPC:", err)
+			return
+		}
+		fmt.Println("Slow log reset.")
+	default:
+		fmt.Println("Usage: slowlog get [count] | slowlog reset")
+	}
+}
+
+// showKeysReport prints the most accessed keys for hotkeys and the largest
+// keys for bigkeys.
+func showKeysReport(command string, req KeysReportRequest) {
+	if command == "hotkeys" {
+		var resp HotKeysResponse
+		if err := client.Call("RPCService.HotKeys", &req, &resp); err != nil {
+			fmt.Println("Error calling RPC:", err)
+			return
+		}
+		if len(resp.Keys) == 0 {
+			fmt.Println("No hot keys, tracking may be disabled with hotkeys_sample_rate 0.")
+		}
+		for i, key := range resp.Keys {
+			fmt.Printf("%d) %q\taccesses=%d\n", i+1, key.Key, key.Accesses)
+		}
+		return
+	}
+	var resp BigKeysResponse
+	if err := client.Call("RPCService.BigKeys", &req, &resp); err != nil {
+		fmt.Println("Error calling RPC:", err)
+		return
+	}
+	for i, key := range resp.Keys {
+		fmt.Printf("%d) %q\tbytes=%d\tshard=%d\n", i+1, key.Key, key.Bytes, key.Shard)
+	}
+}
+
+// monitor prints the commands served by the server as they arrive, until
+// interrupted with Ctrl-C.
+func monitor(req MonitorRequest) {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	defer client.Call("RPCService.MonitorStop", &req, &RPCResponse{})
+
+	fmt.Println("Monitoring, press Ctrl-C to stop.")
+	for {
+		var resp MonitorResponse
+		if err := client.Call("RPCService.Monitor", &req, &resp); err != nil {
+			fmt.Println("Error calling RPC:", err)
+			return
+		}
+		for _, line := range resp.Lines {
+			fmt.Println(line)
+		}
+		select {
+		case <-interrupt:
+			return
+		default:
+		}
+	}
+}
+
+// handleACL runs the acl subcommands, which manage the server's users.
+func handleACL(args []string) {
+	if len(args) == 0 {
+		fmt.Println("Usage: acl users | acl setuser [name] [role] [password|-] [+command ...] [key-pattern ...] | acl deluser [name]")
+		return
+	}
+	switch args[0] {
+	case "users":
+		var resp ACLUsersResponse
+		if err := client.Call("RPCService.ACLUsers", &ACLUserRequest{}, &resp); err != nil {
+			fmt.Println("Error calling RPC:", err)
+			return
+		}
+		if resp.Error != "" {
+			fmt.Println("Error:", resp.Error)
+			return
+		}
+		for _, user := range resp.Users {
+			commands, keys := "all", "*"
+			if len(user.Commands) > 0 {
+				commands = strings.Join(user.Commands, ",")
+			}
+			if len(user.KeyPatterns) > 0 {
+				keys = strings.Join(user.KeyPatterns, ",")
+			}
+			fmt.Printf("%s\trole=%s\tcommands=%s\tkeys=%s\n", user.Name, user.Role, commands, keys)
+		}
+	case "setuser":
+		if len(args) < 4 {
+			fmt.Println("Usage: acl setuser [name] [role] [password|-] [+command ...] [key-pattern ...]")
+			fmt.Println("  roles: read-only, read-write, admin; '-' keeps the current password")
+			return
+		}
+		req := ACLUserRequest{User: ACLUser{Name: args[1], Role: args[2]}}
+		if args[3] != "-" {
+			req.Password = args[3]
+		}
+		for _, arg := range args[4:] {
+			if strings.HasPrefix(arg, "+") {
+				req.User.Commands = append(req.User.Commands, arg[1:])
+			} else {
+				req.User.KeyPatterns = append(req.User.KeyPatterns, arg)
+			}
+		}
+		callACL("RPCService.ACLSetUser", req, "User saved.")
+	case "deluser":
+		if len(args) != 2 {
+			fmt.Println("Usage: acl deluser [name]")
+			return
+		}
+		callACL("RPCService.ACLDeleteUser", ACLUserRequest{User: ACLUser{Name: args[1]}}, "User deleted.")
+	default:
+		fmt.Println("Unknown acl command:", args[0])
+	}
+}
+
+func callACL(method string, req ACLUserRequest, success string) {
+	var resp RPCResponse
+	if err := client.Call(method, &req, &resp); err != nil {
+		fmt.Println("Error calling RPC:", err)
+		return
+	}
+	if resp.Success {
+		fmt.Println(success)
+	} else {
+		fmt.Println("Error:", resp.Error)
+	}
+}
+
 func generatePassword() {
 	password := make([]byte, 16) // 16 bytes = 128 bits
 	if _, err := rand.Read(password); err != nil {
@@ -169,16 +550,22 @@ func generatePassword() {
 	}
 	newToken := fmt.Sprintf("%x", password)
 
-	// Update the config file with the new token
-	configFilePath := "config/config.json"
-	cfg, err := config.LoadConfig(configFilePath)
+	// Update the config file with the new token. Only auth_token is replaced,
+	// so that defaults and environment overrides are not written into it.
+	configFilePath := config.Path(configPath)
+	data, err := ioutil.ReadFile(configFilePath)
 	if err != nil {
 		fmt.Println("Error loading config:", err)
 		return
 	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		fmt.Println("Error loading config:", err)
+		return
+	}
 
-	cfg.AuthToken = newToken
-	configData, err := json.MarshalIndent(cfg, "", "  ")
+	fields["auth_token"], _ = json.Marshal(newToken)
+	configData, err := json.MarshalIndent(fields, "", "  ")
 	if err != nil {
 		fmt.Println("Error marshalling config:", err)
 		return
@@ -189,7 +576,7 @@ func generatePassword() {
 		return
 	}
 
-	fmt.Println("New password generated and saved to config:", newToken)
+	fmt.Println("New password generated and saved to config (the server applies it on its next config reload):", newToken)
 }
 
 func setKey(key, value string, ttl int64) {
@@ -207,6 +594,21 @@ func setKey(key, value string, ttl int64) {
 	}
 }
 
+func psetKey(key, value string, ttl int64) {
+	req := RPCRequest{Key: key, Value: value, TTL: ttl}
+	var resp RPCResponse
+	err := client.Call("RPCService.RPCPSet", &req, &resp)
+	if err != nil {
+		fmt.Println("Error calling RPCPSet:", err)
+		return
+	}
+	if resp.Success {
+		fmt.Println("Key set successfully.")
+	} else {
+		fmt.Println("Error:", resp.Error)
+	}
+}
+
 func getKey(key string) {
 	req := RPCRequest{Key: key}
 	var resp RPCResponse
@@ -237,25 +639,105 @@ func deleteKey(key string) {
 	}
 }
 
-func main() {
-	// Load configuration
-	cfg, err := config.LoadConfig("config/config.json")
-	if err != nil {
-		fmt.Println("Error loading config:", err)
+// rpcMethods maps expiration commands to RPC methods.
+var rpcMethods = map[string]string{
+	"ttl":       "RPCService.RPCTTL",
+	"pttl":      "RPCService.RPCPTTL",
+	"expire":    "RPCService.RPCExpire",
+	"pexpire":   "RPCService.RPCPExpire",
+	"expireat":  "RPCService.RPCExpireAt",
+	"pexpireat": "RPCService.RPCPExpireAt",
+	"getex":     "RPCService.RPCGetEx",
+	"pgetex":    "RPCService.RPCPGetEx",
+}
+
+func showTTL(command, key string) {
+	req := RPCRequest{Key: key}
+	var resp RPCResponse
+	if err := client.Call(rpcMethods[command], &req, &resp); err != nil {
+		fmt.Println("Error calling", rpcMethods[command]+":", err)
 		return
 	}
+	switch {
+	case !resp.Success:
+		fmt.Println("Error:", resp.Error)
+	case resp.TTL == -1:
+		fmt.Println("Key has no expiration.")
+	case command == "pttl":
+		fmt.Printf("TTL: %d ms\n", resp.TTL)
+	default:
+		fmt.Printf("TTL: %d s\n", resp.TTL)
+	}
+}
 
-	// Connect to the RPC server
-	client, err = rpc.Dial("tcp", cfg.RPCPort)
-	if err != nil {
-		fmt.Println("Error connecting to RPC server:", err)
+func expireKey(command, key string, n int64) {
+	req := RPCRequest{Key: key}
+	if command == "expireat" || command == "pexpireat" {
+		req.ExpireAt = n
+	} else {
+		req.TTL = n
+	}
+	var resp RPCResponse
+	if err := client.Call(rpcMethods[command], &req, &resp); err != nil {
+		fmt.Println("Error calling", rpcMethods[command]+":", err)
 		return
 	}
-	defer client.Close()
+	if resp.Success {
+		fmt.Println("Expiration updated.")
+	} else {
+		fmt.Println("Error:", resp.Error)
+	}
+}
 
-	// Load the configuration to get the auth token
-	authToken = cfg.AuthToken
+func persistKey(key string) {
+	req := RPCRequest{Key: key}
+	var resp RPCResponse
+	if err := client.Call("RPCService.RPCPersist", &req, &resp); err != nil {
+		fmt.Println("Error calling RPCPersist:", err)
+		return
+	}
+	if resp.Success {
+		fmt.Println("Expiration removed.")
+	} else {
+		fmt.Println("Error:", resp.Error)
+	}
+}
 
+func getExKey(command, key string, ttl int64) {
+	req := RPCRequest{Key: key, TTL: ttl}
+	var resp RPCResponse
+	if err := client.Call(rpcMethods[command], &req, &resp); err != nil {
+		fmt.Println("Error calling", rpcMethods[command]+":", err)
+		return
+	}
+	if resp.Success {
+		fmt.Printf("Value: %s\n", resp.Data)
+	} else {
+		fmt.Println("Error:", resp.Error)
+	}
+}
+
+// dialRPC connects to the RPC server, over TLS if it is enabled in the config.
+func dialRPC(cfg *config.Config) (*rpc.Client, error) {
+	tlsConfig, err := cfg.ClientTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig == nil {
+		return rpc.Dial("tcp", cfg.RPCPort)
+	}
+	// rpc_port usually has no host part, so the local server is dialed.
+	if host, _, err := net.SplitHostPort(cfg.RPCPort); err == nil && host == "" && tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = "localhost"
+	}
+	conn, err := tls.Dial("tcp", cfg.RPCPort, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	return rpc.NewClient(conn), nil
+}
+
+func main() {
 	// Execute the root command
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
@@ -3,13 +3,23 @@ package cluster
 import (
 	"encoding/json"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/shafigh75/Memorandum/cluster/manager"
 	"github.com/shafigh75/Memorandum/config"
+	"github.com/shafigh75/Memorandum/server/acl"
+	"github.com/shafigh75/Memorandum/server/audit"
+	"github.com/shafigh75/Memorandum/server/monitor"
+	"github.com/shafigh75/Memorandum/server/slowlog"
+	"github.com/shafigh75/Memorandum/server/trace"
+	"github.com/shafigh75/Memorandum/utils/metrics"
 )
 
 type NodeConfig struct {
@@ -24,57 +34,243 @@ type HTTPResponse struct {
 
 var nodesFileMutex sync.Mutex
 
-func authMiddleware(cfg *config.Config, next http.HandlerFunc) http.HandlerFunc {
+// auditLog records deletes, node additions and denied requests; nil disables it.
+var auditLog *audit.Log
+
+// slowLog records slow requests; nil disables it.
+var slowLog *slowlog.Log
+
+// monitorHub receives every request for MONITOR; nil disables it.
+var monitorHub *monitor.Hub
+
+// tracer exports the spans of requests; nil only correlates logs.
+var tracer *trace.Tracer
+
+// principal returns the name of the user of a request, or "" when
+// authentication is disabled.
+func principal(r *http.Request) string {
+	if user := acl.UserFromContext(r.Context()); user != nil {
+		return user.Name
+	}
+	return ""
+}
+
+// authMiddleware authenticates requests against the ACL and stores the user
+// in the request context. A nil accessList disables authentication.
+func authMiddleware(accessList *acl.List, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if cfg.AuthEnabled {
-			authHeader := r.Header.Get("Authorization")
-			if authHeader != "Bearer "+cfg.AuthToken {
+		if accessList != nil {
+			user, err := accessList.AuthenticateRequest(r)
+			if err != nil {
+				slog.Warn("cluster request denied", "reason", "UNAUTHORIZED", "method", r.Method, "path", r.URL.Path, "client", r.RemoteAddr,
+					"trace_id", trace.FromContext(r.Context()).TraceID())
+				auditLog.Record(audit.Event{
+					Source:    r.RemoteAddr,
+					Operation: audit.OpAuth,
+					Outcome:   audit.OutcomeDenied,
+					Detail:    err.Error(),
+				})
 				sendError(w, "Unauthorized", http.StatusUnauthorized)
 				return
 			}
+			r = r.WithContext(acl.WithUser(r.Context(), user))
 		}
 		next(w, r)
 	}
 }
 
-func StartHTTPServer(port string) {
-	cfg, err := config.LoadConfig("config/config.json")
+// statusRecorder remembers the status code and size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status  int
+	written int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	n, err := s.ResponseWriter.Write(p)
+	s.written += n
+	return n, err
+}
+
+// instrument records the duration and status of requests in the metrics,
+// slow requests in the slow log and every request in the monitor. The value
+// size in the slow log is that of the request body for writes and of the
+// response for reads. Each request gets a span, which continues the trace of
+// the client and is passed to the nodes in the request context.
+func instrument(operation string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		parent, requestID := trace.Extract(r.Header)
+		span := tracer.StartServer("cluster "+operation, parent)
+		trace.WriteHeaders(w.Header(), span, requestID)
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(recorder, r.WithContext(trace.NewContext(r.Context(), span)))
+		took := time.Since(start)
+		metrics.ObserveRequest("cluster", operation, strconv.Itoa(recorder.status), took)
+
+		var key string
+		switch operation {
+		case "get":
+			key = strings.TrimPrefix(r.URL.Path, "/get/")
+		case "delete":
+			key = strings.TrimPrefix(r.URL.Path, "/delete/")
+		}
+		endSpan(span, r, key, recorder.status)
+		monitorHub.Publish(monitor.Event{
+			Time:     start,
+			Protocol: "cluster",
+			Client:   r.RemoteAddr,
+			Command:  operation,
+			Key:      key,
+		})
+		if !slowLog.Slow(took) {
+			return
+		}
+		entry := slowlog.Entry{
+			Time:     start,
+			Duration: took,
+			Protocol: "cluster",
+			Command:  operation,
+			Key:      key,
+			Client:   r.RemoteAddr,
+			TraceID:  span.TraceID(),
+		}
+		switch operation {
+		case "get":
+			entry.ValueSize = recorder.written
+		case "set":
+			entry.ValueSize = int(max(r.ContentLength, 0))
+		}
+		slowLog.Record(entry)
+	}
+}
+
+// endSpan records the outcome of a request in its span and ends it.
+func endSpan(span *trace.Span, r *http.Request, key string, status int) {
+	span.SetAttr("http.request.method", r.Method)
+	span.SetAttr("url.path", r.URL.Path)
+	span.SetAttr("client.address", r.RemoteAddr)
+	span.SetAttr("http.response.status_code", status)
+	if key != "" {
+		span.SetAttr("memorandum.key", key)
+	}
+	if status >= http.StatusInternalServerError {
+		span.SetError(http.StatusText(status))
+	}
+	span.End()
+}
+
+// authorize reports whether the user of the request may run command on key,
+// and responds 403 if not.
+func authorize(w http.ResponseWriter, r *http.Request, command, key string) bool {
+	user := acl.UserFromContext(r.Context())
+	if user.Can(command, key) {
+		return true
+	}
+	slog.Warn("cluster request denied", "reason", "FORBIDDEN", "user", user.Name, "command", command, "key", key, "client", r.RemoteAddr,
+		"trace_id", trace.FromContext(r.Context()).TraceID())
+	auditLog.Record(audit.Event{
+		Principal: user.Name,
+		Source:    r.RemoteAddr,
+		Operation: command,
+		Key:       key,
+		Outcome:   audit.OutcomeForbidden,
+	})
+	sendError(w, "Forbidden", http.StatusForbidden)
+	return false
+}
+
+// StartHTTPServer starts the cluster API on cluster_port. Unless accessList
+// is nil, requests are authenticated and checked against it. Deletes, node
+// additions and denied requests are recorded in trail, slow requests in slow,
+// every request in hub and the spans of requests in spans; all may be nil.
+func StartHTTPServer(configs *config.Manager, accessList *acl.List, trail *audit.Log, slow *slowlog.Log, hub *monitor.Hub, spans *trace.Tracer) {
+	auditLog = trail
+	slowLog = slow
+	monitorHub = hub
+	tracer = spans
+	cfg := configs.Get()
+	port := cfg.ClusterPort
+
+	nodeService := initializeCluster(configs)
+
+	http.HandleFunc("/set", instrument("set", authMiddleware(accessList, handleSet(nodeService))))
+	http.HandleFunc("/get/", instrument("get", authMiddleware(accessList, handleGet(nodeService))))
+	http.HandleFunc("/delete/", instrument("delete", authMiddleware(accessList, handleDelete(nodeService))))
+	http.HandleFunc("/nodes", instrument("nodes", authMiddleware(accessList, handleNodes(nodeService))))
+	http.HandleFunc("/nodes/add", instrument("addnode", authMiddleware(accessList, handleAddNode(nodeService, nodesFile(cfg)))))
+
+	tlsConfig, err := cfg.ServerTLSConfig()
 	if err != nil {
-		log.Fatalf("Error loading config: %v", err)
+		fatal("loading the TLS config failed", "err", err)
+	}
+	server := &http.Server{Addr: port, TLSConfig: tlsConfig}
+	slog.Info("starting cluster server", "addr", port, "tls", tlsConfig != nil)
+	if tlsConfig != nil {
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
 	}
+	fatal("cluster server failed", "err", err)
+}
 
-	nodeService := initializeCluster()
+// fatal logs an error that the cluster cannot run without and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
 
-	http.HandleFunc("/set", authMiddleware(cfg, handleSet(nodeService)))
-	http.HandleFunc("/get/", authMiddleware(cfg, handleGet(nodeService)))
-	http.HandleFunc("/delete/", authMiddleware(cfg, handleDelete(nodeService)))
-	http.HandleFunc("/nodes", authMiddleware(cfg, handleNodes(nodeService)))
-	http.HandleFunc("/nodes/add", authMiddleware(cfg, handleAddNode(nodeService)))
+// running is the manager of the cluster started by StartHTTPServer.
+var running atomic.Pointer[manager.ClusterManager]
 
-	log.Printf("memo-cluster running on port %s\n", port)
-	log.Fatal(http.ListenAndServe(port, nil))
+// Members returns the nodes of the running cluster, or nil if the cluster
+// has not been started.
+func Members() []manager.Node {
+	if cm := running.Load(); cm != nil {
+		return cm.Members()
+	}
+	return nil
 }
 
-func initializeCluster() *manager.NodeService {
+// nodesFile returns the path of the nodes file, cluster/nodes.json unless
+// nodes_file is set.
+func nodesFile(cfg *config.Config) string {
+	if cfg.NodesFile == "" {
+		return "cluster/nodes.json"
+	}
+	return cfg.NodesFile
+}
+
+func initializeCluster(configs *config.Manager) *manager.NodeService {
 	var nodeConfig NodeConfig
-	configFile := "cluster/nodes.json"
+	configFile := nodesFile(configs.Get())
 
 	file, err := os.Open(configFile)
 	if err != nil {
-		log.Fatalf("Failed to open nodes.json: %v", err)
+		fatal("opening nodes file failed", "file", configFile, "err", err)
 	}
 	defer file.Close()
 
 	bytes, err := ioutil.ReadAll(file)
 	if err != nil {
-		log.Fatalf("Failed to read nodes.json: %v", err)
+		fatal("reading nodes file failed", "file", configFile, "err", err)
 	}
 
 	if err := json.Unmarshal(bytes, &nodeConfig); err != nil {
-		log.Fatalf("Failed to parse node config: %v", err)
+		fatal("parsing nodes file failed", "file", configFile, "err", err)
 	}
 
-	clusterManager := manager.NewClusterManager(configFile)
+	clusterManager, err := manager.NewClusterManager(configFile, configs)
+	if err != nil {
+		fatal("creating the cluster manager failed", "err", err)
+	}
+	clusterManager.RegisterMetrics(metrics.Default)
+	running.Store(clusterManager)
 	nodeService := manager.NewNodeService(clusterManager)
 
 	for _, addr := range nodeConfig.Nodes {
@@ -111,13 +307,16 @@ func handleSet(svc *manager.NodeService) http.HandlerFunc {
 		data := make(map[string]string)
 		var ttl int64
 		for _, req := range requests {
+			if !authorize(w, r, acl.CmdSet, req.Key) {
+				return
+			}
 			data[req.Key] = req.Value
 			ttl = req.TTL
 		}
 
 		var resp bool
-		if err := svc.SetData(data, ttl, &resp); err != nil {
-			log.Printf("Set error: %v", err)
+		if err := svc.SetData(r.Context(), data, ttl, &resp); err != nil {
+			slog.Error("cluster set failed", "trace_id", trace.FromContext(r.Context()).TraceID(), "err", err)
 			sendError(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
@@ -141,10 +340,13 @@ func handleGet(svc *manager.NodeService) http.HandlerFunc {
 			sendError(w, "Key required", http.StatusBadRequest)
 			return
 		}
+		if !authorize(w, r, acl.CmdGet, key) {
+			return
+		}
 
 		var resp manager.RPCResponse
-		if err := svc.GetData(key, &resp); err != nil {
-			log.Printf("Get error: %v", err)
+		if err := svc.GetData(r.Context(), key, &resp); err != nil {
+			slog.Warn("cluster get failed", "key", key, "trace_id", trace.FromContext(r.Context()).TraceID(), "err", err)
 			sendError(w, err.Error(), http.StatusOK)
 			return
 		}
@@ -168,10 +370,25 @@ func handleDelete(svc *manager.NodeService) http.HandlerFunc {
 			sendError(w, "Key required", http.StatusBadRequest)
 			return
 		}
+		if !authorize(w, r, acl.CmdDelete, key) {
+			return
+		}
 
 		var resp bool
-		if err := svc.DeleteData(key, &resp); err != nil {
-			log.Printf("Delete error: %v", err)
+		err := svc.DeleteData(r.Context(), key, &resp)
+		event := audit.Event{
+			Principal: principal(r),
+			Source:    r.RemoteAddr,
+			Operation: audit.OpDelete,
+			Key:       key,
+			Outcome:   audit.OutcomeOK,
+		}
+		if err != nil {
+			event.Outcome, event.Detail = audit.OutcomeError, err.Error()
+		}
+		auditLog.Record(event)
+		if err != nil {
+			slog.Error("cluster delete failed", "key", key, "trace_id", trace.FromContext(r.Context()).TraceID(), "err", err)
 			sendError(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
@@ -184,6 +401,9 @@ func handleDelete(svc *manager.NodeService) http.HandlerFunc {
 
 func handleNodes(svc *manager.NodeService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorize(w, r, acl.CmdInfo, "") {
+			return
+		}
 		activeNodes := svc.ClusterManager.GetActiveNodes()
 		sendResponse(w, HTTPResponse{
 			Success: true,
@@ -192,12 +412,16 @@ func handleNodes(svc *manager.NodeService) http.HandlerFunc {
 	}
 }
 
-func handleAddNode(svc *manager.NodeService) http.HandlerFunc {
+// handleAddNode adds a node to the cluster and to the nodes file at path.
+func handleAddNode(svc *manager.NodeService, path string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
+		if !authorize(w, r, acl.CmdCluster, "") {
+			return
+		}
 
 		var request struct{ Address string }
 		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
@@ -205,8 +429,20 @@ func handleAddNode(svc *manager.NodeService) http.HandlerFunc {
 			return
 		}
 
-		if err := updateNodesJSON(request.Address); err != nil {
-			log.Printf("Add node error: %v", err)
+		err := updateNodesJSON(path, request.Address)
+		event := audit.Event{
+			Principal: principal(r),
+			Source:    r.RemoteAddr,
+			Operation: audit.OpAddNode,
+			Outcome:   audit.OutcomeOK,
+			Detail:    "node=" + request.Address,
+		}
+		if err != nil {
+			event.Outcome, event.Detail = audit.OutcomeError, event.Detail+": "+err.Error()
+		}
+		auditLog.Record(event)
+		if err != nil {
+			slog.Error("adding cluster node failed", "err", err)
 			sendError(w, "Failed to update cluster", http.StatusInternalServerError)
 			return
 		}
@@ -219,12 +455,13 @@ func handleAddNode(svc *manager.NodeService) http.HandlerFunc {
 	}
 }
 
-func updateNodesJSON(newNode string) error {
+// updateNodesJSON adds newNode to the nodes file at path, unless listed.
+func updateNodesJSON(path, newNode string) error {
 	nodesFileMutex.Lock()
 	defer nodesFileMutex.Unlock()
 
 	var nodeConfig NodeConfig
-	file, err := os.Open("cluster/nodes.json")
+	file, err := os.Open(path)
 	if err != nil {
 		return err
 	}
@@ -251,7 +488,7 @@ func updateNodesJSON(newNode string) error {
 		return err
 	}
 
-	return ioutil.WriteFile("cluster/nodes.json", newData, 0644)
+	return ioutil.WriteFile(path, newData, 0644)
 }
 
 func sendResponse(w http.ResponseWriter, resp HTTPResponse, status int) {
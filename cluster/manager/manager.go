@@ -1,18 +1,28 @@
 package manager
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"fmt"
 	"hash/crc32"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"net/rpc"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/shafigh75/Memorandum/config"
+	"github.com/shafigh75/Memorandum/server/trace"
+	"github.com/shafigh75/Memorandum/utils/metrics"
 )
 
+// nodeCalls counts RPC calls to cluster nodes.
+var nodeCalls = metrics.Default.NewCounterVec("memorandum_cluster_rpc_total",
+	"RPC calls to cluster nodes, by node, method and status (ok or error).", "node", "method", "status")
+
 type Node struct {
 	Address string
 	Active  bool
@@ -20,35 +30,145 @@ type Node struct {
 }
 
 type ClusterManager struct {
-	Nodes               []*Node
-	Mutex               sync.Mutex
-	HeartbeatInterval   time.Duration
-	configFile          string
-	LastModTime         time.Time
-	configCheckInterval time.Duration
+	Nodes       []*Node
+	Mutex       sync.Mutex
+	configFile  string
+	LastModTime time.Time
+	configs     *config.Manager // intervals and the auth token are read on use, so reloads apply
+	tlsConfig   *tls.Config     // TLS for connections to nodes, nil for plain TCP
+}
+
+// AuthRequest is the argument of RPCService.Auth.
+type AuthRequest struct {
+	Token string
 }
 
-func NewClusterManager(configFile string) *ClusterManager {
-	cfg, err := config.LoadConfig("config/config.json")
+// NewClusterManager creates a manager for the nodes listed in configFile.
+func NewClusterManager(configFile string, configs *config.Manager) (*ClusterManager, error) {
+	tlsConfig, err := configs.Get().ClientTLSConfig()
 	if err != nil {
-		log.Fatalf("Error loading config: %v", err)
+		return nil, fmt.Errorf("loading TLS config: %w", err)
 	}
 
 	return &ClusterManager{
-		Nodes:               make([]*Node, 0),
-		HeartbeatInterval:   time.Duration(cfg.HeartbeatInterval) * time.Second,
-		configFile:          configFile,
-		configCheckInterval: time.Duration(cfg.ConfigCheckInterval) * time.Second,
+		Nodes:      make([]*Node, 0),
+		configFile: configFile,
+		configs:    configs,
+		tlsConfig:  tlsConfig,
+	}, nil
+}
+
+// heartbeatInterval returns the current interval between node health checks.
+func (cm *ClusterManager) heartbeatInterval() time.Duration {
+	return cm.configs.Get().HeartbeatInterval.Duration()
+}
+
+// configCheckInterval returns the current interval between nodes file checks.
+func (cm *ClusterManager) configCheckInterval() time.Duration {
+	return cm.configs.Get().ConfigCheckInterval.Duration()
+}
+
+// authToken returns the RPC auth token of the nodes, or "" if auth is disabled.
+// Validate requires it when auth is enabled in cluster mode.
+func (cm *ClusterManager) authToken() string {
+	cfg := cm.configs.Get()
+	if !cfg.AuthEnabled {
+		return ""
+	}
+	return cfg.AuthToken
+}
+
+// RegisterMetrics registers a gauge of the status of each node.
+func (cm *ClusterManager) RegisterMetrics(r *metrics.Registry) {
+	r.NewGaugeVecFunc("memorandum_cluster_node_up", "Whether a cluster node passed its last health check (1) or not (0).",
+		[]string{"node"}, func(emit func(float64, ...string)) {
+			cm.Mutex.Lock()
+			defer cm.Mutex.Unlock()
+			for _, node := range cm.Nodes {
+				up := 0.0
+				if node.Active {
+					up = 1
+				}
+				emit(up, node.Address)
+			}
+		})
+}
+
+// call dials a node, calls an RPC method and closes the connection. Calls
+// are counted by node, method and outcome in the metrics, and timed as a
+// child of the span of ctx, whose trace an *RPCRequest carries to the node.
+func (cm *ClusterManager) call(ctx context.Context, address, method string, req, resp interface{}) error {
+	span := trace.StartClient(trace.FromContext(ctx), "rpc "+strings.TrimPrefix(method, "RPCService."))
+	span.SetAttr("server.address", address)
+	if r, ok := req.(*RPCRequest); ok {
+		r.TraceParent = span.Context().TraceParent()
+	}
+	client, err := cm.Dial(address)
+	if err == nil {
+		err = client.Call(method, req, resp)
+		client.Close()
+	}
+	status := "ok"
+	if err != nil {
+		status = "error"
+		span.SetError(err.Error())
+	}
+	span.End()
+	nodeCalls.With(address, strings.TrimPrefix(method, "RPCService."), status).Inc()
+	return err
+}
+
+// resetTicker applies a changed interval to a ticker.
+func resetTicker(ticker *time.Ticker, current *time.Duration, interval time.Duration) {
+	if interval > 0 && interval != *current {
+		ticker.Reset(interval)
+		*current = interval
 	}
 }
 
+// Dial opens an RPC connection to a node, over TLS if it is enabled, and
+// authenticates it when auth is enabled.
+func (cm *ClusterManager) Dial(address string) (*rpc.Client, error) {
+	var client *rpc.Client
+	if cm.tlsConfig == nil {
+		var err error
+		if client, err = rpc.Dial("tcp", address); err != nil {
+			return nil, err
+		}
+	} else {
+		conn, err := tls.Dial("tcp", address, cm.tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+		client = rpc.NewClient(conn)
+	}
+	authToken := cm.authToken()
+	if authToken == "" {
+		return client, nil
+	}
+
+	var resp struct {
+		Success bool
+		Error   string
+	}
+	if err := client.Call("RPCService.Auth", &AuthRequest{Token: authToken}, &resp); err != nil {
+		client.Close()
+		return nil, err
+	}
+	if !resp.Success {
+		client.Close()
+		return nil, fmt.Errorf("authenticating to %s: %s", address, resp.Error)
+	}
+	return client, nil
+}
+
 func (cm *ClusterManager) AddNode(address string) {
 	cm.Mutex.Lock()
 	defer cm.Mutex.Unlock()
 
 	for _, node := range cm.Nodes {
 		if node.Address == address {
-			log.Printf("Node updated: %s", address)
+			slog.Info("cluster node updated", "node", address)
 			cm.Nodes[node.Index] = &Node{
 				Address: address,
 				Active:  true,
@@ -64,7 +184,7 @@ func (cm *ClusterManager) AddNode(address string) {
 		Index:   len(cm.Nodes),
 	}
 	cm.Nodes = append(cm.Nodes, newNode)
-	log.Printf("Node added: %s", address)
+	slog.Info("cluster node added", "node", address)
 }
 
 func (cm *ClusterManager) RemoveNode(address string) {
@@ -78,22 +198,24 @@ func (cm *ClusterManager) RemoveNode(address string) {
 			for j := i; j < len(cm.Nodes); j++ {
 				cm.Nodes[j].Index = j
 			}
-			log.Printf("Node removed: %s", address)
+			slog.Info("cluster node removed", "node", address)
 			return
 		}
 	}
 }
 
 func (cm *ClusterManager) StartHealthCheck() {
-	ticker := time.NewTicker(cm.HeartbeatInterval)
+	interval := cm.heartbeatInterval()
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for range ticker.C {
+		resetTicker(ticker, &interval, cm.heartbeatInterval())
 		for _, node := range cm.Nodes {
 			if !cm.PingNode(node.Address) {
 				cm.Mutex.Lock()
 				node.Active = false
-				log.Printf("Node inactive: %s", node.Address)
+				slog.Warn("cluster node inactive", "node", node.Address)
 				cm.Mutex.Unlock()
 			}
 		}
@@ -101,14 +223,8 @@ func (cm *ClusterManager) StartHealthCheck() {
 }
 
 func (cm *ClusterManager) PingNode(address string) bool {
-	client, err := rpc.Dial("tcp", address)
-	if err != nil {
-		return false
-	}
-	defer client.Close()
-
 	var reply bool
-	err = client.Call("RPCService.Ping", struct{}{}, &reply)
+	err := cm.call(context.Background(), address, "RPCService.Ping", struct{}{}, &reply)
 	return err == nil && reply
 }
 
@@ -125,6 +241,18 @@ func (cm *ClusterManager) GetActiveNodes() []string {
 	return active
 }
 
+// Members returns a copy of every known node, active or not.
+func (cm *ClusterManager) Members() []Node {
+	cm.Mutex.Lock()
+	defer cm.Mutex.Unlock()
+
+	members := make([]Node, len(cm.Nodes))
+	for i, node := range cm.Nodes {
+		members[i] = *node
+	}
+	return members
+}
+
 func (cm *ClusterManager) GetNodes(key string, replicas int) []*Node {
 	cm.Mutex.Lock()
 	defer cm.Mutex.Unlock()
@@ -145,7 +273,7 @@ func (cm *ClusterManager) GetNodes(key string, replicas int) []*Node {
 	}
 	for i := 0; i <= replicas; i++ {
 		if len(active) == 0 {
-			log.Println("Error: There is no active nodes")
+			slog.Error("no active cluster nodes")
 			return nil
 		}
 		idx := (primaryIdx + i) % len(active)
@@ -157,10 +285,12 @@ func (cm *ClusterManager) GetNodes(key string, replicas int) []*Node {
 }
 
 func (cm *ClusterManager) StartConfigMonitor() {
-	ticker := time.NewTicker(cm.configCheckInterval)
+	interval := cm.configCheckInterval()
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for range ticker.C {
+		resetTicker(ticker, &interval, cm.configCheckInterval())
 		cm.syncWithConfig()
 	}
 }
@@ -168,7 +298,7 @@ func (cm *ClusterManager) StartConfigMonitor() {
 func (cm *ClusterManager) syncWithConfig() {
 	cm.Mutex.Lock()
 
-	log.Println("syncing cluster ...")
+	slog.Debug("syncing cluster nodes", "file", cm.configFile)
 	// 1. Check file modification time
 	fi, err := os.Stat(cm.configFile)
 	if err != nil || fi.ModTime().Before(cm.LastModTime) {
@@ -181,21 +311,21 @@ func (cm *ClusterManager) syncWithConfig() {
 	file, err := os.Open(cm.configFile)
 	if err != nil {
 		cm.Mutex.Unlock()
-		log.Printf("Error opening config: %v", err)
+		slog.Error("opening nodes file failed", "file", cm.configFile, "err", err)
 		return
 	}
 	bytes, err := ioutil.ReadAll(file)
 	file.Close()
 	if err != nil {
 		cm.Mutex.Unlock()
-		log.Printf("Error reading config: %v", err)
+		slog.Error("reading nodes file failed", "file", cm.configFile, "err", err)
 		return
 	}
 
 	var nodeConfig struct{ Nodes []string }
 	if err := json.Unmarshal(bytes, &nodeConfig); err != nil {
 		cm.Mutex.Unlock()
-		log.Printf("Error parsing config: %v", err)
+		slog.Error("parsing nodes file failed", "file", cm.configFile, "err", err)
 		return
 	}
 
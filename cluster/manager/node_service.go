@@ -1,11 +1,12 @@
 package manager
 
 import (
+	"context"
 	"fmt"
-	"log"
-	"net/rpc"
+	"log/slog"
 
 	"github.com/shafigh75/Memorandum/config"
+	"github.com/shafigh75/Memorandum/server/trace"
 )
 
 type NodeService struct {
@@ -17,9 +18,10 @@ func NewNodeService(cm *ClusterManager) *NodeService {
 }
 
 type RPCRequest struct {
-	Key   string
-	Value string
-	TTL   int64
+	Key         string
+	Value       string
+	TTL         int64
+	TraceParent string // W3C traceparent of the call, set by ClusterManager.call
 }
 
 type RPCResponse struct {
@@ -28,15 +30,14 @@ type RPCResponse struct {
 	Error   string
 }
 
+// GetConfig returns the current configuration snapshot.
 func (ns *NodeService) GetConfig() *config.Config {
-	cfg, err := config.LoadConfig("config/config.json")
-	if err != nil {
-		log.Fatalf("Error loading config: %v", err)
-	}
-	return cfg
+	return ns.ClusterManager.configs.Get()
 }
 
-func (ns *NodeService) SetData(data map[string]string, ttl int64, reply *bool) error {
+// SetData writes each key to its nodes. ctx carries the span of the request,
+// which the calls to the nodes continue.
+func (ns *NodeService) SetData(ctx context.Context, data map[string]string, ttl int64, reply *bool) error {
 	cfg := ns.GetConfig()
 	replica := cfg.ReplicaCount
 	for key, value := range data {
@@ -50,21 +51,13 @@ func (ns *NodeService) SetData(data map[string]string, ttl int64, reply *bool) e
 				continue
 			}
 
-			client, err := rpc.Dial("tcp", node.Address)
-			if err != nil {
-				log.Printf("RPC connection failed: %s - %v", node.Address, err)
-				continue
-			}
-
 			req := RPCRequest{Key: key, Value: value, TTL: ttl}
 			var resp RPCResponse
-			if err := client.Call("RPCService.RPCSet", &req, &resp); err != nil {
-				log.Printf("RPCSet failed: %s - %v", node.Address, err)
-				client.Close()
+			if err := ns.ClusterManager.call(ctx, node.Address, "RPCService.RPCSet", &req, &resp); err != nil {
+				slog.Warn("cluster RPCSet failed", "node", node.Address, "trace_id", trace.FromContext(ctx).TraceID(), "err", err)
 				continue
 			}
 
-			client.Close()
 			if !resp.Success {
 				return fmt.Errorf("node %s failed to set key", node.Address)
 			}
@@ -75,7 +68,8 @@ func (ns *NodeService) SetData(data map[string]string, ttl int64, reply *bool) e
 	return nil
 }
 
-func (ns *NodeService) GetData(key string, reply *RPCResponse) error {
+// GetData reads a key from the first of its nodes that has it.
+func (ns *NodeService) GetData(ctx context.Context, key string, reply *RPCResponse) error {
 	cfg := ns.GetConfig()
 	replica := cfg.ReplicaCount
 	nodes := ns.ClusterManager.GetNodes(key, replica)
@@ -88,21 +82,13 @@ func (ns *NodeService) GetData(key string, reply *RPCResponse) error {
 			continue
 		}
 
-		client, err := rpc.Dial("tcp", node.Address)
-		if err != nil {
-			log.Printf("RPC connection failed: %s - %v", node.Address, err)
-			continue
-		}
-
 		req := RPCRequest{Key: key}
 		var resp RPCResponse
-		if err := client.Call("RPCService.RPCGet", &req, &resp); err != nil {
-			log.Printf("RPCGet failed: %s - %v", node.Address, err)
-			client.Close()
+		if err := ns.ClusterManager.call(ctx, node.Address, "RPCService.RPCGet", &req, &resp); err != nil {
+			slog.Warn("cluster RPCGet failed", "node", node.Address, "trace_id", trace.FromContext(ctx).TraceID(), "err", err)
 			continue
 		}
 
-		client.Close()
 		if resp.Success {
 			*reply = resp
 			return nil
@@ -112,7 +98,8 @@ func (ns *NodeService) GetData(key string, reply *RPCResponse) error {
 	return fmt.Errorf("no key was found")
 }
 
-func (ns *NodeService) DeleteData(key string, reply *bool) error {
+// DeleteData removes a key from its nodes.
+func (ns *NodeService) DeleteData(ctx context.Context, key string, reply *bool) error {
 	cfg := ns.GetConfig()
 	replica := cfg.ReplicaCount
 	nodes := ns.ClusterManager.GetNodes(key, replica)
@@ -125,21 +112,13 @@ func (ns *NodeService) DeleteData(key string, reply *bool) error {
 			continue
 		}
 
-		client, err := rpc.Dial("tcp", node.Address)
-		if err != nil {
-			log.Printf("RPC connection failed: %s - %v", node.Address, err)
-			continue
-		}
-
 		req := RPCRequest{Key: key}
 		var resp RPCResponse
-		if err := client.Call("RPCService.RPCDelete", &req, &resp); err != nil {
-			log.Printf("RPCDelete failed: %s - %v", node.Address, err)
-			client.Close()
+		if err := ns.ClusterManager.call(ctx, node.Address, "RPCService.RPCDelete", &req, &resp); err != nil {
+			slog.Warn("cluster RPCDelete failed", "node", node.Address, "trace_id", trace.FromContext(ctx).TraceID(), "err", err)
 			continue
 		}
 
-		client.Close()
 		if resp.Success {
 			*reply = true
 			return nil
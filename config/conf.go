@@ -1,44 +1,159 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
-	"io/ioutil"
+	"errors"
+	"fmt"
 	"os"
+	"reflect"
+	"sort"
+	"strings"
 )
 
 // Config holds the configuration settings.
 type Config struct {
-	HTTPPort            string `json:"http_port"`            // port for http
-	RPCPort             string `json:"rpc_port"`             // port for rpc
-	ClusterPort         string `json:"cluster_port"`         // port for clustreing
-	CleanupInterval     int64  `json:"cleanup_interval"`     // memory cleanup interval in seconds
-	HeartbeatInterval   int64  `json:"heartbeat_interval"`   // check nodes health interval in seconds
-	ConfigCheckInterval int64  `json:"configCheck_interval"` // interval to re-add nodes in seconds
-	AuthEnabled         bool   `json:"auth_enabled"`         // set to true to enable auth
-	AuthToken           string `json:"auth_token"`           // Token for authentication
-	WalPath             string `json:"WAL_path"`             // path for wal.bin file
-	HttpLogPath         string `json:"http_log_path"`        // http log file path
-	RPCLogPath          string `json:"rpc_log_path"`         // rpc log file path
-	WalBufferSize       int    `json:"WAL_bufferSize"`       // buffer size for each wal flush
-	WalEnabled          bool   `json:"wal_enabled"`          // turn wal logging on or off
-	ClusterEnabled      bool   `json:"cluster_enabled"`      // turn wal logging on or off
-	WalFlushInterval    int    `json:"WAL_flushInterval"`    // wal flush interval in seconds
-	NumShards           int    `json:"shard_count"`          // number of node shards
-	ReplicaCount        int    `json:"replica_count"`        // number of nodes to replicate our data
+	HTTPPort             string       `json:"http_port"`             // port for http
+	RPCPort              string       `json:"rpc_port"`              // port for rpc
+	ClusterPort          string       `json:"cluster_port"`          // port for clustreing
+	CleanupInterval      Seconds      `json:"cleanup_interval"`      // memory cleanup interval
+	CleanupBatchSize     int          `json:"cleanup_batch_size"`    // expired keys removed per shard lock, 0 for the default
+	CleanupTimeBudget    Milliseconds `json:"cleanup_time_budget"`   // time a cleanup cycle may spend, 0 for the default
+	HeartbeatInterval    Seconds      `json:"heartbeat_interval"`    // check nodes health interval
+	ConfigCheckInterval  Seconds      `json:"configCheck_interval"`  // interval to re-add nodes
+	AuthEnabled          bool         `json:"auth_enabled"`          // set to true to enable auth
+	AuthToken            string       `json:"auth_token"`            // Token for authentication
+	ACLFile              string       `json:"acl_file"`              // JSON file of users and their permissions, see acl.Load
+	JWTSecret            string       `json:"jwt_secret"`            // HMAC secret of signed tokens (at least 32 bytes), empty to disable them
+	JWTIssuer            string       `json:"jwt_issuer"`            // iss claim required in signed tokens, empty to accept any
+	WalPath              string       `json:"WAL_path"`              // path for wal.bin file
+	HttpLogPath          string       `json:"http_log_path"`         // http log file path
+	RPCLogPath           string       `json:"rpc_log_path"`          // rpc log file path
+	AuditLogPath         string       `json:"audit_log_path"`        // hash-chained audit log of deletes, ACL changes and auth failures, empty to disable
+	AuditHashKeys        bool         `json:"audit_hash_keys"`       // record SHA-256 hashes of keys in the audit log instead of the keys
+	WalBufferSize        int          `json:"WAL_bufferSize"`        // buffer size for each wal flush
+	WalEnabled           bool         `json:"wal_enabled"`           // turn wal logging on or off
+	ClusterEnabled       bool         `json:"cluster_enabled"`       // turn wal logging on or off
+	NodesFile            string       `json:"nodes_file"`            // JSON list of cluster nodes, cluster/nodes.json if empty
+	WalFlushInterval     Seconds      `json:"WAL_flushInterval"`     // wal flush interval
+	NumShards            int          `json:"shard_count"`           // number of node shards
+	ReplicaCount         int          `json:"replica_count"`         // number of nodes to replicate our data
+	CompressionThreshold int          `json:"compression_threshold"` // compress values of at least this many bytes, 0 to disable
+	EncryptionKeyFile    string       `json:"encryption_key_file"`   // JSON key file for WAL and snapshot encryption, see db.LoadKeyring
+	TLSEnabled           bool         `json:"tls_enabled"`           // serve HTTP, RPC and cluster traffic over TLS
+	TLSCertFile          string       `json:"tls_cert_file"`         // PEM certificate of this node
+	TLSKeyFile           string       `json:"tls_key_file"`          // PEM private key of this node
+	TLSClientCAFile      string       `json:"tls_client_ca_file"`    // CA that client certificates must be signed by (mutual TLS)
+	TLSCAFile            string       `json:"tls_ca_file"`           // CA used to verify servers when connecting, system roots if empty
+	TLSServerName        string       `json:"tls_server_name"`       // name expected in server certificates when connecting
+	TLSMinVersion        string       `json:"tls_min_version"`       // "1.2" (default) or "1.3"
+	SlowlogThreshold     Milliseconds `json:"slowlog_threshold"`     // log operations taking at least this long, 0 for all, negative for none
+	SlowlogMaxLen        int          `json:"slowlog_max_len"`       // number of slow operations kept
+	HotKeysSampleRate    int          `json:"hotkeys_sample_rate"`   // count one access in this many to find hot keys, 0 to disable
+	KeyMetricsTop        int          `json:"key_metrics_top"`       // hottest and biggest keys exported as metrics by rank, 0 for none
+	LogLevel             string       `json:"log_level"`             // debug, info, warn or error
+	LogMaxSize           int          `json:"log_max_size"`          // rotate log files at this many megabytes, 0 for no limit
+	LogRotateInterval    Seconds      `json:"log_rotate_interval"`   // rotate log files at this age, 0 for no limit
+	LogMaxBackups        int          `json:"log_max_backups"`       // rotated log files kept, 0 to keep all
+	LogSampleRate        int          `json:"log_sample_rate"`       // log one request in this many, 0 for none
+	LogValues            bool         `json:"log_values"`            // write values in request logs instead of their size
+	TraceFile            string       `json:"trace_file"`            // append request spans as OTLP JSON lines, "" for none
+	TraceEndpoint        string       `json:"trace_endpoint"`        // OTLP/HTTP collector URL spans are posted to, e.g. http://localhost:4318/v1/traces
+	TraceSampleRate      int          `json:"trace_sample_rate"`     // export one trace in this many, 0 for only those sampled by clients
 }
 
-// LoadConfig reads the configuration from a JSON file.
+// LoadConfig reads the configuration from a JSON file. Omitted fields keep
+// their value from Default. Errors name the offending field or line.
 func LoadConfig(filePath string) (*Config, error) {
-	file, err := os.Open(filePath)
+	data, err := os.ReadFile(filePath)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
+	config := Default()
+	if err := config.parse(data); err != nil {
+		return nil, fmt.Errorf("%s: %w", filePath, err)
+	}
+	return config, nil
+}
 
-	byteValue, _ := ioutil.ReadAll(file)
-	var config Config
-	if err := json.Unmarshal(byteValue, &config); err != nil {
-		return nil, err
+// parse decodes the fields of a configuration file one by one, so that
+// unknown fields and bad values are reported by name.
+func (c *Config) parse(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			line := 1 + bytes.Count(data[:syntaxErr.Offset], []byte("\n"))
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		return err
+	}
+
+	names := make([]string, 0, len(raw))
+	for name := range raw {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fields := reflect.ValueOf(c).Elem()
+	for _, name := range names {
+		field, ok := fieldByName(fields, name)
+		if !ok {
+			if similar := closestField(name); similar != "" {
+				return fmt.Errorf("unknown field %q, did you mean %q?", name, similar)
+			}
+			return fmt.Errorf("unknown field %q", name)
+		}
+		if err := json.Unmarshal(raw[name], field.Addr().Interface()); err != nil {
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				return fmt.Errorf("%s: expected %s, got %s", name, typeErr.Type, typeErr.Value)
+			}
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// fieldByName returns the field of a Config value with the given JSON name.
+func fieldByName(config reflect.Value, name string) (reflect.Value, bool) {
+	for i := 0; i < config.NumField(); i++ {
+		if jsonName(config.Type().Field(i)) == name {
+			return config.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// closestField returns the field name within two edits of name, ignoring
+// case, or "" if there is none.
+func closestField(name string) string {
+	best, bestDistance := "", 3
+	for _, field := range Fields() {
+		if d := editDistance(strings.ToLower(name), strings.ToLower(field.Name)); d < bestDistance {
+			best, bestDistance = field.Name, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
 	}
-	return &config, nil
+	return prev[len(b)]
 }
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Seconds is a duration written in the configuration either as a string such
+// as "30s" or "1m30s", or, as in older files, as a number of seconds.
+type Seconds time.Duration
+
+// Milliseconds is like Seconds, but plain numbers are milliseconds.
+type Milliseconds time.Duration
+
+// Duration returns s as a time.Duration.
+func (s Seconds) Duration() time.Duration { return time.Duration(s) }
+
+// Duration returns m as a time.Duration.
+func (m Milliseconds) Duration() time.Duration { return time.Duration(m) }
+
+func (s *Seconds) UnmarshalJSON(data []byte) error {
+	d, err := unmarshalDuration(data, time.Second)
+	*s = Seconds(d)
+	return err
+}
+
+func (m *Milliseconds) UnmarshalJSON(data []byte) error {
+	d, err := unmarshalDuration(data, time.Millisecond)
+	*m = Milliseconds(d)
+	return err
+}
+
+func (s Seconds) MarshalJSON() ([]byte, error) {
+	return marshalDuration(time.Duration(s), time.Second)
+}
+
+func (m Milliseconds) MarshalJSON() ([]byte, error) {
+	return marshalDuration(time.Duration(m), time.Millisecond)
+}
+
+// UnmarshalText parses a flag or environment value.
+func (s *Seconds) UnmarshalText(text []byte) error {
+	d, err := parseDuration(string(text), time.Second)
+	*s = Seconds(d)
+	return err
+}
+
+// UnmarshalText parses a flag or environment value.
+func (m *Milliseconds) UnmarshalText(text []byte) error {
+	d, err := parseDuration(string(text), time.Millisecond)
+	*m = Milliseconds(d)
+	return err
+}
+
+func unmarshalDuration(data []byte, unit time.Duration) (time.Duration, error) {
+	if bytes.HasPrefix(data, []byte(`"`)) {
+		var text string
+		if err := json.Unmarshal(data, &text); err != nil {
+			return 0, err
+		}
+		return parseDuration(text, unit)
+	}
+	return parseDuration(string(data), unit)
+}
+
+// parseDuration parses a Go duration string, or a number of units.
+func parseDuration(text string, unit time.Duration) (time.Duration, error) {
+	if n, err := strconv.ParseFloat(text, 64); err == nil {
+		return time.Duration(n * float64(unit)), nil
+	}
+	d, err := time.ParseDuration(text)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q, use a number of %s or a string such as \"30s\"", text, unitName(unit))
+	}
+	return d, nil
+}
+
+// marshalDuration writes whole units as a number, so that rewriting a file
+// keeps its format, and anything else as a duration string.
+func marshalDuration(d, unit time.Duration) ([]byte, error) {
+	if d%unit == 0 {
+		return []byte(strconv.FormatInt(int64(d/unit), 10)), nil
+	}
+	return json.Marshal(d.String())
+}
+
+func unitName(unit time.Duration) string {
+	if unit == time.Millisecond {
+		return "milliseconds"
+	}
+	return "seconds"
+}
@@ -0,0 +1,208 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// hotReloadable lists the fields, by JSON name, that take effect when the
+// configuration is reloaded. Changes to other fields need a restart; a reload
+// keeps their previous values so that Get always returns the configuration in
+// effect.
+var hotReloadable = map[string]bool{
+	"cleanup_interval":     true,
+	"cleanup_batch_size":   true,
+	"cleanup_time_budget":  true,
+	"heartbeat_interval":   true,
+	"configCheck_interval": true,
+	"auth_token":           true,
+	"jwt_secret":           true,
+	"jwt_issuer":           true,
+	"replica_count":        true,
+	"http_log_path":        true,
+	"rpc_log_path":         true,
+	"audit_hash_keys":      true,
+	"slowlog_threshold":    true,
+	"slowlog_max_len":      true,
+	"hotkeys_sample_rate":  true,
+	"log_level":            true,
+	"log_max_size":         true,
+	"log_rotate_interval":  true,
+	"log_max_backups":      true,
+	"log_sample_rate":      true,
+	"log_values":           true,
+	"trace_sample_rate":    true,
+}
+
+// A Manager loads the configuration file once and reloads it on request or
+// when the file changes. Readers get an immutable snapshot with Get, and
+// subsystems that must react to changes register with Subscribe. It is safe
+// for concurrent use.
+type Manager struct {
+	path      string
+	overrides Overrides
+	current   atomic.Pointer[Config]
+
+	mu          sync.Mutex // serializes reloads and subscriptions
+	modTime     time.Time
+	subscribers []func(prev, next *Config)
+}
+
+// NewManager loads and validates the configuration file, applying the
+// environment and overrides as Load does, also on each reload.
+func NewManager(path string, overrides Overrides) (*Manager, error) {
+	m := &Manager{path: path, overrides: overrides}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := Load(path, overrides)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	m.modTime = info.ModTime()
+	m.current.Store(cfg)
+	return m, nil
+}
+
+// NewStaticManager returns a manager serving cfg that has no file to reload,
+// e.g. for tests and tools.
+func NewStaticManager(cfg *Config) *Manager {
+	m := &Manager{}
+	m.current.Store(cfg)
+	return m
+}
+
+// Get returns the current configuration. The snapshot is shared and must not
+// be modified.
+func (m *Manager) Get() *Config {
+	return m.current.Load()
+}
+
+// Subscribe registers fn to be called after each reload that changes the
+// configuration, with the previous and the new snapshot.
+func (m *Manager) Subscribe(fn func(prev, next *Config)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.subscribers = append(m.subscribers, fn)
+}
+
+// Reload reads the configuration file again. If it cannot be read or is
+// invalid, the current configuration stays in effect and the error is
+// returned. It reports whether the configuration changed.
+func (m *Manager) Reload() (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.path == "" {
+		return false, nil
+	}
+
+	info, err := os.Stat(m.path)
+	if err != nil {
+		return false, err
+	}
+	// Recorded before parsing, so Watch reports a broken file once rather
+	// than on every tick until it is fixed.
+	m.modTime = info.ModTime()
+	cfg, err := Load(m.path, m.overrides)
+	if err != nil {
+		return false, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return false, fmt.Errorf("%s: %w", m.path, err)
+	}
+
+	prev := m.current.Load()
+	if restart := keepRestartFields(prev, cfg); len(restart) > 0 {
+		slog.Warn("config reload: changes take effect after a restart", "fields", strings.Join(restart, ","))
+	}
+	if reflect.DeepEqual(prev, cfg) {
+		return false, nil
+	}
+	m.current.Store(cfg)
+	for _, fn := range m.subscribers {
+		fn(prev, cfg)
+	}
+	return true, nil
+}
+
+// Watch reloads the configuration whenever the file's modification time
+// changes, checking every interval until stop is closed. Errors are logged and
+// the current configuration is kept; a file that fails to load is not retried
+// until it changes again.
+func (m *Manager) Watch(interval time.Duration, stop <-chan struct{}) {
+	if m.path == "" {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		info, err := os.Stat(m.path)
+		if err != nil {
+			slog.Error("config watch failed", "err", err)
+			continue
+		}
+		m.mu.Lock()
+		modified := !info.ModTime().Equal(m.modTime)
+		m.mu.Unlock()
+		if !modified {
+			continue
+		}
+		if _, err := m.Reload(); err != nil {
+			slog.Error("config reload failed, keeping the current config", "err", err)
+		}
+	}
+}
+
+// ChangedFields returns the JSON names of the fields that differ between two
+// configurations.
+func ChangedFields(prev, next *Config) []string {
+	var changed []string
+	prevValue, nextValue := reflect.ValueOf(prev).Elem(), reflect.ValueOf(next).Elem()
+	for i := 0; i < prevValue.NumField(); i++ {
+		if !reflect.DeepEqual(prevValue.Field(i).Interface(), nextValue.Field(i).Interface()) {
+			changed = append(changed, jsonName(prevValue.Type().Field(i)))
+		}
+	}
+	return changed
+}
+
+// keepRestartFields copies the fields that cannot be reloaded from prev to
+// next and returns the JSON names of those that differed.
+func keepRestartFields(prev, next *Config) []string {
+	var kept []string
+	prevValue, nextValue := reflect.ValueOf(prev).Elem(), reflect.ValueOf(next).Elem()
+	for i := 0; i < prevValue.NumField(); i++ {
+		name := jsonName(prevValue.Type().Field(i))
+		if hotReloadable[name] {
+			continue
+		}
+		if !reflect.DeepEqual(prevValue.Field(i).Interface(), nextValue.Field(i).Interface()) {
+			kept = append(kept, name)
+			nextValue.Field(i).Set(prevValue.Field(i))
+		}
+	}
+	return kept
+}
+
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestManagerReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	write := func(data string) {
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"http_port": ":6060", "auth_token": "old", "replica_count": 1}`)
+	configs, err := NewManager(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var notified []string
+	configs.Subscribe(func(prev, next *Config) {
+		notified = ChangedFields(prev, next)
+	})
+
+	// Reloadable fields change, the port keeps its value until a restart.
+	write(`{"http_port": ":7070", "auth_token": "new", "replica_count": 1}`)
+	if changed, err := configs.Reload(); !changed || err != nil {
+		t.Fatalf("expected a change, got %v %v", changed, err)
+	}
+	if cfg := configs.Get(); cfg.AuthToken != "new" || cfg.HTTPPort != ":6060" {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+	if len(notified) != 1 || notified[0] != "auth_token" {
+		t.Fatalf("expected subscribers to see auth_token change, got %v", notified)
+	}
+
+	// A broken or invalid file keeps the current config.
+	for _, data := range []string{`{"auth_token": `, `{"replica_count": -1}`} {
+		write(data)
+		if _, err := configs.Reload(); err == nil {
+			t.Fatalf("expected an error for %s", data)
+		}
+		if configs.Get().AuthToken != "new" {
+			t.Fatal("expected the previous config to stay in effect")
+		}
+		// Watch must not report the same broken file on every tick.
+		if info, _ := os.Stat(path); !configs.modTime.Equal(info.ModTime()) {
+			t.Fatal("expected the failed file's modification time to be recorded")
+		}
+	}
+}
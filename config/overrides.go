@@ -0,0 +1,115 @@
+package config
+
+import (
+	"encoding"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// DefaultPath is the configuration file used when neither --config nor
+// $MEMORANDUM_CONFIG is set. It is relative to the working directory.
+const DefaultPath = "config/config.json"
+
+// EnvPrefix starts the environment variables that override configuration
+// fields: MEMORANDUM_ followed by the upper-cased JSON name, e.g.
+// MEMORANDUM_HTTP_PORT for http_port. MEMORANDUM_CONFIG names the file.
+const EnvPrefix = "MEMORANDUM_"
+
+// Path returns the configuration file to load: path if it is not empty, then
+// $MEMORANDUM_CONFIG, then DefaultPath.
+func Path(path string) string {
+	if path != "" {
+		return path
+	}
+	if env := os.Getenv(EnvPrefix + "CONFIG"); env != "" {
+		return env
+	}
+	return DefaultPath
+}
+
+// Overrides maps JSON field names to values that take precedence over both
+// the file and the environment, e.g. from command-line flags.
+type Overrides map[string]string
+
+// A Field describes a configuration setting and how to override it.
+type Field struct {
+	Name string // JSON name in the configuration file
+	Env  string // environment variable
+	Flag string // command-line flag, without the leading dashes
+	Bool bool   // the value is true or false
+}
+
+// Fields returns every configuration setting in file order.
+func Fields() []Field {
+	t := reflect.TypeOf(Config{})
+	fields := make([]Field, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name := jsonName(t.Field(i))
+		fields = append(fields, Field{
+			Name: name,
+			Env:  EnvPrefix + strings.ToUpper(name),
+			Flag: strings.ReplaceAll(strings.ToLower(name), "_", "-"),
+			Bool: t.Field(i).Type.Kind() == reflect.Bool,
+		})
+	}
+	return fields
+}
+
+// Load reads the configuration file and applies the environment variables
+// and then overrides, so that overrides win over the environment, which wins
+// over the file.
+func Load(path string, overrides Overrides) (*Config, error) {
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range Fields() {
+		if value, ok := os.LookupEnv(field.Env); ok {
+			if err := cfg.Set(field.Name, value); err != nil {
+				return nil, fmt.Errorf("%s: %w", field.Env, err)
+			}
+		}
+	}
+	for name, value := range overrides {
+		if err := cfg.Set(name, value); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+// Set parses value into the field with the given JSON name.
+func (c *Config) Set(name, value string) error {
+	field, ok := fieldByName(reflect.ValueOf(c).Elem(), name)
+	if !ok {
+		return fmt.Errorf("unknown config field %q", name)
+	}
+	if text, ok := field.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		if err := text.UnmarshalText([]byte(value)); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s: invalid integer %q", name, value)
+		}
+		field.SetInt(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s: invalid boolean %q", name, value)
+		}
+		field.SetBool(b)
+	default:
+		return fmt.Errorf("%s: unsupported type %s", name, field.Kind())
+	}
+	return nil
+}
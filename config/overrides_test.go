@@ -0,0 +1,34 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	data := `{"http_port": ":6060", "rpc_port": ":1234", "shard_count": 32, "wal_enabled": true}`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MEMORANDUM_HTTP_PORT", ":7070")
+	t.Setenv("MEMORANDUM_RPC_PORT", ":2345")
+	t.Setenv("MEMORANDUM_WAL_ENABLED", "false")
+
+	cfg, err := Load(path, Overrides{"rpc_port": ":3456", "shard_count": "8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.HTTPPort != ":7070" || cfg.RPCPort != ":3456" || cfg.NumShards != 8 || cfg.WalEnabled {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+
+	t.Setenv("MEMORANDUM_SHARD_COUNT", "many")
+	if _, err := Load(path, nil); err == nil {
+		t.Fatal("expected an error for an invalid environment value")
+	}
+	if _, err := Load(path, Overrides{"no_such_field": "1"}); err == nil {
+		t.Fatal("expected an error for an unknown field")
+	}
+}
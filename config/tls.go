@@ -0,0 +1,95 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// tlsVersions maps tls_min_version values to TLS versions.
+var tlsVersions = map[string]uint16{
+	"":    tls.VersionTLS12,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ServerTLSConfig returns the TLS configuration of the HTTP, RPC and cluster
+// listeners, or nil if TLS is disabled. When tls_client_ca_file is set,
+// clients must present a certificate signed by that CA (mutual TLS).
+func (c *Config) ServerTLSConfig() (*tls.Config, error) {
+	if !c.TLSEnabled {
+		return nil, nil
+	}
+	minVersion, err := c.tlsMinVersion()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   minVersion,
+	}
+	if c.TLSClientCAFile != "" {
+		if tlsConfig.ClientCAs, err = loadCertPool(c.TLSClientCAFile); err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// ClientTLSConfig returns the TLS configuration used to connect to Memorandum
+// servers (cluster nodes and the CLI), or nil if TLS is disabled. Servers are
+// verified against tls_ca_file, or the system roots if it is empty, and the
+// configured certificate is presented for mutual TLS.
+func (c *Config) ClientTLSConfig() (*tls.Config, error) {
+	if !c.TLSEnabled {
+		return nil, nil
+	}
+	minVersion, err := c.tlsMinVersion()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		MinVersion: minVersion,
+		ServerName: c.TLSServerName,
+	}
+	if c.TLSCAFile != "" {
+		if tlsConfig.RootCAs, err = loadCertPool(c.TLSCAFile); err != nil {
+			return nil, err
+		}
+	}
+	if c.TLSCertFile != "" && c.TLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading TLS certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+func (c *Config) tlsMinVersion() (uint16, error) {
+	version, ok := tlsVersions[c.TLSMinVersion]
+	if !ok {
+		return 0, fmt.Errorf("unsupported tls_min_version %q: use 1.2 or 1.3", c.TLSMinVersion)
+	}
+	return version, nil
+}
+
+// loadCertPool reads PEM certificates from a file.
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no PEM certificates found in %s", path)
+	}
+	return pool, nil
+}
@@ -0,0 +1,120 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert creates a certificate for 127.0.0.1 signed by parent (self-signed
+// if parent is nil) and writes it and its key as PEM files.
+func writeCert(t *testing.T, dir, name string, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(dir, name+".pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := writeCert(t, dir, "ca", true, nil, nil)
+	writeCert(t, dir, "node", false, ca, caKey)
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	cfg := &Config{
+		TLSEnabled:      true,
+		TLSCertFile:     path("node.pem"),
+		TLSKeyFile:      path("node.key"),
+		TLSClientCAFile: path("ca.pem"),
+		TLSCAFile:       path("ca.pem"),
+		TLSMinVersion:   "1.3",
+	}
+	serverConfig, err := cfg.ServerTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConfig, err := cfg.ClientTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), clientConfig)
+	if err != nil {
+		t.Fatalf("expected the mutual TLS handshake to succeed: %v", err)
+	}
+	if conn.ConnectionState().Version != tls.VersionTLS13 {
+		t.Fatal("expected TLS 1.3")
+	}
+	conn.Close()
+
+	// Without a client certificate the server rejects the connection.
+	anonymous := clientConfig.Clone()
+	anonymous.Certificates = nil
+	if conn, err := tls.Dial("tcp", listener.Addr().String(), anonymous); err == nil {
+		_, err = conn.Read(make([]byte, 1))
+		conn.Close()
+		if err == nil {
+			t.Fatal("expected a connection without a client certificate to be rejected")
+		}
+	}
+
+	if _, err := (&Config{TLSEnabled: true, TLSMinVersion: "1.0"}).ServerTLSConfig(); err == nil {
+		t.Fatal("expected an error for an unsupported minimum version")
+	}
+	if tlsConfig, err := (&Config{}).ServerTLSConfig(); tlsConfig != nil || err != nil {
+		t.Fatal("expected no TLS config when TLS is disabled")
+	}
+}
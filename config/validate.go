@@ -0,0 +1,138 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// minJWTSecretSize matches acl.MinJWTSecretSize.
+const minJWTSecretSize = 32
+
+// maxKeyReport matches db.MaxKeyReport.
+const maxKeyReport = 128
+
+// Default returns the configuration used for fields omitted from the file.
+func Default() *Config {
+	return &Config{
+		HTTPPort:            ":6060",
+		RPCPort:             ":1234",
+		ClusterPort:         ":5036",
+		CleanupInterval:     Seconds(10 * time.Second),
+		HeartbeatInterval:   Seconds(10 * time.Second),
+		ConfigCheckInterval: Seconds(10 * time.Second),
+		WalPath:             "data/wal.bin",
+		HttpLogPath:         "logs/http.log",
+		RPCLogPath:          "logs/rpc.log",
+		WalBufferSize:       4096,
+		WalFlushInterval:    Seconds(30 * time.Second),
+		NumShards:           32,
+		SlowlogThreshold:    Milliseconds(10 * time.Millisecond),
+		SlowlogMaxLen:       128,
+		HotKeysSampleRate:   16,
+		KeyMetricsTop:       10,
+		LogLevel:            "info",
+		LogMaxSize:          100,
+		LogRotateInterval:   Seconds(24 * time.Hour),
+		LogMaxBackups:       7,
+		LogSampleRate:       1,
+		TraceSampleRate:     1,
+	}
+}
+
+// Validate checks the configuration for values that cannot work. It reports
+// every problem found, each naming its field.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, field, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: "+format, append([]interface{}{field}, args...)...))
+		}
+	}
+
+	for _, field := range []struct {
+		name, addr string
+	}{
+		{"http_port", c.HTTPPort},
+		{"rpc_port", c.RPCPort},
+		{"cluster_port", c.ClusterPort},
+	} {
+		check(validAddress(field.addr), field.name, `invalid address %q, use ":6060" or "host:6060"`, field.addr)
+	}
+
+	for _, field := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"cleanup_interval", c.CleanupInterval.Duration()},
+		{"heartbeat_interval", c.HeartbeatInterval.Duration()},
+		{"configCheck_interval", c.ConfigCheckInterval.Duration()},
+		{"WAL_flushInterval", c.WalFlushInterval.Duration()},
+	} {
+		check(field.value > 0, field.name, "must be positive, got %v", field.value)
+	}
+	check(c.CleanupTimeBudget >= 0, "cleanup_time_budget", "must not be negative, got %v", c.CleanupTimeBudget.Duration())
+
+	check(c.NumShards >= 1, "shard_count", "must be at least 1, got %d", c.NumShards)
+	check(c.ReplicaCount >= 0, "replica_count", "must not be negative, got %d", c.ReplicaCount)
+	check(c.CleanupBatchSize >= 0, "cleanup_batch_size", "must not be negative, got %d", c.CleanupBatchSize)
+	check(c.CompressionThreshold >= 0, "compression_threshold", "must not be negative, got %d", c.CompressionThreshold)
+	check(c.WalBufferSize > 0, "WAL_bufferSize", "must be positive, got %d", c.WalBufferSize)
+	check(!c.WalEnabled || c.WalPath != "", "WAL_path", "must be set when wal_enabled is true")
+	check(c.HttpLogPath != "", "http_log_path", "must be set")
+	check(c.SlowlogMaxLen >= 1, "slowlog_max_len", "must be at least 1, got %d", c.SlowlogMaxLen)
+	check(c.HotKeysSampleRate >= 0, "hotkeys_sample_rate", "must not be negative, got %d", c.HotKeysSampleRate)
+	check(c.LogMaxSize >= 0, "log_max_size", "must not be negative, got %d", c.LogMaxSize)
+	check(c.LogRotateInterval >= 0, "log_rotate_interval", "must not be negative, got %v", c.LogRotateInterval.Duration())
+	check(c.LogMaxBackups >= 0, "log_max_backups", "must not be negative, got %d", c.LogMaxBackups)
+	check(c.LogSampleRate >= 0, "log_sample_rate", "must not be negative, got %d", c.LogSampleRate)
+	if _, err := c.logLevel(); err != nil {
+		errs = append(errs, err)
+	}
+	check(c.TraceSampleRate >= 0, "trace_sample_rate", "must not be negative, got %d", c.TraceSampleRate)
+	if c.TraceEndpoint != "" {
+		u, err := url.Parse(c.TraceEndpoint)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "trace_endpoint", "must be an http or https URL, got %q", c.TraceEndpoint)
+	}
+	check(c.KeyMetricsTop >= 0 && c.KeyMetricsTop <= maxKeyReport, "key_metrics_top", "must be between 0 and %d, got %d", maxKeyReport, c.KeyMetricsTop)
+
+	// The cluster logs in to the nodes with the shared token; ACL users are
+	// not used for that.
+	check(!c.ClusterEnabled || !c.AuthEnabled || c.AuthToken != "", "auth_token", "must be set when cluster_enabled and auth_enabled are true")
+	check(c.JWTSecret == "" || len(c.JWTSecret) >= minJWTSecretSize, "jwt_secret", "must be at least %d bytes", minJWTSecretSize)
+	check(!c.TLSEnabled || c.TLSCertFile != "", "tls_cert_file", "must be set when tls_enabled is true")
+	check(!c.TLSEnabled || c.TLSKeyFile != "", "tls_key_file", "must be set when tls_enabled is true")
+	if _, err := c.tlsMinVersion(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// SlogLevel returns the level named by log_level, Info if it is invalid.
+func (c *Config) SlogLevel() slog.Level {
+	level, _ := c.logLevel()
+	return level
+}
+
+func (c *Config) logLevel() (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		return slog.LevelInfo, fmt.Errorf("log_level: invalid level %q, use debug, info, warn or error", c.LogLevel)
+	}
+	return level, nil
+}
+
+// validAddress reports whether addr is a listen address such as ":6060" or
+// "127.0.0.1:6060".
+func validAddress(addr string) bool {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	n, err := strconv.Atoi(port)
+	return err == nil && n >= 0 && n <= 65535
+}
@@ -0,0 +1,53 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadAndValidate(t *testing.T) {
+	dir := t.TempDir()
+	load := func(data string) (*Config, error) {
+		path := filepath.Join(dir, "config.json")
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return LoadConfig(path)
+	}
+
+	// Omitted fields get defaults, durations accept numbers and strings.
+	cfg, err := load(`{"cleanup_interval": "1m30s", "heartbeat_interval": 5, "cleanup_time_budget": 50}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.CleanupInterval.Duration() != 90*time.Second || cfg.HeartbeatInterval.Duration() != 5*time.Second ||
+		cfg.CleanupTimeBudget.Duration() != 50*time.Millisecond || cfg.NumShards != 32 || cfg.HTTPPort != ":6060" {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected a valid config, got %v", err)
+	}
+
+	for data, want := range map[string]string{
+		`{"shard_cont": 8}`:             `did you mean "shard_count"`,
+		`{"shard_count": "8"}`:          "shard_count: expected int",
+		`{"heartbeat_interval": "10x"}`: "heartbeat_interval: invalid duration",
+		"{\n\"http_port\": \":1\",\n}":  "line 3",
+	} {
+		if _, err := load(data); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: expected an error containing %q, got %v", data, want, err)
+		}
+	}
+
+	cfg, _ = load(`{"shard_count": 0, "rpc_port": "1234", "cleanup_interval": -1, "wal_enabled": true, "WAL_path": "", "trace_endpoint": "localhost:4318",
+		"cluster_enabled": true, "auth_enabled": true}`)
+	err = cfg.Validate()
+	for _, field := range []string{"shard_count", "rpc_port", "cleanup_interval", "WAL_path", "trace_endpoint", "auth_token"} {
+		if err == nil || !strings.Contains(err.Error(), field+":") {
+			t.Errorf("expected an error for %s, got %v", field, err)
+		}
+	}
+}
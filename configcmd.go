@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/shafigh75/Memorandum/config"
+	"github.com/spf13/cobra"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config [command]",
+	Short: "Inspect the configuration",
+}
+
+var configCheckCmd = &cobra.Command{
+	Use:   "check [config-file]",
+	Short: "Validate the configuration",
+	Long: `Loads the configuration the way the server does, including environment
+variables and flags, and reports every invalid field. The file defaults to
+--config, then $MEMORANDUM_CONFIG, then config/config.json. Exits with a
+non-zero status if the configuration is invalid, for use in CI.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return configCheck(args)
+	},
+}
+
+func init() {
+	configCmd.AddCommand(configCheckCmd)
+	rootCmd.AddCommand(configCmd)
+}
+
+func configCheck(args []string) error {
+	path := config.Path(configPath)
+	if len(args) > 0 {
+		path = args[0]
+	}
+	cfg, err := config.Load(path, configOverrides)
+	if err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		var problems []error
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			problems = joined.Unwrap()
+		} else {
+			problems = []error{err}
+		}
+		for _, problem := range problems {
+			fmt.Println(Red+"  "+path+":"+Reset, problem)
+		}
+		return fmt.Errorf("%d invalid field(s) in %s", len(problems), path)
+	}
+	fmt.Println(Green + path + ": OK" + Reset)
+	return nil
+}
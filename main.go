@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -13,10 +14,18 @@ import (
 	"github.com/shafigh75/Memorandum/cluster"
 
 	"github.com/shafigh75/Memorandum/config"
+	"github.com/shafigh75/Memorandum/server/acl"
+	"github.com/shafigh75/Memorandum/server/audit"
 	"github.com/shafigh75/Memorandum/server/db"
 	httpHandler "github.com/shafigh75/Memorandum/server/http"
+	"github.com/shafigh75/Memorandum/server/info"
+	"github.com/shafigh75/Memorandum/server/monitor"
 	rpcHandler "github.com/shafigh75/Memorandum/server/rpc"
+	"github.com/shafigh75/Memorandum/server/slowlog"
+	"github.com/shafigh75/Memorandum/server/trace"
 	Logger "github.com/shafigh75/Memorandum/utils/logger"
+	"github.com/shafigh75/Memorandum/utils/metrics"
+	"github.com/spf13/cobra"
 )
 
 const (
@@ -40,80 +49,320 @@ func printBanner(name string) {
 	fmt.Println(starBorder + Reset)
 }
 
+var rootCmd = &cobra.Command{
+	Use:   "Memorandum",
+	Short: "Memorandum in-memory key-value store",
+	Long:  `Memorandum is a sharded in-memory key-value store. Run without a subcommand to start the server.`,
+	// errors are printed by main, usage only on request
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		collectConfigFlags(cmd)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		runServer()
+	},
+}
+
 func main() {
+	if err := rootCmd.Execute(); err != nil {
+		fmt.Println(Red+"Error:"+Reset, err)
+		os.Exit(1)
+	}
+}
+
+var (
+	configPath      string               // --config
+	configOverrides = config.Overrides{} // fields set with flags, see collectConfigFlags
+)
+
+func init() {
+	flags := rootCmd.PersistentFlags()
+	flags.StringVar(&configPath, "config", "", "configuration file (default $"+config.EnvPrefix+"CONFIG, then "+config.DefaultPath+")")
+	for _, field := range config.Fields() {
+		flags.String(field.Flag, "", "overrides "+field.Name+" and $"+field.Env)
+		if field.Bool {
+			flags.Lookup(field.Flag).NoOptDefVal = "true"
+		}
+	}
+}
+
+// collectConfigFlags records the configuration fields set with flags.
+func collectConfigFlags(cmd *cobra.Command) {
+	for _, field := range config.Fields() {
+		if flag := cmd.Flags().Lookup(field.Flag); flag != nil && flag.Changed {
+			configOverrides[field.Name] = flag.Value.String()
+		}
+	}
+}
+
+// loadConfig loads the configuration file with the environment and flags
+// applied on top.
+func loadConfig() (*config.Config, error) {
+	return config.Load(config.Path(configPath), configOverrides)
+}
+
+// loadACL loads the users allowed to access the server, or returns nil if
+// authentication is disabled.
+func loadACL(cfg *config.Config) (*acl.List, error) {
+	if !cfg.AuthEnabled {
+		return nil, nil
+	}
+	accessList, err := acl.Load(cfg.ACLFile, cfg.AuthToken)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.JWTSecret != "" {
+		if err := accessList.UseJWT([]byte(cfg.JWTSecret), cfg.JWTIssuer); err != nil {
+			return nil, err
+		}
+	}
+	return accessList, nil
+}
+
+// expireConfig returns the settings of the cleanup routine.
+func expireConfig(cfg *config.Config) db.ExpireConfig {
+	return db.ExpireConfig{
+		Interval:   cfg.CleanupInterval.Duration(),
+		BatchSize:  cfg.CleanupBatchSize,
+		TimeBudget: cfg.CleanupTimeBudget.Duration(),
+	}
+}
+
+// logOptions returns the settings of the loggers.
+func logOptions(cfg *config.Config) Logger.Options {
+	return Logger.Options{
+		Level:          cfg.SlogLevel(),
+		MaxSize:        int64(cfg.LogMaxSize) << 20,
+		RotateInterval: cfg.LogRotateInterval.Duration(),
+		MaxBackups:     cfg.LogMaxBackups,
+		SampleRate:     cfg.LogSampleRate,
+		LogValues:      cfg.LogValues,
+	}
+}
+
+// openTracer returns the exporter of request spans, or nil if neither
+// trace_file nor trace_endpoint is set. Spans name this node by its host and
+// RPC port.
+func openTracer(cfg *config.Config) (*trace.Tracer, error) {
+	if cfg.TraceFile == "" && cfg.TraceEndpoint == "" {
+		return nil, nil
+	}
+	host, _ := os.Hostname()
+	return trace.NewTracer(trace.Options{
+		File:       cfg.TraceFile,
+		Endpoint:   cfg.TraceEndpoint,
+		SampleRate: cfg.TraceSampleRate,
+		Instance:   host + cfg.RPCPort,
+	})
+}
+
+// subscribeConfig applies reloaded settings to the running servers. Intervals,
+// the replica count and the cluster auth token are read from the snapshot on
+// use and need no subscriber.
+func subscribeConfig(configs *config.Manager, store *db.ShardedInMemoryStore, accessList *acl.List, serverLogger, httpLogger, rpcLogger *Logger.Logger, auditLog *audit.Log, slow *slowlog.Log, tracer *trace.Tracer) {
+	configs.Subscribe(func(prev, next *config.Config) {
+		changed := config.ChangedFields(prev, next)
+		slog.Info("config reloaded", "changed", strings.Join(changed, ","))
+		auditLog.Record(audit.Event{
+			Principal: "system",
+			Operation: audit.OpReload,
+			Outcome:   audit.OutcomeOK,
+			Detail:    strings.Join(changed, ","),
+		})
+
+		if prev.CleanupInterval != next.CleanupInterval || prev.CleanupBatchSize != next.CleanupBatchSize ||
+			prev.CleanupTimeBudget != next.CleanupTimeBudget {
+			store.StartExpireRoutine(expireConfig(next))
+		}
+		if accessList != nil {
+			accessList.SetToken(next.AuthToken)
+			if err := accessList.UseJWT([]byte(next.JWTSecret), next.JWTIssuer); err != nil {
+				slog.Error("applying jwt_secret failed, signed tokens are disabled", "err", err)
+				accessList.UseJWT(nil, "")
+			}
+		}
+		if prev.HttpLogPath != next.HttpLogPath {
+			if err := httpLogger.Reopen(next.HttpLogPath); err != nil {
+				slog.Error("reopening the HTTP log failed", "path", next.HttpLogPath, "err", err)
+			}
+		}
+		if prev.RPCLogPath != next.RPCLogPath {
+			if err := rpcLogger.Reopen(next.RPCLogPath); err != nil {
+				slog.Error("reopening the RPC log failed", "path", next.RPCLogPath, "err", err)
+			}
+		}
+		for _, logger := range []*Logger.Logger{serverLogger, httpLogger, rpcLogger} {
+			logger.Configure(logOptions(next))
+		}
+		auditLog.SetHashKeys(next.AuditHashKeys)
+		slow.Configure(next.SlowlogThreshold.Duration(), next.SlowlogMaxLen)
+		store.UseHotKeys(next.HotKeysSampleRate)
+		tracer.SetSampleRate(next.TraceSampleRate)
+	})
+}
+
+// clusterNodes returns the members of the running cluster for INFO.
+func clusterNodes() []info.Node {
+	members := cluster.Members()
+	nodes := make([]info.Node, len(members))
+	for i, member := range members {
+		nodes[i] = info.Node{Address: member.Address, Active: member.Active}
+	}
+	return nodes
+}
+
+// runServer starts the HTTP, RPC and cluster servers and blocks until shutdown.
+func runServer() {
 	printBanner("Memorandum")
 	// Load configuration
-	confPath := "config/config.json"
-	config, err := config.LoadConfig(confPath)
+	configs, err := config.NewManager(config.Path(configPath), configOverrides)
 	if err != nil {
 		fmt.Println(Red+"Error loading config:"+Reset, err)
 		return
 	}
+	cfg := configs.Get()
+
+	// Server messages go to stderr, also those of packages using slog or log
+	serverLogger, _ := Logger.NewLogger("", logOptions(cfg))
+	slog.SetDefault(serverLogger.Slog())
 
-	store, err := db.LoadConfigAndCreateStore(confPath)
+	store, err := db.NewStoreFromConfig(cfg)
 	if err != nil {
-		fmt.Println(Red+"Error creating Store:"+Reset, err)
+		slog.Error("creating the store failed", "err", err)
 		return
 	}
 
-	httpLogPath := config.HttpLogPath
-	httpLogger, err := Logger.NewLogger(httpLogPath)
+	httpLogger, err := Logger.NewLogger(cfg.HttpLogPath, logOptions(cfg))
 	if err != nil {
-		fmt.Println(Red+"Error creating Logger:"+Reset, err)
+		slog.Error("opening the HTTP log failed", "path", cfg.HttpLogPath, "err", err)
 		return
 	}
+	defer httpLogger.Close()
 
 	// Start the cleanup routine based on the config
-	store.StartCleanupRoutine(time.Duration(config.CleanupInterval) * time.Second)
+	store.StartExpireRoutine(expireConfig(cfg))
+	store.RegisterMetrics(metrics.Default)
+	if cfg.KeyMetricsTop > 0 {
+		store.RegisterKeyMetrics(metrics.Default, cfg.KeyMetricsTop)
+	}
+
+	accessList, err := loadACL(cfg)
+	if err != nil {
+		slog.Error("loading the ACL failed", "err", err)
+		return
+	}
+
+	tlsConfig, err := cfg.ServerTLSConfig()
+	if err != nil {
+		slog.Error("loading the TLS config failed", "err", err)
+		return
+	}
+
+	var auditLog *audit.Log
+	if cfg.AuditLogPath != "" {
+		if auditLog, err = audit.Open(cfg.AuditLogPath, cfg.AuditHashKeys); err != nil {
+			slog.Error("opening the audit log failed", "path", cfg.AuditLogPath, "err", err)
+			return
+		}
+		defer auditLog.Close()
+	}
+
+	tracer, err := openTracer(cfg)
+	if err != nil {
+		slog.Error("opening the trace file failed", "path", cfg.TraceFile, "err", err)
+		return
+	}
+	defer tracer.Close()
 
 	// Create a new HTTP server
+	handler := httpHandler.NewHandler(store, httpLogger, accessList) // Use the handler created from the store
+	handler.Audit = auditLog
+	slow := slowlog.New(cfg.SlowlogThreshold.Duration(), cfg.SlowlogMaxLen)
+	handler.Slowlog = slow
+	hub := monitor.NewHub()
+	handler.Monitor = hub
+	handler.Tracer = tracer
+	var httpConnections info.ConnCounter
+	collector := info.NewCollector(store, configs)
+	collector.ConfigFile = config.Path(configPath)
+	collector.HTTPConnections = httpConnections.Count
+	collector.RPCConnections = rpcHandler.OpenConnections
+	if cfg.ClusterEnabled {
+		collector.ClusterNodes = clusterNodes
+	}
+	handler.Info = collector
 	httpServer := &http.Server{
-		Addr:    config.HTTPPort,
-		Handler: httpHandler.NewHandler(store, httpLogger), // Use the handler created from the store
+		Addr:      cfg.HTTPPort,
+		Handler:   handler,
+		TLSConfig: tlsConfig,
+		ConnState: httpConnections.ConnState,
 	}
 
 	// Start the HTTP server in a goroutine
 	go func() {
-		fmt.Println("Starting HTTP server on", config.HTTPPort)
-		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			fmt.Println(Red+"Error starting HTTP server:"+Reset, err)
+		var err error
+		slog.Info("starting HTTP server", "addr", cfg.HTTPPort, "tls", tlsConfig != nil)
+		if tlsConfig != nil {
+			err = httpServer.ListenAndServeTLS("", "")
+		} else {
+			err = httpServer.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			slog.Error("HTTP server failed", "err", err)
 		}
 	}()
 
 	// Start the RPC server in a goroutine
-	rpcLogPath := config.RPCLogPath
-	rpcLogger, err := Logger.NewLogger(rpcLogPath)
+	rpcLogger, err := Logger.NewLogger(cfg.RPCLogPath, logOptions(cfg))
 	if err != nil {
-		fmt.Println(Yellow + "logger is disabled ..." + Reset)
+		slog.Warn("opening the RPC log failed, RPC requests are not logged", "path", cfg.RPCLogPath, "err", err)
 	}
-	go rpcHandler.StartRPCServer(store, config.RPCPort, rpcLogger)
+	defer rpcLogger.Close()
+	go rpcHandler.StartRPCServer(store, cfg, accessList, auditLog, collector, slow, hub, tracer, rpcLogger, tlsConfig)
 
-	isClustered := config.ClusterEnabled
+	isClustered := cfg.ClusterEnabled
 	if isClustered {
-		fmt.Println(Red + "Running in cluster Mode, starting server ..." + Reset)
-		go cluster.StartHTTPServer(config.ClusterPort)
+		slog.Info("running in cluster mode")
+		go cluster.StartHTTPServer(configs, accessList, auditLog, slow, hub, tracer)
 	} else {
-		fmt.Println(Red + "Running as standalone server ... " + Reset)
+		slog.Info("running as a standalone server")
 	}
 
-	// Channel to listen for shutdown signals
+	// Reload the config on SIGHUP and when the file changes
+	subscribeConfig(configs, store, accessList, serverLogger, httpLogger, rpcLogger, auditLog, slow, tracer)
+	stopWatch := make(chan struct{})
+	defer close(stopWatch)
+	go configs.Watch(5*time.Second, stopWatch)
+
+	// Channel to listen for shutdown and reload signals
 	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
 	// Wait for a shutdown signal
-	<-signalChan
-	fmt.Println(Blue + "Shutdown signal received. Initiating graceful shutdown..." + Reset)
+	for sig := range signalChan {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		if _, err := configs.Reload(); err != nil {
+			slog.Error("reloading config failed, keeping the current one", "err", err)
+		}
+	}
+	slog.Info("shutdown signal received, shutting down")
 
 	// Create a context with a timeout for the shutdown process
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	// End MONITOR streams, which would otherwise keep their connections open
+	hub.Close()
+
 	// Shutdown the HTTP server gracefully
 	if err := httpServer.Shutdown(ctx); err != nil {
-		fmt.Println(Red+"Error shutting down HTTP server:"+Reset, err)
+		slog.Error("shutting down the HTTP server failed", "err", err)
 	}
 
 	// close the store gracefully
 	store.Close()
-	fmt.Println(Green + "Shutdown complete." + Reset)
+	slog.Info("shutdown complete")
 }
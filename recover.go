@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/shafigh75/Memorandum/config"
+	"github.com/shafigh75/Memorandum/server/acl"
+	"github.com/shafigh75/Memorandum/server/db"
+	httpHandler "github.com/shafigh75/Memorandum/server/http"
+	Logger "github.com/shafigh75/Memorandum/utils/logger"
+	"github.com/spf13/cobra"
+)
+
+var (
+	recoverWalPath  string
+	recoverSnapshot string
+	recoverUntil    string
+	recoverOffset   int64
+	recoverOut      string
+	recoverServe    string
+)
+
+var recoverCmd = &cobra.Command{
+	Use:   "recover",
+	Short: "Rebuild the store as of a point in time from the WAL",
+	Long: `Replays the WAL (optionally on top of a base snapshot) up to a timestamp or
+WAL byte offset. The recovered state can be written to a new snapshot file
+and/or served read-only over HTTP for inspection. The served state stays as
+of the recovery target: keys alive then do not expire. A base snapshot is refused
+if the WAL was rewritten since it was taken, e.g. by wal filter or reencrypt.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runRecover()
+	},
+}
+
+func init() {
+	recoverCmd.Flags().StringVar(&recoverWalPath, "wal", "", "WAL file to replay (defaults to WAL_path from config)")
+	recoverCmd.Flags().StringVar(&recoverSnapshot, "snapshot", "", "base snapshot to start from")
+	recoverCmd.Flags().StringVar(&recoverUntil, "until", "", "recover up to this time (RFC3339 or Unix seconds)")
+	recoverCmd.Flags().Int64Var(&recoverOffset, "offset", 0, "recover up to this WAL byte offset")
+	recoverCmd.Flags().StringVar(&recoverOut, "out", "", "write the recovered state to this snapshot file")
+	recoverCmd.Flags().StringVar(&recoverServe, "serve", "", "serve the recovered state read-only on this HTTP address (e.g. :6061)")
+	rootCmd.AddCommand(recoverCmd)
+}
+
+// parseTimestamp accepts either an RFC3339 time or Unix seconds and returns
+// Unix milliseconds.
+func parseTimestamp(value string) (int64, error) {
+	if unix, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return unix * 1000, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timestamp %q: use RFC3339 or Unix seconds", value)
+	}
+	return t.UnixMilli(), nil
+}
+
+func runRecover() error {
+	if recoverOut == "" && recoverServe == "" {
+		return fmt.Errorf("nothing to do: use --out and/or --serve")
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	walPath := recoverWalPath
+	if walPath == "" {
+		walPath = cfg.WalPath
+	}
+
+	var target db.RecoveryTarget
+	if recoverUntil != "" {
+		if target.Until, err = parseTimestamp(recoverUntil); err != nil {
+			return err
+		}
+	}
+	target.Offset = recoverOffset
+
+	// The recovered store never writes back to the WAL.
+	store := db.NewShardedInMemoryStore(cfg.NumShards, &db.DummyWAL{})
+	keys, err := db.LoadKeyring(cfg.EncryptionKeyFile)
+	if err != nil {
+		return err
+	}
+	store.UseKeyring(keys)
+	header, err := store.RecoverPointInTime(walPath, recoverSnapshot, target)
+	if err != nil {
+		return err
+	}
+	fmt.Printf(Green+"Recovered state as of %s (WAL offset %d)"+Reset+"\n",
+		formatMillis(header.Timestamp), header.WALOffset)
+
+	if recoverOut != "" {
+		if err := store.WriteSnapshot(recoverOut, header); err != nil {
+			return err
+		}
+		fmt.Println("Snapshot written to", recoverOut)
+	}
+
+	if recoverServe != "" {
+		return serveReadOnly(store, header, cfg, recoverServe)
+	}
+	return nil
+}
+
+// readOnlyHandler returns an HTTP handler that serves the recovered store as
+// of the header's time: the store's clock is stopped there, so keys alive at
+// the recovery target are neither reported missing nor deleted because they
+// expired since.
+func readOnlyHandler(store *db.ShardedInMemoryStore, header db.SnapshotHeader, logger *Logger.Logger, accessList *acl.List) *httpHandler.Handler {
+	store.UseClock(db.FixedClock(time.UnixMilli(header.Timestamp)))
+	handler := httpHandler.NewHandler(store, logger, accessList)
+	handler.ReadOnly = true
+	return handler
+}
+
+// serveReadOnly exposes the recovered store over HTTP until interrupted.
+func serveReadOnly(store *db.ShardedInMemoryStore, header db.SnapshotHeader, cfg *config.Config, addr string) error {
+	httpLogger, err := Logger.NewLogger(cfg.HttpLogPath, logOptions(cfg))
+	if err != nil {
+		return err
+	}
+	defer httpLogger.Close()
+
+	accessList, err := loadACL(cfg)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Addr: addr, Handler: readOnlyHandler(store, header, httpLogger, accessList)}
+
+	go func() {
+		fmt.Println("Serving recovered state read-only on", addr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			fmt.Println(Red+"Error starting HTTP server:"+Reset, err)
+		}
+	}()
+
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+	<-signalChan
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return server.Shutdown(ctx)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shafigh75/Memorandum/server/db"
+	"github.com/shafigh75/Memorandum/server/db/dbtest"
+	httpHandler "github.com/shafigh75/Memorandum/server/http"
+)
+
+func TestServeReadOnlyAsOfTarget(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal.bin")
+	wal, err := db.NewWAL(path, 16, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().Add(-2 * time.Hour)
+	clock := dbtest.NewFakeClock(start)
+	store := db.NewShardedInMemoryStore(4, wal)
+	store.UseClock(clock)
+	store.Set("session", "alice", 60) // expired an hour and more ago
+	clock.Advance(time.Minute)
+	store.Set("later", "bob", 0)
+	if err := wal.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	recovered := db.NewShardedInMemoryStore(4, &db.DummyWAL{})
+	target := db.RecoveryTarget{Until: start.Add(time.Second).UnixMilli()}
+	header, err := recovered.RecoverPointInTime(path, "", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := readOnlyHandler(recovered, header, nil, nil)
+
+	for _, tc := range []struct {
+		key  string
+		want string
+	}{
+		{key: "session", want: "alice"},
+		{key: "session", want: "alice"}, // not deleted by the first read
+		{key: "later"},
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/get?key="+tc.key, nil))
+		var resp httpHandler.APIResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := resp.Data.(string); got != tc.want {
+			t.Errorf("expected %s to be %q as of the target, got %+v", tc.key, tc.want, resp)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/set", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected writes to be rejected, got %d", rec.Code)
+	}
+}
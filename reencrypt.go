@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/shafigh75/Memorandum/server/db"
+	"github.com/spf13/cobra"
+)
+
+var (
+	reencryptOut     string
+	reencryptKeyFile string
+	reencryptDecrypt bool
+)
+
+var reencryptCmd = &cobra.Command{
+	Use:   "reencrypt <wal-or-snapshot-file>",
+	Short: "Rewrite a WAL or snapshot file with the active encryption key",
+	Long: `Decrypts every record of a WAL or snapshot file with any key of the keyring
+and encrypts it again with the active key, e.g. after a key rotation or to
+encrypt a WAL written before encryption was enabled. Run it while the server
+is stopped. The file is rewritten in place unless --out is given.
+
+Re-encrypting a WAL changes the offsets of its records, so snapshots taken
+against it before cannot be used as a recover --snapshot base with the new
+file; recover rejects them. Write a new snapshot with recover --out, or
+re-encrypt snapshots, which keeps them usable with their WAL.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runReencrypt(args[0])
+	},
+}
+
+func init() {
+	reencryptCmd.Flags().StringVar(&reencryptOut, "out", "", "write the result to this file instead of rewriting the input")
+	reencryptCmd.Flags().StringVar(&reencryptKeyFile, "key-file", "", "key file to use (defaults to encryption_key_file from config, then $"+db.EncryptionKeyEnv+")")
+	reencryptCmd.Flags().BoolVar(&reencryptDecrypt, "decrypt", false, "write every record in plaintext instead")
+	rootCmd.AddCommand(reencryptCmd)
+}
+
+// loadKeyring returns the keyring configured for the server, or nil if
+// encryption is not configured. A missing config file is not an error, so the
+// offline tools also work with only the environment variable set.
+func loadKeyring(keyFile string) (*db.Keyring, error) {
+	if keyFile == "" {
+		if cfg, err := loadConfig(); err == nil {
+			keyFile = cfg.EncryptionKeyFile
+		}
+	}
+	return db.LoadKeyring(keyFile)
+}
+
+func runReencrypt(path string) error {
+	keys, err := loadKeyring(reencryptKeyFile)
+	if err != nil {
+		return err
+	}
+	write := keys
+	if reencryptDecrypt {
+		write = nil
+	} else if keys == nil {
+		return fmt.Errorf("no encryption key configured: set encryption_key_file, --key-file or $%s", db.EncryptionKeyEnv)
+	}
+
+	out := reencryptOut
+	if out == "" {
+		out = path
+	}
+	count, err := db.ReencryptFile(path, out, keys, write)
+	if err != nil {
+		return err
+	}
+	if write == nil {
+		fmt.Printf(Green+"Decrypted %d records into %s"+Reset+"\n", count, out)
+	} else {
+		fmt.Printf(Green+"Encrypted %d records with key %q into %s"+Reset+"\n", count, write.ActiveKeyID(), out)
+	}
+	return nil
+}
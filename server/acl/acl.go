@@ -0,0 +1,415 @@
+// Package acl implements the users and permissions that restrict what each
+// client of the HTTP, RPC and cluster interfaces may do.
+package acl
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Commands checked by Can. Related operations share a command, e.g.
+// CmdExpire covers expire, pexpire, expireat, pexpireat and persist.
+const (
+	CmdGet     = "get"     // get, including binary values
+	CmdTTL     = "ttl"     // ttl and pttl
+	CmdSet     = "set"     // set, pset and binary set
+	CmdDelete  = "delete"  // delete
+	CmdExpire  = "expire"  // change the expiration of a key
+	CmdGetEx   = "getex"   // getex and pgetex, which read and reset the expiration
+	CmdACL     = "acl"     // manage users
+	CmdCluster = "cluster" // add cluster nodes
+	CmdMetrics = "metrics" // read server metrics
+	CmdInfo    = "info"    // read server, keyspace and cluster information
+	CmdSlowlog = "slowlog" // read and reset the slow log
+	CmdMonitor = "monitor" // watch the commands served
+	CmdHotKeys = "hotkeys" // list the most accessed keys
+	CmdBigKeys = "bigkeys" // list the largest keys
+)
+
+// Command categories, which can be used in User.Commands in place of the
+// commands they contain.
+var categories = map[string][]string{
+	"@read":  {CmdGet, CmdTTL},
+	"@write": {CmdSet, CmdDelete, CmdExpire, CmdGetEx},
+	"@admin": {CmdACL, CmdCluster, CmdMetrics, CmdInfo, CmdSlowlog, CmdMonitor, CmdHotKeys, CmdBigKeys},
+}
+
+// Roles and the command categories they grant.
+const (
+	RoleReadOnly  = "read-only"
+	RoleReadWrite = "read-write"
+	RoleAdmin     = "admin"
+)
+
+var roles = map[string][]string{
+	RoleReadOnly:  {"@read"},
+	RoleReadWrite: {"@read", "@write"},
+	RoleAdmin:     {"@read", "@write", "@admin"},
+}
+
+// ErrInvalidCredentials is returned when authentication fails.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// A User is a named client and its permissions. The role sets the commands a
+// user may run; Commands can narrow them further and KeyPatterns restricts the
+// keys they apply to.
+type User struct {
+	Name         string   `json:"name"`
+	PasswordHash string   `json:"password_hash,omitempty"`
+	Role         string   `json:"role"`
+	Commands     []string `json:"commands,omitempty"`     // commands or @categories, all of the role's if empty
+	KeyPatterns  []string `json:"key_patterns,omitempty"` // glob patterns of allowed keys, all keys if empty
+
+	// Users authenticated with a signed token have grants from its scopes
+	// instead of a role, and expire with the token.
+	grants    []grant
+	expiresAt int64
+}
+
+// TokenUser is the user of clients that authenticate with the shared
+// auth_token. It has the admin role.
+var TokenUser = &User{Name: "token", Role: RoleAdmin}
+
+// Can reports whether the user may run command on key. Admin commands do not
+// act on a key and ignore KeyPatterns. A nil user, used when authentication
+// is disabled, may do anything.
+func (u *User) Can(command, key string) bool {
+	if u == nil {
+		return true
+	}
+	if u.grants != nil {
+		for _, g := range u.grants {
+			if contains(g.commands, command) && (contains(categories["@admin"], command) || matchPattern(g.pattern, key)) {
+				return true
+			}
+		}
+		return false
+	}
+	if !contains(expand(roles[u.Role]), command) {
+		return false
+	}
+	if len(u.Commands) > 0 && !contains(expand(u.Commands), command) {
+		return false
+	}
+	if len(u.KeyPatterns) == 0 || contains(categories["@admin"], command) {
+		return true
+	}
+	for _, pattern := range u.KeyPatterns {
+		if matchPattern(pattern, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks the role, commands and key patterns of a user.
+func (u *User) validate() error {
+	if u.Name == "" || strings.ContainsAny(u.Name, ": \t\n") {
+		return fmt.Errorf("invalid user name %q", u.Name)
+	}
+	if _, ok := roles[u.Role]; !ok {
+		return fmt.Errorf("user %s: unknown role %q: use %s, %s or %s", u.Name, u.Role, RoleReadOnly, RoleReadWrite, RoleAdmin)
+	}
+	known := expand([]string{"@read", "@write", "@admin"})
+	for _, command := range u.Commands {
+		if _, ok := categories[command]; !ok && !contains(known, command) {
+			return fmt.Errorf("user %s: unknown command %q", u.Name, command)
+		}
+	}
+	for _, pattern := range u.KeyPatterns {
+		if pattern == "" {
+			return fmt.Errorf("user %s: empty key pattern", u.Name)
+		}
+	}
+	return checkPasswordHash(u.PasswordHash)
+}
+
+// expand replaces categories by their commands.
+func expand(commands []string) []string {
+	var expanded []string
+	for _, command := range commands {
+		if members, ok := categories[command]; ok {
+			expanded = append(expanded, members...)
+		} else {
+			expanded = append(expanded, command)
+		}
+	}
+	return expanded
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPattern reports whether key matches a glob pattern where '*' matches
+// any sequence of characters, including '/', and '?' matches one character.
+func matchPattern(pattern, key string) bool {
+	p, k := 0, 0
+	star, starKey := -1, 0
+	for k < len(key) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == key[k]):
+			p++
+			k++
+		case p < len(pattern) && pattern[p] == '*':
+			star, starKey = p, k
+			p++
+		case star >= 0:
+			// Let the last '*' consume one more character.
+			starKey++
+			p, k = star+1, starKey
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// A List holds the users allowed to access the server and the shared token.
+// It is safe for concurrent use.
+type List struct {
+	path string // ACL file, empty to keep users in memory only
+
+	mu    sync.RWMutex
+	token string       // shared auth_token granting TokenUser, empty to disable it
+	jwt   *jwtVerifier // set by UseJWT
+	users map[string]*User
+	// verified caches a digest of the last password that matched each user's
+	// hash, so that PBKDF2 runs once per password rather than per request.
+	verified map[string][sha256.Size]byte
+}
+
+// aclFile is the JSON format of the ACL file.
+type aclFile struct {
+	Users []*User `json:"users"`
+}
+
+// Load reads the users from an ACL file. A missing file is an empty list; it
+// is created when the first user is added. The shared token, if not empty,
+// also authenticates clients as TokenUser.
+func Load(path, token string) (*List, error) {
+	l := &List{
+		path:     path,
+		token:    token,
+		users:    make(map[string]*User),
+		verified: make(map[string][sha256.Size]byte),
+	}
+	if path == "" {
+		return l, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var file aclFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("ACL file %s: %w", path, err)
+	}
+	for _, user := range file.Users {
+		if err := user.validate(); err != nil {
+			return nil, fmt.Errorf("ACL file %s: %w", path, err)
+		}
+		l.users[user.Name] = user
+	}
+	return l, nil
+}
+
+// Authenticate returns the user with the given name and password. An empty
+// name authenticates the password as the shared token or, if UseJWT was
+// called, as a signed token.
+func (l *List) Authenticate(name, password string) (*User, error) {
+	if name == "" {
+		l.mu.RLock()
+		token, jwt := l.token, l.jwt
+		l.mu.RUnlock()
+		if token != "" && subtle.ConstantTimeCompare([]byte(password), []byte(token)) == 1 {
+			return TokenUser, nil
+		}
+		if jwt != nil && looksLikeJWT(password) {
+			return jwt.verify(password, time.Now())
+		}
+		return nil, ErrInvalidCredentials
+	}
+
+	digest := sha256.Sum256([]byte(password))
+	l.mu.RLock()
+	user, ok := l.users[name]
+	cached, isCached := l.verified[name]
+	l.mu.RUnlock()
+	if !ok {
+		verifyPassword(dummyPasswordHash, password)
+		return nil, ErrInvalidCredentials
+	}
+	if isCached && subtle.ConstantTimeCompare(cached[:], digest[:]) == 1 {
+		return user, nil
+	}
+	if !verifyPassword(user.PasswordHash, password) {
+		return nil, ErrInvalidCredentials
+	}
+	l.mu.Lock()
+	if l.users[name] == user { // not replaced while the hash was computed
+		l.verified[name] = digest
+	}
+	l.mu.Unlock()
+	return user, nil
+}
+
+// AuthenticateRequest authenticates an HTTP request with either the shared
+// token or a signed token ("Authorization: Bearer <token>"), or a user's
+// credentials (basic auth).
+func (l *List) AuthenticateRequest(r *http.Request) (*User, error) {
+	if name, password, ok := r.BasicAuth(); ok {
+		if name == "" {
+			return nil, ErrInvalidCredentials
+		}
+		return l.Authenticate(name, password)
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	return l.Authenticate("", token)
+}
+
+// SetToken replaces the shared token, e.g. when the configuration is
+// reloaded. An empty token disables it.
+func (l *List) SetToken(token string) {
+	l.mu.Lock()
+	l.token = token
+	l.mu.Unlock()
+}
+
+// SetUser adds or replaces a user and saves the ACL file. The password is
+// hashed; it may be empty when replacing a user to keep its password.
+func (l *List) SetUser(user User, password string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if password != "" {
+		hash, err := HashPassword(password)
+		if err != nil {
+			return err
+		}
+		user.PasswordHash = hash
+	} else if existing, ok := l.users[user.Name]; ok {
+		user.PasswordHash = existing.PasswordHash
+	} else {
+		return fmt.Errorf("user %s: a password is required", user.Name)
+	}
+	if err := user.validate(); err != nil {
+		return err
+	}
+
+	previous, existed := l.users[user.Name]
+	l.users[user.Name] = &user
+	delete(l.verified, user.Name)
+	if err := l.save(); err != nil {
+		if existed {
+			l.users[user.Name] = previous
+		} else {
+			delete(l.users, user.Name)
+		}
+		return err
+	}
+	return nil
+}
+
+// DeleteUser removes a user and saves the ACL file. It reports whether the
+// user existed.
+func (l *List) DeleteUser(name string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	user, ok := l.users[name]
+	if !ok {
+		return false, nil
+	}
+	delete(l.users, name)
+	delete(l.verified, name)
+	if err := l.save(); err != nil {
+		l.users[name] = user
+		return false, err
+	}
+	return true, nil
+}
+
+// Users returns the users sorted by name, without their password hashes.
+func (l *List) Users() []User {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	users := make([]User, 0, len(l.users))
+	for _, user := range l.users {
+		u := *user
+		u.PasswordHash = ""
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+	return users
+}
+
+// save writes the users to the ACL file, readable only by the owner, through a
+// temporary file so that a crash never leaves a partial file. l.mu must be held.
+func (l *List) save() error {
+	if l.path == "" {
+		return nil
+	}
+	file := aclFile{Users: make([]*User, 0, len(l.users))}
+	for _, user := range l.users {
+		file.Users = append(file.Users, user)
+	}
+	sort.Slice(file.Users, func(i, j int) bool { return file.Users[i].Name < file.Users[j].Name })
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), filepath.Base(l.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), l.path)
+}
+
+type contextKey struct{}
+
+// WithUser returns a copy of ctx carrying the authenticated user.
+func WithUser(ctx context.Context, user *User) context.Context {
+	return context.WithValue(ctx, contextKey{}, user)
+}
+
+// UserFromContext returns the user stored by WithUser, or nil.
+func UserFromContext(ctx context.Context) *User {
+	user, _ := ctx.Value(contextKey{}).(*User)
+	return user
+}
@@ -0,0 +1,187 @@
+package acl
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MinJWTSecretSize is the minimum length of the HMAC secret of signed tokens.
+const MinJWTSecretSize = 32
+
+// jwtLeeway is the clock skew tolerated when checking exp and nbf.
+const jwtLeeway = 30 * time.Second
+
+// jwtHeader is the header of the tokens produced by SignToken.
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+var (
+	// ErrInvalidToken is returned for signed tokens that are malformed, use
+	// another algorithm, have a bad signature or come from another issuer.
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrTokenExpired is returned for signed tokens past their exp claim.
+	ErrTokenExpired = errors.New("token expired")
+)
+
+// TokenClaims are the claims of a signed token. Scope is a space-separated
+// list of "<access>:<key pattern>" scopes, where access is read, write, admin
+// or a command name and the pattern defaults to "*", e.g.
+// "read:builds/* write:builds/ci/*".
+type TokenClaims struct {
+	Subject   string `json:"sub,omitempty"`
+	Issuer    string `json:"iss,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	NotBefore int64  `json:"nbf,omitempty"`
+	ExpiresAt int64  `json:"exp"` // Unix seconds, required
+	Scope     string `json:"scope"`
+}
+
+// A grant allows a set of commands on keys matching a pattern.
+type grant struct {
+	commands []string
+	pattern  string
+}
+
+// scopeAccess maps the access part of a scope to command categories.
+var scopeAccess = map[string]string{
+	"read":  "@read",
+	"write": "@write",
+	"admin": "@admin",
+}
+
+// parseScope parses the Scope claim.
+func parseScope(scope string) ([]grant, error) {
+	var grants []grant
+	for _, item := range strings.Fields(scope) {
+		access, pattern, ok := strings.Cut(item, ":")
+		if !ok || pattern == "" {
+			pattern = "*"
+		}
+		var commands []string
+		if category, ok := scopeAccess[access]; ok {
+			commands = expand([]string{category})
+		} else if contains(expand([]string{"@read", "@write", "@admin"}), access) {
+			commands = []string{access}
+		} else {
+			return nil, fmt.Errorf("unknown scope access %q: use read, write, admin or a command", access)
+		}
+		grants = append(grants, grant{commands: commands, pattern: pattern})
+	}
+	if len(grants) == 0 {
+		return nil, errors.New("token has no scopes")
+	}
+	return grants, nil
+}
+
+// A jwtVerifier checks signed tokens.
+type jwtVerifier struct {
+	secret []byte
+	issuer string // required iss claim, empty to accept any issuer
+}
+
+// UseJWT makes Authenticate accept HS256 tokens signed with secret, in
+// addition to the shared token. If issuer is not empty, tokens must carry it
+// as their iss claim. It may be called again to rotate the secret; an empty
+// secret stops accepting signed tokens.
+func (l *List) UseJWT(secret []byte, issuer string) error {
+	var verifier *jwtVerifier
+	if len(secret) > 0 {
+		if len(secret) < MinJWTSecretSize {
+			return fmt.Errorf("JWT secret must be at least %d bytes", MinJWTSecretSize)
+		}
+		verifier = &jwtVerifier{secret: secret, issuer: issuer}
+	}
+	l.mu.Lock()
+	l.jwt = verifier
+	l.mu.Unlock()
+	return nil
+}
+
+// SignToken returns an HS256 JWT for claims. The scope is checked so that
+// unusable tokens are not minted.
+func SignToken(secret []byte, claims TokenClaims) (string, error) {
+	if len(secret) < MinJWTSecretSize {
+		return "", fmt.Errorf("JWT secret must be at least %d bytes", MinJWTSecretSize)
+	}
+	if claims.ExpiresAt == 0 {
+		return "", errors.New("token has no expiration")
+	}
+	if _, err := parseScope(claims.Scope); err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(jwtSignature(secret, signed)), nil
+}
+
+func jwtSignature(secret []byte, signed string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return mac.Sum(nil)
+}
+
+// verify checks a token and returns a user limited to its scopes.
+func (v *jwtVerifier) verify(token string, now time.Time) (*User, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+	// Only HS256 is accepted, whatever the header claims, so a token cannot
+	// switch to "none" or another algorithm.
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if data, err := base64.RawURLEncoding.DecodeString(parts[0]); err != nil || json.Unmarshal(data, &header) != nil || header.Alg != "HS256" {
+		return nil, ErrInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, jwtSignature(v.secret, parts[0]+"."+parts[1])) {
+		return nil, ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var claims TokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if v.issuer != "" && claims.Issuer != v.issuer {
+		return nil, ErrInvalidToken
+	}
+	if claims.ExpiresAt == 0 || now.Add(-jwtLeeway).Unix() >= claims.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+	if claims.NotBefore != 0 && now.Add(jwtLeeway).Unix() < claims.NotBefore {
+		return nil, ErrInvalidToken
+	}
+	grants, err := parseScope(claims.Scope)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	name := claims.Subject
+	if name == "" {
+		name = "jwt"
+	}
+	return &User{Name: name, grants: grants, expiresAt: claims.ExpiresAt}, nil
+}
+
+// Expired reports whether the user came from a signed token that has
+// expired since it was verified. Connections that authenticate once, like
+// RPC, check it on every call.
+func (u *User) Expired() bool {
+	return u != nil && u.expiresAt != 0 && time.Now().Add(-jwtLeeway).Unix() >= u.expiresAt
+}
+
+// looksLikeJWT reports whether a bearer token has the JWT structure.
+func looksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
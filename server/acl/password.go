@@ -0,0 +1,103 @@
+package acl
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Password hashes are stored as "pbkdf2-sha256$<iterations>$<salt>$<hash>"
+// with base64 salt and hash.
+const (
+	hashScheme        = "pbkdf2-sha256"
+	hashIterations    = 100000
+	hashSaltSize      = 16
+	hashKeySize       = sha256.Size
+	minHashIterations = 1000
+)
+
+// dummyPasswordHash is verified for unknown users, so that they take as long
+// to reject as a wrong password and the response time does not reveal which
+// users exist. No password matches its all-zero key in practice.
+var dummyPasswordHash = fmt.Sprintf("%s$%d$%s$%s", hashScheme, hashIterations,
+	base64.RawStdEncoding.EncodeToString(make([]byte, hashSaltSize)),
+	base64.RawStdEncoding.EncodeToString(make([]byte, hashKeySize)))
+
+// HashPassword returns the PBKDF2-SHA256 hash of a password with a random salt.
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, hashSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := pbkdf2SHA256([]byte(password), salt, hashIterations, hashKeySize)
+	return fmt.Sprintf("%s$%d$%s$%s", hashScheme, hashIterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// checkPasswordHash reports whether a hash is in the HashPassword format.
+func checkPasswordHash(hash string) error {
+	_, _, _, err := parsePasswordHash(hash)
+	return err
+}
+
+// verifyPassword reports whether password matches a HashPassword hash.
+func verifyPassword(hash, password string) bool {
+	iterations, salt, key, err := parsePasswordHash(hash)
+	if err != nil {
+		return false
+	}
+	derived := pbkdf2SHA256([]byte(password), salt, iterations, len(key))
+	return subtle.ConstantTimeCompare(derived, key) == 1
+}
+
+func parsePasswordHash(hash string) (int, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != hashScheme {
+		return 0, nil, nil, fmt.Errorf("unsupported password hash: expected %s$<iterations>$<salt>$<hash>", hashScheme)
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < minHashIterations {
+		return 0, nil, nil, fmt.Errorf("invalid password hash iterations %q", parts[1])
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("invalid password hash salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil || len(key) == 0 {
+		return 0, nil, nil, fmt.Errorf("invalid password hash")
+	}
+	return iterations, salt, key, nil
+}
+
+// pbkdf2SHA256 derives a key with PBKDF2 (RFC 8018) using HMAC-SHA256.
+func pbkdf2SHA256(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	var key []byte
+	var counter [4]byte
+	u := make([]byte, sha256.Size)
+	for block := uint32(1); len(key) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(counter[:], block)
+		prf.Write(counter[:])
+		u = prf.Sum(u[:0])
+		t := append([]byte(nil), u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
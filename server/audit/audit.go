@@ -0,0 +1,200 @@
+// Package audit writes a tamper-evident trail of administrative and
+// destructive operations. Each record is a JSON line holding the SHA-256 hash
+// of the previous record, so editing, removing or reordering records breaks
+// the chain, which Verify detects.
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Outcomes of audited operations.
+const (
+	OutcomeOK        = "ok"
+	OutcomeError     = "error"
+	OutcomeDenied    = "denied"    // authentication failed
+	OutcomeForbidden = "forbidden" // the user may not run the operation
+)
+
+// Operations recorded in addition to the ACL command names.
+const (
+	OpAuth       = "auth"
+	OpDelete     = "delete"
+	OpSetUser    = "acl.setuser"
+	OpDeleteUser = "acl.deluser"
+	OpAddNode    = "cluster.addnode"
+	OpReload     = "config.reload"
+)
+
+// An Event is one audit record.
+type Event struct {
+	Time      string `json:"time"` // RFC 3339, set by Record
+	Principal string `json:"principal"`
+	Source    string `json:"source,omitempty"` // client address
+	Operation string `json:"operation"`
+	Key       string `json:"key,omitempty"`
+	KeyHash   string `json:"key_hash,omitempty"` // SHA-256 of the key when keys are hashed
+	Outcome   string `json:"outcome"`
+	Detail    string `json:"detail,omitempty"`
+	Prev      string `json:"prev"` // hash of the previous record, empty for the first
+	Hash      string `json:"hash"` // hash of this record with Hash empty, chained to Prev
+}
+
+// A Log appends events to an audit file. A nil *Log discards events, so
+// callers do not need to check whether auditing is enabled.
+type Log struct {
+	mu       sync.Mutex
+	hashKeys bool
+	file     *os.File
+	last     string // hash of the last record
+}
+
+// Open opens the audit file for appending, creating it with mode 0600, and
+// continues the chain from its last record. If hashKeys is set, keys are
+// recorded as their SHA-256 hash instead of in plain text.
+func Open(path string, hashKeys bool) (*Log, error) {
+	last, err := lastHash(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{hashKeys: hashKeys, file: file, last: last}, nil
+}
+
+// lastHash returns the hash of the last record of an audit file, or "" if it
+// is empty or does not exist.
+func lastHash(path string) (string, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	var last string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return "", fmt.Errorf("audit log %s: line %d: %w", path, line, err)
+		}
+		last = event.Hash
+	}
+	return last, scanner.Err()
+}
+
+// Record appends an event to the log. Failures to write are reported with the
+// default slog logger; they do not fail the audited operation.
+func (l *Log) Record(event Event) {
+	if l == nil {
+		return
+	}
+	if event.Principal == "" {
+		event.Principal = "anonymous"
+	}
+	event.Time = time.Now().UTC().Format(time.RFC3339Nano)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.hashKeys && event.Key != "" {
+		sum := sha256.Sum256([]byte(event.Key))
+		event.Key, event.KeyHash = "", hex.EncodeToString(sum[:])
+	}
+	event.Prev = l.last
+	hash, err := event.hash()
+	if err == nil {
+		event.Hash = hash
+		var line []byte
+		if line, err = json.Marshal(event); err == nil {
+			_, err = l.file.Write(append(line, '\n'))
+		}
+	}
+	if err != nil {
+		slog.Error("writing audit record failed", "operation", event.Operation, "err", err)
+		return
+	}
+	l.last = event.Hash
+}
+
+// SetHashKeys changes whether keys of later events are hashed.
+func (l *Log) SetHashKeys(hashKeys bool) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.hashKeys = hashKeys
+	l.mu.Unlock()
+}
+
+// Close closes the audit file.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// hash returns the chained hash of an event: the SHA-256 of its JSON encoding
+// with Hash empty, which includes Prev.
+func (e Event) hash() (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Verify checks the hash chain of an audit file. It returns the number of
+// records and the hash of the last one, which can be kept elsewhere to also
+// detect records removed from the end. The error names the first line that
+// breaks the chain.
+func Verify(path string) (int, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+
+	var count int
+	var prev string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := count + 1
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return count, prev, fmt.Errorf("line %d: invalid record: %w", line, err)
+		}
+		if event.Prev != prev {
+			return count, prev, fmt.Errorf("line %d: chain broken: previous hash %q, expected %q", line, event.Prev, prev)
+		}
+		hash, err := event.hash()
+		if err != nil {
+			return count, prev, err
+		}
+		if hash != event.Hash {
+			return count, prev, fmt.Errorf("line %d: record was modified: hash %q, expected %q", line, event.Hash, hash)
+		}
+		prev = event.Hash
+		count++
+	}
+	return count, prev, scanner.Err()
+}
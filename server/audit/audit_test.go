@@ -0,0 +1,54 @@
+package audit
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHashChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log, err := Open(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Record(Event{Principal: "alice", Source: "10.0.0.1:5000", Operation: OpDelete, Key: "team-a/k", Outcome: OutcomeOK})
+	log.Record(Event{Source: "10.0.0.2:5000", Operation: OpAuth, Outcome: OutcomeDenied})
+	log.Close()
+
+	// Reopening continues the chain, and hashed keys are not written in plain text.
+	log, err = Open(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Record(Event{Principal: "admin", Operation: OpDelete, Key: "secret-key", Outcome: OutcomeOK})
+	log.Close()
+
+	count, last, err := Verify(path)
+	if err != nil || count != 3 || last == "" {
+		t.Fatalf("expected 3 valid records, got %d %q %v", count, last, err)
+	}
+	data, _ := os.ReadFile(path)
+	if bytes.Contains(data, []byte("secret-key")) {
+		t.Fatal("expected the key to be hashed")
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Fatalf("expected audit log mode 0600, got %v", info.Mode().Perm())
+	}
+
+	// Editing a record is detected on its line.
+	tampered := bytes.Replace(data, []byte(`"key":"team-a/k"`), []byte(`"key":"team-b/k"`), 1)
+	os.WriteFile(path, tampered, 0600)
+	if _, _, err := Verify(path); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Fatalf("expected a modified record on line 1, got %v", err)
+	}
+
+	// Removing a record breaks the chain.
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	os.WriteFile(path, append(append([]byte{}, lines[0]...), lines[2]...), 0600)
+	if _, _, err := Verify(path); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("expected a broken chain on line 2, got %v", err)
+	}
+}
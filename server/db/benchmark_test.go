@@ -1,6 +1,7 @@
 package db
 
 import (
+	"container/heap"
 	"fmt"
 	"testing"
 )
@@ -60,3 +61,81 @@ func BenchmarkDelete(b *testing.B) {
 		store.Delete(key)
 	}
 }
+
+// populatedStore returns a WAL-less store with a single shard holding n keys
+// with a TTL, the worst case for the expiration index.
+func populatedStore(n int) *ShardedInMemoryStore {
+	store := NewShardedInMemoryStore(1, &DummyWAL{})
+	for i := 0; i < n; i++ {
+		store.Set(fmt.Sprintf("key%d", i), "value", 3600)
+	}
+	return store
+}
+
+func BenchmarkOverwriteLargeShard(b *testing.B) {
+	const n = 300000
+	store := populatedStore(n)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		store.Set(fmt.Sprintf("key%d", i%n), "value", 3600)
+	}
+}
+
+func BenchmarkDeleteLargeShard(b *testing.B) {
+	const n = 300000
+	store := populatedStore(n)
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key := keys[i%n]
+		store.Delete(key)
+		if i%n == n-1 {
+			b.StopTimer()
+			for _, k := range keys {
+				store.Set(k, "value", 3600)
+			}
+			b.StartTimer()
+		}
+	}
+}
+
+// BenchmarkExpiryIndexUpdate and BenchmarkExpiryLinearScan compare moving a
+// key in the indexed heap with the previous approach of scanning the heap for
+// the key, removing it and pushing it again.
+func BenchmarkExpiryIndexUpdate(b *testing.B) {
+	const n = 300000
+	h := newMinHeap()
+	for i := 0; i < n; i++ {
+		h.Update(fmt.Sprintf("key%d", i), int64(i+1))
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h.Update(fmt.Sprintf("key%d", (i*7919)%n), int64(n+i))
+	}
+}
+
+func BenchmarkExpiryLinearScan(b *testing.B) {
+	const n = 300000
+	h := newMinHeap()
+	for i := 0; i < n; i++ {
+		h.Update(fmt.Sprintf("key%d", i), int64(i+1))
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key := fmt.Sprintf("key%d", (i*7919)%n)
+		for j, entry := range h.entries {
+			if entry.key == key {
+				heap.Remove(&h, j)
+				break
+			}
+		}
+		heap.Push(&h, heapEntry{key: key, expiration: int64(n + i)})
+	}
+}
@@ -0,0 +1,35 @@
+package db
+
+import "time"
+
+// Clock is the time source used for expirations, cleanup and WAL timestamps.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the default Clock backed by time.Now.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// fixedClock is a Clock stopped at one time.
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time { return time.Time(c) }
+
+// FixedClock returns a Clock that always reports t, e.g. to serve a recovered
+// store as of its recovery target without keys expiring since.
+func FixedClock(t time.Time) Clock {
+	return fixedClock(t)
+}
+
+// UseClock replaces the store's time source, e.g. with a fake clock in tests.
+// It must be called before the store is used.
+func (s *ShardedInMemoryStore) UseClock(clock Clock) {
+	s.clock = clock
+}
+
+// nowMillis returns the store's current time in Unix milliseconds.
+func (s *ShardedInMemoryStore) nowMillis() int64 {
+	return s.clock.Now().UnixMilli()
+}
@@ -0,0 +1,124 @@
+package db
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+)
+
+// flateWriters reuses compressors, which are expensive to allocate.
+var flateWriters = sync.Pool{
+	New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return w
+	},
+}
+
+// compressValue returns the flate-compressed value, or nil if compressing
+// does not make it smaller.
+func compressValue(value []byte) []byte {
+	var buf bytes.Buffer
+	w := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(w)
+	w.Reset(&buf)
+	if _, err := w.Write(value); err != nil {
+		return nil
+	}
+	if err := w.Close(); err != nil {
+		return nil
+	}
+	if buf.Len() >= len(value) {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// decompressValue reverses compressValue.
+func decompressValue(value []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(value))
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// compressionState holds the compression threshold and counters of a store.
+type compressionState struct {
+	threshold       int // values of at least this many bytes are compressed, 0 disables
+	compressed      atomic.Int64
+	uncompressed    atomic.Int64
+	rawBytes        atomic.Int64
+	compressedBytes atomic.Int64
+}
+
+// CompressionStats reports on values written since the store was created.
+type CompressionStats struct {
+	Threshold       int     // minimum size of compressed values in bytes, 0 when disabled
+	Compressed      int64   // values stored compressed
+	Uncompressed    int64   // values at or above the threshold that did not shrink
+	RawBytes        int64   // size of the compressed values before compression
+	CompressedBytes int64   // size of the compressed values after compression
+	Ratio           float64 // RawBytes / CompressedBytes, 0 if nothing was compressed
+}
+
+// UseCompression enables flate compression of values of at least threshold
+// bytes, in memory and in the WAL. A threshold of 0 disables it. Reads are
+// not affected: compressed values are decompressed transparently whatever the
+// setting. It must be called before the store is used.
+func (s *ShardedInMemoryStore) UseCompression(threshold int) {
+	s.compression.threshold = threshold
+}
+
+// CompressionStats returns a snapshot of the compression counters.
+func (s *ShardedInMemoryStore) CompressionStats() CompressionStats {
+	c := &s.compression
+	stats := CompressionStats{
+		Threshold:       c.threshold,
+		Compressed:      c.compressed.Load(),
+		Uncompressed:    c.uncompressed.Load(),
+		RawBytes:        c.rawBytes.Load(),
+		CompressedBytes: c.compressedBytes.Load(),
+	}
+	if stats.CompressedBytes > 0 {
+		stats.Ratio = float64(stats.RawBytes) / float64(stats.CompressedBytes)
+	}
+	return stats
+}
+
+// encodeValue returns the representation of value kept in the store,
+// compressed if it is large enough and compresses well.
+func (s *ShardedInMemoryStore) encodeValue(value []byte) ValueWithTTL {
+	c := &s.compression
+	if c.threshold <= 0 || len(value) < c.threshold {
+		return ValueWithTTL{Value: value}
+	}
+	compressed := compressValue(value)
+	if compressed == nil {
+		c.uncompressed.Add(1)
+		return ValueWithTTL{Value: value}
+	}
+	c.compressed.Add(1)
+	c.rawBytes.Add(int64(len(value)))
+	c.compressedBytes.Add(int64(len(compressed)))
+	return ValueWithTTL{Value: compressed, Compressed: true}
+}
+
+// plainValue returns the value as written by the client. A value that cannot
+// be decompressed is reported as missing.
+func (s *ShardedInMemoryStore) plainValue(key string, value ValueWithTTL) ([]byte, bool) {
+	if !value.Compressed {
+		return value.Value, true
+	}
+	plain, err := decompressValue(value.Value)
+	if err != nil {
+		slog.Error("decompressing value failed", "key", key, "err", err)
+		return nil, false
+	}
+	return plain, true
+}
+
+// storedValue returns the in-memory representation of a replayed set entry.
+func (entry *WriteAheadLogEntry) storedValue() ValueWithTTL {
+	return ValueWithTTL{Value: entry.Value, Expiration: entry.ExpiresAt, Compressed: entry.Compressed}
+}
@@ -0,0 +1,82 @@
+package db
+
+import (
+	"bytes"
+	"crypto/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	store, wal, clock := newTestStore()
+	store.UseCompression(64)
+
+	doc := strings.Repeat(`{"user":"alice","roles":["admin","dev"]},`, 100)
+	store.Set("doc", doc, 0)
+	store.Set("small", "tiny", 0)
+	noise := make([]byte, 256)
+	rand.Read(noise)
+	store.SetBytes("noise", noise, 0)
+
+	if stored := store.getShard("doc").store["doc"]; !stored.Compressed || len(stored.Value) >= len(doc) {
+		t.Fatalf("expected doc to be stored compressed, got %d bytes", len(stored.Value))
+	}
+	if store.getShard("small").store["small"].Compressed {
+		t.Fatal("values below the threshold must not be compressed")
+	}
+	if store.getShard("noise").store["noise"].Compressed {
+		t.Fatal("values that do not shrink must be stored as is")
+	}
+	if v, ok := store.Get("doc"); !ok || v != doc {
+		t.Fatal("Get must return the decompressed value")
+	}
+	if v, ok := store.GetEx("doc", 10); !ok || v != doc {
+		t.Fatal("GetEx must return the decompressed value")
+	}
+
+	stats := store.CompressionStats()
+	if stats.Compressed != 1 || stats.Uncompressed != 1 || stats.RawBytes != int64(len(doc)) || stats.Ratio <= 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	// The WAL carries the compressed bytes and replay keeps them compressed,
+	// even in a store with compression disabled.
+	walPath := filepath.Join(t.TempDir(), "wal.bin")
+	writeTestWAL(t, walPath, wal.entries)
+	replayed := NewShardedInMemoryStore(4, &DummyWAL{})
+	replayed.UseClock(clock)
+	if err := replayed.RecoverFromWAL(walPath); err != nil {
+		t.Fatal(err)
+	}
+	if !replayed.getShard("doc").store["doc"].Compressed {
+		t.Fatal("replayed value must stay compressed")
+	}
+	if v, ok := replayed.GetBytes("noise"); !ok || !bytes.Equal(v, noise) {
+		t.Fatal("uncompressed value did not survive replay")
+	}
+	if v, ok := replayed.Get("doc"); !ok || v != doc {
+		t.Fatal("compressed value did not survive replay")
+	}
+}
+
+func TestDecodeV2Entry(t *testing.T) {
+	entry := WriteAheadLogEntry{Action: ActionSet, Key: "k", Value: []byte("v"), Timestamp: 1, v2: true}
+	entry.Checksum = entry.computeChecksum()
+	var buf bytes.Buffer
+	if err := encodeEntry(&buf, entry); err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := decodeEntry(&buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.v2 || !decoded.ValidChecksum() {
+		t.Fatalf("expected a valid v2 entry, got %+v", decoded)
+	}
+	decoded.upgrade()
+	if decoded.v2 || !decoded.ValidChecksum() {
+		t.Fatalf("expected the entry to be upgraded to the current format, got %+v", decoded)
+	}
+}
@@ -0,0 +1,294 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// walFormatEncrypted marks a record whose current-format encoding is sealed
+// with AES-GCM. The marker is followed by the key ID (one length byte and the
+// ID), the nonce and the length-prefixed ciphertext. The key ID is also the
+// additional authenticated data.
+const walFormatEncrypted int32 = -4
+
+// EncryptionKeyEnv is the environment variable read when no key file is
+// configured. It holds comma-separated "id:base64-key" pairs; the first key
+// is the active one.
+const EncryptionKeyEnv = "MEMORANDUM_ENCRYPTION_KEY"
+
+// ErrNoEncryptionKey is returned when a record is encrypted with a key that
+// is not in the keyring.
+var ErrNoEncryptionKey = errors.New("encryption key not available")
+
+// A Keyring holds the AES keys used to encrypt WAL records and snapshots.
+// New records are encrypted with the active key; any key in the ring can
+// decrypt, which allows keys to be rotated without rewriting old files.
+type Keyring struct {
+	active string
+	aeads  map[string]cipher.AEAD
+}
+
+// NewKeyring creates a keyring from AES-128, AES-192 or AES-256 keys indexed
+// by key ID. The active key encrypts new records.
+func NewKeyring(active string, keys map[string][]byte) (*Keyring, error) {
+	if _, ok := keys[active]; !ok {
+		return nil, fmt.Errorf("active encryption key %q is not in the keyring", active)
+	}
+	ring := &Keyring{active: active, aeads: make(map[string]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		if id == "" || len(id) > 255 {
+			return nil, fmt.Errorf("invalid encryption key ID %q: must be 1 to 255 bytes", id)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %q: %w", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		ring.aeads[id] = aead
+	}
+	return ring, nil
+}
+
+// UseKeyring encrypts snapshots with the active key of keys and decrypts WAL
+// and snapshot records with any of its keys. It must be called before the
+// store is used; the WAL is configured separately with WAL.UseKeyring.
+func (s *ShardedInMemoryStore) UseKeyring(keys *Keyring) {
+	s.keys = keys
+}
+
+// ActiveKeyID returns the ID of the key that encrypts new records.
+func (k *Keyring) ActiveKeyID() string {
+	return k.active
+}
+
+// keyFile is the JSON format of an encryption key file.
+type keyFile struct {
+	Active string            `json:"active"`
+	Keys   map[string]string `json:"keys"` // key ID -> base64 key
+}
+
+// LoadKeyring loads the keyring from a key file, or from EncryptionKeyEnv if
+// path is empty. It returns a nil keyring, meaning no encryption, if neither
+// is set. Key files must not be readable by group or others.
+func LoadKeyring(path string) (*Keyring, error) {
+	if path == "" {
+		env := os.Getenv(EncryptionKeyEnv)
+		if env == "" {
+			return nil, nil
+		}
+		return parseKeyringEnv(env)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("key file %s must not be accessible by group or others (chmod 600)", path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file keyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("key file %s: %w", path, err)
+	}
+	keys := make(map[string][]byte, len(file.Keys))
+	for id, encoded := range file.Keys {
+		if keys[id], err = base64.StdEncoding.DecodeString(encoded); err != nil {
+			return nil, fmt.Errorf("key file %s: key %q: %w", path, id, err)
+		}
+	}
+	return NewKeyring(file.Active, keys)
+}
+
+// parseKeyringEnv parses the EncryptionKeyEnv format.
+func parseKeyringEnv(value string) (*Keyring, error) {
+	keys := make(map[string][]byte)
+	var active string
+	for _, pair := range strings.Split(value, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok {
+			return nil, fmt.Errorf("%s: expected id:base64-key, got %q", EncryptionKeyEnv, pair)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("%s: key %q: %w", EncryptionKeyEnv, id, err)
+		}
+		if active == "" {
+			active = id
+		}
+		keys[id] = key
+	}
+	return NewKeyring(active, keys)
+}
+
+// encodeRecord encodes an entry, encrypting it with the active key of the
+// keyring unless keys is nil.
+func encodeRecord(buf *bytes.Buffer, entry WriteAheadLogEntry, keys *Keyring) error {
+	if keys == nil {
+		return encodeEntry(buf, entry)
+	}
+	var plain bytes.Buffer
+	if err := encodeEntry(&plain, entry); err != nil {
+		return err
+	}
+	aead := keys.aeads[keys.active]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	sealed := aead.Seal(nil, nonce, plain.Bytes(), []byte(keys.active))
+
+	if err := binary.Write(buf, binary.LittleEndian, walFormatEncrypted); err != nil {
+		return err
+	}
+	buf.WriteByte(byte(len(keys.active)))
+	buf.WriteString(keys.active)
+	buf.Write(nonce)
+	return writeField(buf, sealed)
+}
+
+// decodeEncryptedEntry decrypts a record whose marker has been consumed.
+func decodeEncryptedEntry(r io.Reader, keys *Keyring) (WriteAheadLogEntry, error) {
+	var entry WriteAheadLogEntry
+	var idLen [1]byte
+	if _, err := io.ReadFull(r, idLen[:]); err != nil {
+		return entry, err
+	}
+	id, err := readFieldData(r, int32(idLen[0]))
+	if err != nil {
+		return entry, err
+	}
+	aead, ok := keys.aead(string(id))
+	nonceSize := 12 // the standard GCM nonce size used by encodeRecord
+	if ok {
+		nonceSize = aead.NonceSize()
+	}
+	nonce, err := readFieldData(r, int32(nonceSize))
+	if err != nil {
+		return entry, err
+	}
+	sealed, err := readBytesField(r)
+	if err != nil {
+		return entry, err
+	}
+	if !ok {
+		return entry, fmt.Errorf("%w: record encrypted with key %q", ErrNoEncryptionKey, id)
+	}
+
+	plain, err := aead.Open(nil, nonce, sealed, id)
+	if err != nil {
+		return entry, fmt.Errorf("%w: cannot decrypt record with key %q", ErrCorruptEntry, id)
+	}
+	// Encrypted records are never nested, so the inner record is read without keys.
+	entry, err = decodeEntry(bytes.NewReader(plain), nil)
+	if err != nil {
+		return entry, fmt.Errorf("%w: decrypted record: %v", ErrCorruptEntry, err)
+	}
+	entry.KeyID = string(id)
+	return entry, nil
+}
+
+// aead returns the cipher for a key ID. It is safe to call on a nil keyring.
+func (k *Keyring) aead(id string) (cipher.AEAD, bool) {
+	if k == nil {
+		return nil, false
+	}
+	aead, ok := k.aeads[id]
+	return aead, ok
+}
+
+// ReencryptFile rewrites a WAL or snapshot file, decrypting records with
+// any key of the read keyring and encrypting them with the active key of the
+// write keyring, or writing plaintext if write is nil. The output is written
+// to a temporary file and renamed into place, so src and dst may be the same
+// file. It must not be used on a WAL the server is writing to. Rewriting a
+// WAL changes the offsets of its records, so RecoverPointInTime rejects
+// snapshots taken against it before with ErrWALMismatch. It returns the
+// number of records rewritten.
+func ReencryptFile(src, dst string, read, write *Keyring) (int, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".tmp*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+	w := bufio.NewWriter(tmp)
+
+	count, err := reencryptRecords(bufio.NewReader(in), w, read, write)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return count, err
+	}
+	return count, os.Rename(tmp.Name(), dst)
+}
+
+// reencryptRecords copies the snapshot header, if any, and re-encodes every record.
+func reencryptRecords(br *bufio.Reader, w io.Writer, read, write *Keyring) (int, error) {
+	r := &countingReader{r: br}
+	if magic, err := br.Peek(len(snapshotMagic)); err == nil && snapshotHeaderSize(magic) > 0 {
+		header := make([]byte, snapshotHeaderSize(magic))
+		if _, err := io.ReadFull(r, header); err != nil {
+			return 0, err
+		}
+		if _, err := w.Write(header); err != nil {
+			return 0, err
+		}
+	}
+
+	var count int
+	var buf bytes.Buffer
+	for {
+		offset := r.offset
+		entry, err := decodeEntry(r, read)
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, fmt.Errorf("record at offset %d: %w", offset, err)
+		}
+		if !entry.ValidChecksum() {
+			return count, fmt.Errorf("invalid checksum for record at offset %d", offset)
+		}
+		entry.upgrade()
+
+		buf.Reset()
+		if err := encodeRecord(&buf, entry, write); err != nil {
+			return count, err
+		}
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return count, err
+		}
+		count++
+	}
+}
@@ -0,0 +1,157 @@
+package db
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testKeyring(t *testing.T, active string, ids ...string) *Keyring {
+	t.Helper()
+	keys := make(map[string][]byte)
+	for _, id := range ids {
+		keys[id] = bytes.Repeat([]byte(id[:1]), 32)
+	}
+	ring, err := NewKeyring(active, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ring
+}
+
+func TestEncryptedWALAndSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	old := testKeyring(t, "a", "a")
+
+	var buf bytes.Buffer
+	for _, entry := range []WriteAheadLogEntry{
+		{Action: ActionSet, Key: "session", Value: []byte("secret-token"), Timestamp: 1},
+		{Action: ActionSet, Key: "other", Value: []byte("v"), Timestamp: 2},
+	} {
+		entry.Checksum = entry.computeChecksum()
+		if err := encodeRecord(&buf, entry, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	walPath := filepath.Join(dir, "wal.bin")
+	if err := os.WriteFile(walPath, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("secret-token")) {
+		t.Fatal("the WAL must not contain plaintext values")
+	}
+
+	if err := NewShardedInMemoryStore(4, &DummyWAL{}).RecoverFromWAL(walPath); !errors.Is(err, ErrNoEncryptionKey) {
+		t.Fatalf("expected ErrNoEncryptionKey without a keyring, got %v", err)
+	}
+	store := NewShardedInMemoryStore(4, &DummyWAL{})
+	store.UseKeyring(old)
+	if err := store.RecoverFromWAL(walPath); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := store.Get("session"); !ok || v != "secret-token" {
+		t.Fatalf("expected the decrypted value, got %q %v", v, ok)
+	}
+
+	// A modified ciphertext fails authentication.
+	tampered := bytes.Clone(buf.Bytes())
+	tampered[40] ^= 1
+	tamperedPath := filepath.Join(dir, "tampered.bin")
+	os.WriteFile(tamperedPath, tampered, 0600)
+	if err := store.RecoverFromWAL(tamperedPath); !errors.Is(err, ErrCorruptEntry) {
+		t.Fatalf("expected ErrCorruptEntry for a tampered record, got %v", err)
+	}
+
+	// Rotate to key b and re-encrypt the WAL in place; key a is no longer needed.
+	rotated := testKeyring(t, "b", "a", "b")
+	if n, err := ReencryptFile(walPath, walPath, rotated, rotated); err != nil || n != 2 {
+		t.Fatalf("expected 2 records re-encrypted, got %d %v", n, err)
+	}
+	onlyB := testKeyring(t, "b", "b")
+	reader, err := OpenWALReader(walPath, onlyB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := reader.Next()
+	reader.Close()
+	if err != nil || record.Entry.KeyID != "b" || !record.Entry.ValidChecksum() {
+		t.Fatalf("expected a valid record encrypted with key b, got %+v %v", record, err)
+	}
+
+	// Snapshots are encrypted with the store's keyring.
+	store.UseKeyring(onlyB)
+	snapPath := filepath.Join(dir, "state.snap")
+	if err := store.WriteSnapshot(snapPath, SnapshotHeader{Timestamp: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(snapPath); bytes.Contains(data, []byte("secret-token")) {
+		t.Fatal("the snapshot must not contain plaintext values")
+	}
+	if info, _ := os.Stat(snapPath); info.Mode().Perm() != 0600 {
+		t.Fatalf("expected snapshot mode 0600, got %v", info.Mode().Perm())
+	}
+	loaded := NewShardedInMemoryStore(4, &DummyWAL{})
+	loaded.UseKeyring(onlyB)
+	if _, err := loaded.LoadSnapshot(snapPath); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := loaded.Get("session"); !ok || v != "secret-token" {
+		t.Fatalf("expected the value from the encrypted snapshot, got %q %v", v, ok)
+	}
+}
+
+func TestReencryptInvalidatesSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	walPath := filepath.Join(dir, "wal.bin")
+	snapPath := filepath.Join(dir, "base.snap")
+	writeTestWAL(t, walPath, []WriteAheadLogEntry{
+		{Action: ActionSet, Key: "a", Value: []byte("1"), Timestamp: 100},
+		{Action: ActionSet, Key: "b", Value: []byte("2"), Timestamp: 200},
+	})
+	store := NewShardedInMemoryStore(4, &DummyWAL{})
+	header, err := store.RecoverPointInTime(walPath, "", RecoveryTarget{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.WriteSnapshot(snapPath, header); err != nil {
+		t.Fatal(err)
+	}
+
+	// Encrypted records are larger, so the snapshot's offset no longer
+	// starts a record.
+	ring := testKeyring(t, "a", "a")
+	if _, err := ReencryptFile(walPath, walPath, nil, ring); err != nil {
+		t.Fatal(err)
+	}
+	restored := NewShardedInMemoryStore(4, &DummyWAL{})
+	restored.UseKeyring(ring)
+	if _, err := restored.RecoverPointInTime(walPath, snapPath, RecoveryTarget{}); !errors.Is(err, ErrWALMismatch) {
+		t.Fatalf("expected ErrWALMismatch after re-encrypting the WAL, got %v", err)
+	}
+}
+
+func TestLoadKeyringFromEnv(t *testing.T) {
+	t.Setenv(EncryptionKeyEnv, "new:"+bytesBase64(32, 'n')+", old:"+bytesBase64(16, 'o'))
+	ring, err := LoadKeyring("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ring.ActiveKeyID() != "new" {
+		t.Fatalf("expected the first key to be active, got %q", ring.ActiveKeyID())
+	}
+	if _, ok := ring.aead("old"); !ok {
+		t.Fatal("expected the old key to stay available for decryption")
+	}
+
+	t.Setenv(EncryptionKeyEnv, "")
+	if ring, err := LoadKeyring(""); ring != nil || err != nil {
+		t.Fatalf("expected no keyring without configuration, got %v %v", ring, err)
+	}
+}
+
+func bytesBase64(n int, b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, n))
+}
@@ -0,0 +1,254 @@
+package db
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Defaults for the active expiration cycle, used for zero ExpireConfig fields.
+const (
+	DefaultExpireBatchSize  = 20
+	DefaultExpireTimeBudget = 25 * time.Millisecond
+	minExpireFastInterval   = 10 * time.Millisecond
+	// expiredRatioThreshold is the share of keys with a TTL that must have
+	// expired during a cycle for the next cycle to run at the fast interval.
+	expiredRatioThreshold = 0.25
+)
+
+// ExpireConfig tunes the active expiration cycle.
+type ExpireConfig struct {
+	Interval     time.Duration // time between cycles when there is no backlog
+	FastInterval time.Duration // time between cycles while keys are expiring in bulk
+	BatchSize    int           // expired keys removed per shard lock acquisition
+	TimeBudget   time.Duration // wall time a single cycle may spend removing keys
+}
+
+func (c ExpireConfig) withDefaults() ExpireConfig {
+	if c.Interval <= 0 {
+		c.Interval = time.Second
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = DefaultExpireBatchSize
+	}
+	if c.TimeBudget <= 0 {
+		c.TimeBudget = DefaultExpireTimeBudget
+	}
+	if c.FastInterval <= 0 {
+		c.FastInterval = c.Interval / 10
+		if c.FastInterval < minExpireFastInterval {
+			c.FastInterval = minExpireFastInterval
+		}
+	}
+	if c.FastInterval > c.Interval {
+		c.FastInterval = c.Interval
+	}
+	return c
+}
+
+// ExpireStats reports on active expiration. Lag is how long after its
+// deadline a key was removed by the expiration cycle.
+type ExpireStats struct {
+	ExpiredKeys       int64         // keys removed by active expiration
+	Cycles            int64         // expiration cycles run
+	LastCycleKeys     int64         // keys removed by the last cycle
+	LastCycleDuration time.Duration // wall time spent by the last cycle
+	LastLag           time.Duration // lag of the most recently removed key
+	MaxLag            time.Duration // largest lag seen
+	AvgLag            time.Duration // mean lag over all removed keys
+	Backlog           bool          // the last cycle ran out of budget with expired keys left
+}
+
+// expireState is the bookkeeping shared by expiration cycles.
+type expireState struct {
+	cycleMu sync.Mutex // serializes cycles
+	cursor  int        // shard the next cycle starts from
+
+	mu       sync.Mutex
+	stats    ExpireStats
+	lagTotal int64 // milliseconds
+	stop     chan struct{}
+}
+
+// ExpireCycle removes expired keys, at most batchSize per shard lock
+// acquisition, until no expired keys are left or the time budget is spent.
+// The lock is released and the goroutine yields between batches so that
+// mass expirations do not block readers and writers. A cycle cut short by
+// its budget resumes from the shard it stopped at. It returns the number of
+// keys removed and whether expired keys were left behind. A budget of 0 means
+// no limit.
+func (s *ShardedInMemoryStore) ExpireCycle(batchSize int, budget time.Duration) (int, bool) {
+	s.expire.cycleMu.Lock()
+	defer s.expire.cycleMu.Unlock()
+
+	start := time.Now()
+	deadline := start.Add(budget)
+	var total expireBatchResult
+	backlog := false
+
+	for i := 0; i < s.numShards && !backlog; i++ {
+		idx := (s.expire.cursor + i) % s.numShards
+		for {
+			batch := s.expireBatch(s.shards[idx], batchSize)
+			total.add(batch)
+			if !batch.more {
+				break
+			}
+			if budget > 0 && time.Now().After(deadline) {
+				// Pick up this shard again on the next cycle.
+				s.expire.cursor = idx
+				backlog = true
+				break
+			}
+			runtime.Gosched()
+		}
+	}
+	if !backlog {
+		s.expire.cursor = 0
+	}
+
+	s.recordExpireCycle(total, time.Since(start), backlog)
+	return total.removed, backlog
+}
+
+// expireBatchResult describes the keys removed by one or more batches. Lags
+// are in milliseconds.
+type expireBatchResult struct {
+	removed  int
+	lagTotal int64
+	maxLag   int64
+	lastLag  int64
+	more     bool // expired keys are still waiting in the shard
+}
+
+func (r *expireBatchResult) add(batch expireBatchResult) {
+	if batch.removed == 0 {
+		return
+	}
+	r.removed += batch.removed
+	r.lagTotal += batch.lagTotal
+	r.lastLag = batch.lastLag
+	if batch.maxLag > r.maxLag {
+		r.maxLag = batch.maxLag
+	}
+}
+
+// expireBatch removes up to batchSize expired keys from a shard in deadline
+// order, holding the shard's write lock for the batch only.
+func (s *ShardedInMemoryStore) expireBatch(shard *mapShard, batchSize int) expireBatchResult {
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	now := s.nowMillis()
+	var r expireBatchResult
+	for r.removed < batchSize {
+		entry, ok := shard.heap.Peek()
+		if !ok || entry.expiration >= now {
+			return r
+		}
+		shard.heap.RemoveByKey(entry.key)
+		delete(shard.store, entry.key)
+		s.logEntry(WriteAheadLogEntry{Action: ActionExpired, Key: entry.key})
+
+		r.lastLag = now - entry.expiration
+		if r.removed == 0 {
+			r.maxLag = r.lastLag // the earliest deadline is the most overdue
+		}
+		r.lagTotal += r.lastLag
+		r.removed++
+	}
+	entry, ok := shard.heap.Peek()
+	r.more = ok && entry.expiration < now
+	return r
+}
+
+func (s *ShardedInMemoryStore) recordExpireCycle(r expireBatchResult, took time.Duration, backlog bool) {
+	s.expire.mu.Lock()
+	defer s.expire.mu.Unlock()
+
+	st := &s.expire.stats
+	st.Cycles++
+	st.LastCycleKeys = int64(r.removed)
+	st.LastCycleDuration = took
+	st.Backlog = backlog
+	if r.removed == 0 {
+		return
+	}
+	expiredKeys.With("active").Add(float64(r.removed))
+	st.ExpiredKeys += int64(r.removed)
+	s.expire.lagTotal += r.lagTotal
+	st.LastLag = time.Duration(r.lastLag) * time.Millisecond
+	if lag := time.Duration(r.maxLag) * time.Millisecond; lag > st.MaxLag {
+		st.MaxLag = lag
+	}
+	st.AvgLag = time.Duration(s.expire.lagTotal/st.ExpiredKeys) * time.Millisecond
+}
+
+// ExpireStats returns a snapshot of the active expiration statistics.
+func (s *ShardedInMemoryStore) ExpireStats() ExpireStats {
+	s.expire.mu.Lock()
+	defer s.expire.mu.Unlock()
+	return s.expire.stats
+}
+
+// expiringKeys returns the number of keys with an expiration.
+func (s *ShardedInMemoryStore) expiringKeys() int {
+	total := 0
+	for _, shard := range s.shards {
+		shard.mu.RLock()
+		total += shard.heap.Len()
+		shard.mu.RUnlock()
+	}
+	return total
+}
+
+// StartExpireRoutine starts a background goroutine running expiration cycles.
+// Cycles run every cfg.Interval, and every cfg.FastInterval while the last
+// cycle left a backlog or expired a large share of the keys with a TTL. The
+// routine stops when the store is closed.
+func (s *ShardedInMemoryStore) StartExpireRoutine(cfg ExpireConfig) {
+	cfg = cfg.withDefaults()
+	stop := make(chan struct{})
+	s.expire.mu.Lock()
+	if s.expire.stop != nil {
+		close(s.expire.stop)
+	}
+	s.expire.stop = stop
+	s.expire.mu.Unlock()
+
+	go func() {
+		timer := time.NewTimer(cfg.Interval)
+		defer timer.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-timer.C:
+			}
+			removed, backlog := s.ExpireCycle(cfg.BatchSize, cfg.TimeBudget)
+			next := cfg.Interval
+			if backlog || expiredRatio(removed, s.expiringKeys()) > expiredRatioThreshold {
+				next = cfg.FastInterval
+			}
+			timer.Reset(next)
+		}
+	}()
+}
+
+// expiredRatio is the share of keys with a TTL that a cycle removed.
+func expiredRatio(removed, remaining int) float64 {
+	if removed == 0 {
+		return 0
+	}
+	return float64(removed) / float64(removed+remaining)
+}
+
+// stopExpireRoutine stops the routine started by StartExpireRoutine, if any.
+func (s *ShardedInMemoryStore) stopExpireRoutine() {
+	s.expire.mu.Lock()
+	defer s.expire.mu.Unlock()
+	if s.expire.stop != nil {
+		close(s.expire.stop)
+		s.expire.stop = nil
+	}
+}
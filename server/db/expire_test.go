@@ -0,0 +1,73 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestExpireCycle(t *testing.T) {
+	store, _, clock := newTestStore()
+
+	for i := 0; i < 200; i++ {
+		store.PSet(fmt.Sprintf("session:%d", i), "1", int64(100+i))
+	}
+	store.Set("permanent", "1", 0)
+	clock.Advance(400 * time.Millisecond)
+
+	// With no time budget one cycle drains every shard, one batch at a time.
+	removed, backlog := store.ExpireCycle(8, 0)
+	if removed != 200 || backlog {
+		t.Fatalf("expected 200 keys removed without backlog, got %d %v", removed, backlog)
+	}
+	if _, ok := store.Get("permanent"); !ok {
+		t.Fatal("keys without expiration must survive the cycle")
+	}
+
+	stats := store.ExpireStats()
+	if stats.ExpiredKeys != 200 || stats.Cycles != 1 || stats.LastCycleKeys != 200 {
+		t.Fatalf("unexpected counters: %+v", stats)
+	}
+	// Deadlines ran from 100ms to 299ms, so lags range from 300ms down to 101ms.
+	if stats.MaxLag != 300*time.Millisecond {
+		t.Fatalf("expected a max lag of 300ms, got %v", stats.MaxLag)
+	}
+	if stats.AvgLag < 200*time.Millisecond || stats.AvgLag > 201*time.Millisecond {
+		t.Fatalf("expected an average lag of about 200ms, got %v", stats.AvgLag)
+	}
+
+	if removed, _ := store.ExpireCycle(8, 0); removed != 0 {
+		t.Fatalf("expected nothing left to expire, got %d", removed)
+	}
+}
+
+func TestExpireCycleBudget(t *testing.T) {
+	store, _, clock := newTestStore()
+
+	for i := 0; i < 1000; i++ {
+		store.PSet(fmt.Sprintf("k%d", i), "1", 10)
+	}
+	clock.Advance(time.Second)
+
+	// A budget that is already spent after the first batch leaves a backlog
+	// for the next cycle instead of holding on to the shard.
+	removed, backlog := store.ExpireCycle(10, time.Nanosecond)
+	if !backlog || removed != 10 {
+		t.Fatalf("expected a single batch of 10 and a backlog, got %d %v", removed, backlog)
+	}
+	if !store.ExpireStats().Backlog {
+		t.Fatal("expected the stats to report the backlog")
+	}
+
+	total := removed
+	for backlog {
+		removed, backlog = store.ExpireCycle(10, time.Nanosecond)
+		total += removed
+	}
+	if total != 1000 {
+		t.Fatalf("expected successive cycles to remove all 1000 keys, got %d", total)
+	}
+	if store.expiringKeys() != 0 {
+		t.Fatal("expected the expiration index to be empty")
+	}
+}
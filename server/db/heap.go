@@ -2,48 +2,75 @@ package db
 
 import "container/heap"
 
-// A MinHeap is a min-heap of ValueWithTTL items.
-type MinHeap []heapEntry
+// A MinHeap is the per-shard expiration index: a min-heap of keys ordered by
+// expiration time plus a key-to-position map, so that adding, moving and
+// removing a key are O(log n). Only keys with an expiration are tracked.
+type MinHeap struct {
+	entries []heapEntry
+	index   map[string]int // key -> position in entries
+}
 
 type heapEntry struct {
-	key          string
-	valueWithTTL ValueWithTTL
-	index        int // Index of the item in the heap.
+	key        string
+	expiration int64 // Unix timestamp in milliseconds
+}
+
+// newMinHeap returns an empty expiration index.
+func newMinHeap() MinHeap {
+	return MinHeap{index: make(map[string]int)}
 }
 
-func (h MinHeap) Len() int { return len(h) }
+func (h MinHeap) Len() int { return len(h.entries) }
 func (h MinHeap) Less(i, j int) bool {
-	return h[i].valueWithTTL.Expiration < h[j].valueWithTTL.Expiration
+	return h.entries[i].expiration < h.entries[j].expiration
 }
 func (h MinHeap) Swap(i, j int) {
-	h[i], h[j] = h[j], h[i]
-	h[i].index = i
-	h[j].index = j
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+	h.index[h.entries[i].key] = i
+	h.index[h.entries[j].key] = j
 }
 
 func (h *MinHeap) Push(x interface{}) {
-	n := len(*h)
 	item := x.(heapEntry)
-	item.index = n
-	*h = append(*h, item)
+	h.index[item.key] = len(h.entries)
+	h.entries = append(h.entries, item)
 }
 
 func (h *MinHeap) Pop() interface{} {
-	old := *h
-	n := len(old)
-	item := old[n-1]
-	old[n-1] = heapEntry{} // Avoid memory leak
-	item.index = -1        // For safety
-	*h = old[0 : n-1]
+	n := len(h.entries)
+	item := h.entries[n-1]
+	h.entries[n-1] = heapEntry{} // Avoid memory leak
+	h.entries = h.entries[:n-1]
+	delete(h.index, item.key)
 	return item
 }
 
+// Update sets the expiration of a key, adding or moving it in the heap. An
+// expiration of 0 means the key never expires and removes it from the heap.
+func (h *MinHeap) Update(key string, expiration int64) {
+	if expiration == 0 {
+		h.RemoveByKey(key)
+		return
+	}
+	if i, ok := h.index[key]; ok {
+		h.entries[i].expiration = expiration
+		heap.Fix(h, i)
+		return
+	}
+	heap.Push(h, heapEntry{key: key, expiration: expiration})
+}
+
+// RemoveByKey removes a key from the heap if it is present.
 func (h *MinHeap) RemoveByKey(key string) {
-	for i, entry := range *h {
-		if entry.key == key {
-			// Remove the entry from the heap
-			heap.Remove(h, i)
-			break
-		}
+	if i, ok := h.index[key]; ok {
+		heap.Remove(h, i)
+	}
+}
+
+// Peek returns the entry with the earliest expiration without removing it.
+func (h *MinHeap) Peek() (heapEntry, bool) {
+	if len(h.entries) == 0 {
+		return heapEntry{}, false
 	}
+	return h.entries[0], true
 }
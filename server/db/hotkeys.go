@@ -0,0 +1,189 @@
+package db
+
+import (
+	"hash/maphash"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Hot keys are found by counting a sample of accesses in a count-min sketch,
+// which never underestimates a key's count, and keeping the keys with the
+// highest estimates as candidates.
+const (
+	sketchDepth         = 4
+	sketchWidth         = 4096
+	hotKeyCandidates    = 128
+	hotKeyDecayInterval = time.Minute // counts are halved at this interval so that they reflect recent traffic
+)
+
+// MaxKeyReport is the most keys returned by HotKeys and BigKeys.
+const MaxKeyReport = hotKeyCandidates
+
+// HotKey is a frequently accessed key.
+type HotKey struct {
+	Key      string `json:"key"`
+	Accesses int64  `json:"accesses"` // estimated recent accesses, reads and writes
+}
+
+// BigKey is a key with a large value.
+type BigKey struct {
+	Key   string `json:"key"`
+	Bytes int    `json:"bytes"` // key and value as stored, i.e. after compression
+	Shard int    `json:"shard"`
+}
+
+// hotKeyState tracks sampled accesses. Accesses that are not sampled only
+// cost an atomic increment.
+type hotKeyState struct {
+	sampleRate atomic.Int64 // one access in sampleRate is counted, 0 when disabled
+	accesses   atomic.Uint64
+
+	mu         sync.Mutex
+	seeds      [sketchDepth]maphash.Seed
+	sketch     [sketchDepth][sketchWidth]uint32
+	candidates map[string]uint32 // estimated sampled accesses of the hottest keys
+	lastDecay  time.Time
+}
+
+// UseHotKeys enables hot key tracking, counting one access in sampleRate. A
+// rate of 0 disables it. Changing the rate clears the counts. It may be
+// called while the store is in use.
+func (s *ShardedInMemoryStore) UseHotKeys(sampleRate int) {
+	h := &s.hotKeys
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.sampleRate.Load() == int64(sampleRate) && h.candidates != nil {
+		return
+	}
+	for i := range h.seeds {
+		h.seeds[i] = maphash.MakeSeed()
+	}
+	clear(h.sketch[:])
+	h.candidates = make(map[string]uint32, hotKeyCandidates)
+	h.lastDecay = time.Now()
+	h.sampleRate.Store(int64(sampleRate))
+}
+
+// touch counts an access to key if it is sampled.
+func (s *ShardedInMemoryStore) touch(key string) {
+	h := &s.hotKeys
+	rate := h.sampleRate.Load()
+	if rate <= 0 || h.accesses.Add(1)%uint64(rate) != 0 {
+		return
+	}
+	h.record(key)
+}
+
+// record counts a sampled access.
+func (h *hotKeyState) record(key string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.candidates == nil {
+		return
+	}
+	if now := time.Now(); now.Sub(h.lastDecay) >= hotKeyDecayInterval {
+		h.decay()
+		h.lastDecay = now
+	}
+
+	estimate := ^uint32(0)
+	for i := range h.sketch {
+		cell := &h.sketch[i][maphash.String(h.seeds[i], key)%sketchWidth]
+		if *cell < ^uint32(0) {
+			*cell++
+		}
+		estimate = min(estimate, *cell)
+	}
+
+	if _, ok := h.candidates[key]; ok || len(h.candidates) < hotKeyCandidates {
+		h.candidates[key] = estimate
+		return
+	}
+	coldest, coldestCount := "", ^uint32(0)
+	for candidate, count := range h.candidates {
+		if count < coldestCount {
+			coldest, coldestCount = candidate, count
+		}
+	}
+	if estimate > coldestCount {
+		delete(h.candidates, coldest)
+		h.candidates[key] = estimate
+	}
+}
+
+// decay halves every count and forgets candidates that reach zero.
+func (h *hotKeyState) decay() {
+	for i := range h.sketch {
+		for j := range h.sketch[i] {
+			h.sketch[i][j] /= 2
+		}
+	}
+	for key, count := range h.candidates {
+		if count /= 2; count == 0 {
+			delete(h.candidates, key)
+		} else {
+			h.candidates[key] = count
+		}
+	}
+}
+
+// HotKeys returns up to n of the most accessed keys, hottest first. Counts
+// are estimates scaled up by the sample rate, and decay over time so that
+// keys that are no longer accessed drop out. It returns nil if hot key
+// tracking is disabled.
+func (s *ShardedInMemoryStore) HotKeys(n int) []HotKey {
+	h := &s.hotKeys
+	h.mu.Lock()
+	rate := h.sampleRate.Load()
+	if rate <= 0 {
+		h.mu.Unlock()
+		return nil
+	}
+	keys := make([]HotKey, 0, len(h.candidates))
+	for key, count := range h.candidates {
+		keys = append(keys, HotKey{Key: key, Accesses: int64(count) * rate})
+	}
+	h.mu.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Accesses != keys[j].Accesses {
+			return keys[i].Accesses > keys[j].Accesses
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	if n < len(keys) {
+		keys = keys[:max(n, 0)]
+	}
+	return keys
+}
+
+// BigKeys returns up to n of the largest keys, largest first. It visits every
+// key, taking each shard's read lock in turn, so it is meant for occasional
+// use.
+func (s *ShardedInMemoryStore) BigKeys(n int) []BigKey {
+	n = min(n, MaxKeyReport)
+	if n <= 0 {
+		return nil
+	}
+	// keys is kept sorted, largest first, and holds at most n keys.
+	keys := make([]BigKey, 0, n)
+	for i, shard := range s.shards {
+		shard.mu.RLock()
+		for key, value := range shard.store {
+			size := len(key) + len(value.Value)
+			if len(keys) == n && size <= keys[n-1].Bytes {
+				continue
+			}
+			at := sort.Search(len(keys), func(j int) bool { return keys[j].Bytes < size })
+			if len(keys) < n {
+				keys = append(keys, BigKey{})
+			}
+			copy(keys[at+1:], keys[at:])
+			keys[at] = BigKey{Key: key, Bytes: size, Shard: i}
+		}
+		shard.mu.RUnlock()
+	}
+	return keys
+}
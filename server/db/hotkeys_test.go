@@ -0,0 +1,79 @@
+package db
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/shafigh75/Memorandum/utils/metrics"
+)
+
+func TestHotKeys(t *testing.T) {
+	store := NewShardedInMemoryStore(4, &DummyWAL{})
+	if keys := store.HotKeys(10); keys != nil {
+		t.Fatalf("expected no hot keys while tracking is disabled, got %v", keys)
+	}
+
+	store.UseHotKeys(1)
+	store.Set("hot", "v", 0)
+	for i := 0; i < 100; i++ {
+		store.Get("hot")
+		store.Get("warm" + strconv.Itoa(i%2))
+	}
+	for i := 0; i < 500; i++ {
+		store.Get("cold" + strconv.Itoa(i)) // more keys than there are candidates
+	}
+
+	keys := store.HotKeys(3)
+	if len(keys) != 3 || keys[0].Key != "hot" || keys[0].Accesses < 101 {
+		t.Fatalf("expected hot first with at least 101 accesses, got %+v", keys)
+	}
+	if keys[1].Key != "warm0" && keys[1].Key != "warm1" {
+		t.Fatalf("expected a warm key second, got %+v", keys)
+	}
+
+	store.UseHotKeys(2)
+	if keys := store.HotKeys(10); len(keys) != 0 {
+		t.Fatalf("expected changing the rate to clear the counts, got %+v", keys)
+	}
+}
+
+func TestBigKeys(t *testing.T) {
+	store := NewShardedInMemoryStore(4, &DummyWAL{})
+	for i := 1; i <= 20; i++ {
+		store.Set("k"+strconv.Itoa(i), strings.Repeat("x", i*10), 0)
+	}
+
+	keys := store.BigKeys(3)
+	if len(keys) != 3 || keys[0].Key != "k20" || keys[1].Key != "k19" || keys[2].Key != "k18" {
+		t.Fatalf("expected the 3 biggest keys, largest first, got %+v", keys)
+	}
+	if keys[0].Bytes != len("k20")+200 {
+		t.Fatalf("expected the size of key and value, got %d", keys[0].Bytes)
+	}
+	if keys := store.BigKeys(100); len(keys) != 20 {
+		t.Fatalf("expected every key, got %d", len(keys))
+	}
+}
+
+func TestKeyMetricsHideKeyNames(t *testing.T) {
+	store := NewShardedInMemoryStore(4, &DummyWAL{})
+	store.UseHotKeys(1)
+	store.Set("token:secret", strings.Repeat("x", 100), 0)
+	store.Get("token:secret")
+
+	registry := metrics.NewRegistry()
+	store.RegisterKeyMetrics(registry, 5)
+	var out strings.Builder
+	if _, err := registry.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "token:secret") {
+		t.Fatalf("key names must not be exported as labels, got\n%s", out.String())
+	}
+	for _, want := range []string{`memorandum_hot_key_accesses{rank="1"} 2`, `memorandum_big_key_bytes{rank="1"} 112`} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected %s, got\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/shafigh75/Memorandum/utils/metrics"
+)
+
+var (
+	walWrittenBytes  = metrics.Default.NewCounter("memorandum_wal_written_bytes_total", "Bytes appended to the WAL file.")
+	walFlushDuration = metrics.Default.NewHistogram("memorandum_wal_flush_duration_seconds", "Time taken to write buffered entries to the WAL file.", metrics.DefBuckets)
+	walFlushErrors   = metrics.Default.NewCounter("memorandum_wal_flush_errors_total", "Failed writes of buffered entries to the WAL file.")
+	expiredKeys      = metrics.Default.NewCounterVec("memorandum_expired_keys_total",
+		"Keys removed because their TTL elapsed, by the expiration cycle (active) or on access (lazy).", "mode")
+)
+
+// bigKeysScanInterval is the minimum time between scans of the store for the
+// big keys metric.
+const bigKeysScanInterval = time.Minute
+
+// RegisterKeyMetrics registers gauges of the top hottest and biggest keys,
+// labelled with their rank, 1 for the hottest or biggest. Key names are left
+// out: anyone who can scrape metrics would see them, and they would make the
+// number of series unbounded. They are listed by HotKeys and BigKeys behind
+// the ACL. The store is scanned for big keys at most once per
+// bigKeysScanInterval.
+func (s *ShardedInMemoryStore) RegisterKeyMetrics(r *metrics.Registry, top int) {
+	r.NewGaugeVecFunc("memorandum_hot_key_accesses", "Estimated recent accesses of the hottest keys, by rank.", []string{"rank"}, func(emit func(float64, ...string)) {
+		for i, key := range s.HotKeys(top) {
+			emit(float64(key.Accesses), strconv.Itoa(i+1))
+		}
+	})
+
+	var mu sync.Mutex
+	var scanned time.Time
+	var big []BigKey
+	r.NewGaugeVecFunc("memorandum_big_key_bytes", "Size of the biggest keys and their values as stored, by rank, updated at most once a minute.", []string{"rank"}, func(emit func(float64, ...string)) {
+		mu.Lock()
+		if time.Since(scanned) >= bigKeysScanInterval {
+			big, scanned = s.BigKeys(top), time.Now()
+		}
+		keys := big
+		mu.Unlock()
+		for i, key := range keys {
+			emit(float64(key.Bytes), strconv.Itoa(i+1))
+		}
+	})
+}
+
+// RegisterMetrics registers gauges that read the state of the store when
+// metrics are collected: keys per shard, keys with a TTL and WAL entries not
+// yet written.
+func (s *ShardedInMemoryStore) RegisterMetrics(r *metrics.Registry) {
+	r.NewGaugeVecFunc("memorandum_keys", "Keys stored, by shard.", []string{"shard"}, func(emit func(float64, ...string)) {
+		for i, count := range s.ShardKeyCounts() {
+			emit(float64(count), strconv.Itoa(i))
+		}
+	})
+	r.NewGaugeFunc("memorandum_expiring_keys", "Keys with a TTL.", func() float64 {
+		return float64(s.expiringKeys())
+	})
+	r.NewCounterFunc("memorandum_expire_cycles_total", "Active expiration cycles run.", func() float64 {
+		return float64(s.ExpireStats().Cycles)
+	})
+	r.NewGaugeFunc("memorandum_wal_queue_depth", "WAL entries queued or buffered but not yet written to the file.", func() float64 {
+		if wal, ok := s.wal.(*WAL); ok {
+			return float64(wal.QueueDepth())
+		}
+		return 0
+	})
+}
@@ -0,0 +1,244 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shafigh75/Memorandum/server/db/dbtest"
+)
+
+// writeTestWAL writes the given entries to a WAL file and returns the byte
+// offset at which each entry starts.
+func writeTestWAL(t *testing.T, path string, entries []WriteAheadLogEntry) []int64 {
+	t.Helper()
+	var buf bytes.Buffer
+	offsets := make([]int64, 0, len(entries))
+	for _, entry := range entries {
+		offsets = append(offsets, int64(buf.Len()))
+		entry.Checksum = entry.computeChecksum()
+		if err := encodeEntry(&buf, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return offsets
+}
+
+func TestRecoverPointInTime(t *testing.T) {
+	dir := t.TempDir()
+	walPath := filepath.Join(dir, "wal.bin")
+	offsets := writeTestWAL(t, walPath, []WriteAheadLogEntry{
+		{Action: "set", Key: "a", Value: []byte("1"), Timestamp: 100},
+		{Action: "set", Key: "b", Value: []byte("2"), Timestamp: 200},
+		{Action: "delete", Key: "a", Timestamp: 300},
+		{Action: "set", Key: "c", Value: []byte("3"), Timestamp: 400},
+	})
+
+	store := NewShardedInMemoryStore(4, &DummyWAL{})
+	header, err := store.RecoverPointInTime(walPath, "", RecoveryTarget{Until: 250})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.WALOffset != offsets[2] {
+		t.Fatalf("expected replay to stop at offset %d, got %d", offsets[2], header.WALOffset)
+	}
+	if v, ok := store.Get("a"); !ok || v != "1" {
+		t.Fatalf("expected a=1 before the delete, got %q %v", v, ok)
+	}
+	if _, ok := store.Get("c"); ok {
+		t.Fatal("c was written after the target and must not be recovered")
+	}
+
+	// Snapshot the state and replay the rest of the WAL on top of it.
+	snapPath := filepath.Join(dir, "base.snap")
+	if err := store.WriteSnapshot(snapPath, header); err != nil {
+		t.Fatal(err)
+	}
+	restored := NewShardedInMemoryStore(4, &DummyWAL{})
+	if _, err := restored.RecoverPointInTime(walPath, snapPath, RecoveryTarget{Offset: offsets[3]}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := restored.Get("a"); ok {
+		t.Fatal("a should have been deleted by the replayed WAL")
+	}
+	if v, ok := restored.Get("b"); !ok || v != "2" {
+		t.Fatalf("expected b=2 from the snapshot, got %q %v", v, ok)
+	}
+	if _, ok := restored.Get("c"); ok {
+		t.Fatal("c starts at the target offset and must not be recovered")
+	}
+}
+
+func TestRecoverRestoresAbsoluteExpirations(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "wal.bin")
+	clock := dbtest.NewFakeClock(time.UnixMilli(1_700_000_000_000))
+	now := clock.Now().UnixMilli()
+	deadline := now + 60_000
+	writeTestWAL(t, walPath, []WriteAheadLogEntry{
+		{Action: ActionSet, Key: "session", Value: []byte("s1"), ExpiresAt: deadline, Timestamp: now - 5_000},
+		{Action: ActionSet, Key: "gone", Value: []byte("old"), Timestamp: now - 4_000},
+		{Action: ActionSet, Key: "gone", Value: []byte("new"), ExpiresAt: now - 1_000, Timestamp: now - 3_000},
+		{Action: ActionSet, Key: "sticky", Value: []byte("v"), ExpiresAt: now + 1_000, Timestamp: now - 2_000},
+		{Action: ActionPersist, Key: "sticky", Timestamp: now - 1_000},
+		{Action: ActionSet, Key: "short", Value: []byte("v"), Timestamp: now - 1_000},
+		{Action: ActionExpire, Key: "short", ExpiresAt: now - 500, Timestamp: now - 900},
+	})
+
+	store := NewShardedInMemoryStore(4, &DummyWAL{})
+	store.UseClock(clock)
+	if err := store.RecoverFromWAL(walPath); err != nil {
+		t.Fatal(err)
+	}
+
+	shard := store.getShard("session")
+	if got := shard.store["session"].Expiration; got != deadline {
+		t.Fatalf("expected the original deadline %d after replay, got %d", deadline, got)
+	}
+	if _, ok := store.Get("gone"); ok {
+		t.Fatal("a key overwritten with an already expired value must not come back")
+	}
+	if _, ok := store.Get("short"); ok {
+		t.Fatal("a key whose updated expiration passed must not come back")
+	}
+	shard = store.getShard("sticky")
+	if got := shard.store["sticky"].Expiration; got != 0 {
+		t.Fatalf("expected persisted key to have no expiration, got %d", got)
+	}
+}
+
+func TestRecoverKeepsKeysAliveAtTarget(t *testing.T) {
+	dir := t.TempDir()
+	walPath := filepath.Join(dir, "wal.bin")
+	snapPath := filepath.Join(dir, "base.snap")
+	clock := dbtest.NewFakeClock(time.UnixMilli(1_700_000_000_000))
+	now := clock.Now().UnixMilli()
+	until := now - 3_000
+	// session expires between the target and now.
+	writeTestWAL(t, walPath, []WriteAheadLogEntry{
+		{Action: ActionSet, Key: "session", Value: []byte("s1"), ExpiresAt: now - 1_000, Timestamp: now - 5_000},
+		{Action: ActionSet, Key: "later", Value: []byte("v"), Timestamp: now - 2_000},
+	})
+
+	store := NewShardedInMemoryStore(4, &DummyWAL{})
+	store.UseClock(clock)
+	header, err := store.RecoverPointInTime(walPath, "", RecoveryTarget{Until: until})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.WriteSnapshot(snapPath, header); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewShardedInMemoryStore(4, &DummyWAL{})
+	restored.UseClock(clock)
+	if _, err := restored.RecoverPointInTime(walPath, snapPath, RecoveryTarget{Until: until}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := restored.getShard("session").store["session"]; !ok {
+		t.Fatal("a key alive at the target must survive the snapshot and its load")
+	}
+	if _, ok := restored.getShard("later").store["later"]; ok {
+		t.Fatal("later was written after the target and must not be recovered")
+	}
+}
+
+func TestRecoverDetectsRewrittenWAL(t *testing.T) {
+	dir := t.TempDir()
+	walPath := filepath.Join(dir, "wal.bin")
+	snapPath := filepath.Join(dir, "base.snap")
+	entries := []WriteAheadLogEntry{
+		{Action: ActionSet, Key: "tmp:1", Value: []byte("x"), Timestamp: 100},
+		{Action: ActionSet, Key: "a", Value: []byte("1"), Timestamp: 200},
+		{Action: ActionSet, Key: "b", Value: []byte("2"), Timestamp: 300},
+	}
+	writeTestWAL(t, walPath, entries)
+	store := NewShardedInMemoryStore(4, &DummyWAL{})
+	header, err := store.RecoverPointInTime(walPath, "", RecoveryTarget{Until: 250})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.WriteSnapshot(snapPath, header); err != nil {
+		t.Fatal(err)
+	}
+
+	// Appending keeps the snapshot usable.
+	writeTestWAL(t, walPath, append(entries, WriteAheadLogEntry{Action: ActionSet, Key: "c", Value: []byte("3"), Timestamp: 400}))
+	restored := NewShardedInMemoryStore(4, &DummyWAL{})
+	if _, err := restored.RecoverPointInTime(walPath, snapPath, RecoveryTarget{}); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := restored.Get("c"); !ok || v != "3" {
+		t.Fatalf("expected c=3 from the appended WAL, got %q %v", v, ok)
+	}
+
+	// Rewriting shifts the offsets, as wal filter and reencrypt do.
+	writeTestWAL(t, walPath, entries[1:])
+	if _, err := NewShardedInMemoryStore(4, &DummyWAL{}).RecoverPointInTime(walPath, snapPath, RecoveryTarget{}); !errors.Is(err, ErrWALMismatch) {
+		t.Fatalf("expected ErrWALMismatch for a rewritten WAL, got %v", err)
+	}
+	writeTestWAL(t, walPath, entries[:1])
+	if _, err := NewShardedInMemoryStore(4, &DummyWAL{}).RecoverPointInTime(walPath, snapPath, RecoveryTarget{}); !errors.Is(err, ErrWALMismatch) {
+		t.Fatalf("expected ErrWALMismatch for a WAL shorter than the snapshot offset, got %v", err)
+	}
+}
+
+func TestDecodeLegacyEntry(t *testing.T) {
+	var buf bytes.Buffer
+	for _, field := range []string{"set", "k", "v"} {
+		binary.Write(&buf, binary.LittleEndian, int32(len(field)))
+		buf.WriteString(field)
+	}
+	binary.Write(&buf, binary.LittleEndian, int64(30))         // relative TTL in seconds
+	binary.Write(&buf, binary.LittleEndian, int64(1700000000)) // timestamp in seconds
+	binary.Write(&buf, binary.LittleEndian, crc32.ChecksumIEEE([]byte("kv")))
+
+	entry, err := decodeEntry(&buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !entry.IsLegacy() || !entry.ValidChecksum() {
+		t.Fatalf("expected a valid legacy entry, got %+v", entry)
+	}
+	if entry.Timestamp != 1700000000000 || entry.ExpiresAt != 1700000030000 {
+		t.Fatalf("legacy times not converted to absolute milliseconds: %+v", entry)
+	}
+}
+
+func TestBinaryValuesRoundTrip(t *testing.T) {
+	blob := []byte{0x00, 0xff, 0xfe, '\n', 0x80, 0x00}
+	store, wal, _ := newTestStore()
+	store.SetBytes("blob", blob, 0)
+	blob[0] = 'x' // the store keeps its own copy
+	if v, ok := store.GetBytes("blob"); !ok || !bytes.Equal(v, []byte{0x00, 0xff, 0xfe, '\n', 0x80, 0x00}) {
+		t.Fatalf("unexpected value %v %v", v, ok)
+	}
+
+	// Replay the logged entry from a WAL file and from a snapshot.
+	dir := t.TempDir()
+	walPath := filepath.Join(dir, "wal.bin")
+	writeTestWAL(t, walPath, wal.entries)
+	replayed := NewShardedInMemoryStore(4, &DummyWAL{})
+	if err := replayed.RecoverFromWAL(walPath); err != nil {
+		t.Fatal(err)
+	}
+	snapPath := filepath.Join(dir, "blob.snap")
+	if err := replayed.WriteSnapshot(snapPath, SnapshotHeader{}); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewShardedInMemoryStore(4, &DummyWAL{})
+	if _, err := loaded.LoadSnapshot(snapPath); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := store.GetBytes("blob")
+	if v, ok := loaded.GetBytes("blob"); !ok || !bytes.Equal(v, want) {
+		t.Fatalf("binary value did not survive the WAL and snapshot: %v %v", v, ok)
+	}
+}
@@ -0,0 +1,226 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// snapshotMagic identifies a Memorandum snapshot file. Version 1 files stored
+// the header timestamp in seconds and version 2 files have no WAL tail
+// checksum; both are still readable.
+var (
+	snapshotMagic   = []byte("MEMSNAP3")
+	snapshotMagicV2 = []byte("MEMSNAP2")
+	snapshotMagicV1 = []byte("MEMSNAP1")
+)
+
+// snapshotHeaderSize returns the size of the header of a snapshot with the
+// given magic, or 0 if it is not a snapshot.
+func snapshotHeaderSize(magic []byte) int {
+	switch {
+	case bytes.Equal(magic, snapshotMagic):
+		return len(snapshotMagic) + 20 // timestamp, WAL offset and WAL tail
+	case bytes.Equal(magic, snapshotMagicV2), bytes.Equal(magic, snapshotMagicV1):
+		return len(snapshotMagic) + 16
+	}
+	return 0
+}
+
+// SnapshotHeader describes the point in time captured by a snapshot file.
+type SnapshotHeader struct {
+	Timestamp int64  // Unix time in milliseconds the snapshot represents
+	WALOffset int64  // WAL byte offset covered by the snapshot; replay resumes here
+	WALTail   uint32 // checksum of the WAL before WALOffset, see WALTail; 0 if unknown
+}
+
+// walTailSize is the number of WAL bytes before a snapshot's WAL offset that
+// its WALTail covers.
+const walTailSize = 4096
+
+// ErrWALMismatch is returned when the WAL no longer matches a snapshot, e.g.
+// because it was rewritten by wal filter or reencrypt after the snapshot was
+// taken, so that the snapshot's WAL offset no longer starts a record.
+var ErrWALMismatch = errors.New("the WAL does not match the snapshot")
+
+// WALTail returns the CRC-32 of the walTailSize bytes of the WAL before
+// offset. Appending to the WAL leaves it unchanged, rewriting the WAL changes
+// it. It fails with ErrWALMismatch if the WAL is shorter than offset.
+func WALTail(walPath string, offset int64) (uint32, error) {
+	file, err := os.Open(walPath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if offset > info.Size() {
+		return 0, fmt.Errorf("%w: it covers WAL offset %d, beyond the end of the %d-byte WAL", ErrWALMismatch, offset, info.Size())
+	}
+	start := max(offset-walTailSize, 0)
+	tail := make([]byte, offset-start)
+	if _, err := file.ReadAt(tail, start); err != nil {
+		return 0, err
+	}
+	return crc32.ChecksumIEEE(tail), nil
+}
+
+// checkWAL reports whether the WAL still matches a snapshot header.
+func checkWAL(walPath string, header SnapshotHeader) error {
+	tail, err := WALTail(walPath, header.WALOffset)
+	if err != nil {
+		return err
+	}
+	if header.WALTail != 0 && tail != header.WALTail {
+		return fmt.Errorf("%w: the WAL was rewritten or replaced since the snapshot was taken", ErrWALMismatch)
+	}
+	return nil
+}
+
+// WriteSnapshot writes every live key in the store to a snapshot file. Each key
+// is stored as a "set" WAL entry so snapshots share the WAL record format. The
+// file is written to a temporary path first and renamed into place.
+func (s *ShardedInMemoryStore) WriteSnapshot(filename string, header SnapshotHeader) error {
+	tmp := filename + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	if err := s.writeSnapshot(file, header); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+// writeSnapshot encodes the header and the entries live at header.Timestamp
+// to w, so that a store recovered to a past point keeps the keys that were
+// alive then. A zero timestamp means now.
+func (s *ShardedInMemoryStore) writeSnapshot(w io.Writer, header SnapshotHeader) error {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.Write(snapshotMagic); err != nil {
+		return err
+	}
+	if err := binary.Write(bw, binary.LittleEndian, header.Timestamp); err != nil {
+		return err
+	}
+	if err := binary.Write(bw, binary.LittleEndian, header.WALOffset); err != nil {
+		return err
+	}
+	if err := binary.Write(bw, binary.LittleEndian, header.WALTail); err != nil {
+		return err
+	}
+
+	asOf := header.Timestamp
+	if asOf == 0 {
+		asOf = s.nowMillis()
+	}
+	var buf bytes.Buffer
+	for _, shard := range s.shards {
+		shard.mu.RLock()
+		for key, value := range shard.store {
+			if value.isExpiredAt(asOf) {
+				continue
+			}
+			entry := WriteAheadLogEntry{
+				Action:     ActionSet,
+				Key:        key,
+				Value:      value.Value,
+				ExpiresAt:  value.Expiration,
+				Timestamp:  header.Timestamp,
+				Compressed: value.Compressed,
+			}
+			entry.Checksum = entry.computeChecksum()
+
+			buf.Reset()
+			if err := encodeRecord(&buf, entry, s.keys); err != nil {
+				shard.mu.RUnlock()
+				return err
+			}
+			if _, err := bw.Write(buf.Bytes()); err != nil {
+				shard.mu.RUnlock()
+				return err
+			}
+		}
+		shard.mu.RUnlock()
+	}
+	return bw.Flush()
+}
+
+// LoadSnapshot loads a snapshot file into the store, keeping the original
+// expiration of every key. Keys that have expired since the snapshot was taken
+// are skipped.
+func (s *ShardedInMemoryStore) LoadSnapshot(filename string) (SnapshotHeader, error) {
+	return s.loadSnapshot(filename, s.nowMillis())
+}
+
+// loadSnapshot loads a snapshot file, skipping the keys that expired before
+// asOf (Unix milliseconds).
+func (s *ShardedInMemoryStore) loadSnapshot(filename string, asOf int64) (SnapshotHeader, error) {
+	var header SnapshotHeader
+	file, err := os.Open(filename)
+	if err != nil {
+		return header, err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return header, err
+	}
+	if snapshotHeaderSize(magic) == 0 {
+		return header, fmt.Errorf("%s is not a snapshot file", filename)
+	}
+	v1 := bytes.Equal(magic, snapshotMagicV1)
+	if err := binary.Read(r, binary.LittleEndian, &header.Timestamp); err != nil {
+		return header, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header.WALOffset); err != nil {
+		return header, err
+	}
+	if bytes.Equal(magic, snapshotMagic) {
+		if err := binary.Read(r, binary.LittleEndian, &header.WALTail); err != nil {
+			return header, err
+		}
+	}
+	if v1 {
+		header.Timestamp *= 1000
+	}
+
+	for {
+		entry, err := decodeEntry(r, s.keys)
+		if err != nil {
+			if err == io.EOF {
+				return header, nil
+			}
+			return header, err
+		}
+		if !entry.ValidChecksum() {
+			return header, fmt.Errorf("invalid checksum for snapshot entry: %v", entry)
+		}
+
+		if entry.IsExpiredAt(asOf) {
+			continue
+		}
+		s.restore(entry.Key, entry.storedValue())
+	}
+}
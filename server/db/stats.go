@@ -0,0 +1,91 @@
+package db
+
+import "time"
+
+// entryOverhead estimates the memory used by a key in addition to the bytes
+// of the key and value: the map entry, the ValueWithTTL and the slice header.
+const entryOverhead = 80
+
+// heapEntryOverhead estimates the memory used by a key in the expiration heap.
+const heapEntryOverhead = 48
+
+// Stats is a snapshot of the contents and activity of a store.
+type Stats struct {
+	Keys         int   // keys stored, including expired keys not removed yet
+	ExpiringKeys int   // keys with a TTL
+	ShardKeys    []int // keys per shard
+	DataBytes    int64 // estimated memory used by keys, values and their bookkeeping
+	Expire       ExpireStats
+	Compression  CompressionStats
+	WAL          WALStats
+}
+
+// WALStats describes the write-ahead log of a store.
+type WALStats struct {
+	Enabled    bool
+	Path       string
+	SizeBytes  int64     // size of the WAL file
+	LastFlush  time.Time // zero if nothing was written since the store started
+	QueueDepth int       // entries queued or buffered but not yet written
+}
+
+// Stats returns a snapshot of the store. It visits every key, taking each
+// shard's read lock in turn, so it is meant for occasional use such as INFO.
+func (s *ShardedInMemoryStore) Stats() Stats {
+	stats := Stats{
+		ShardKeys:   make([]int, len(s.shards)),
+		Expire:      s.ExpireStats(),
+		Compression: s.CompressionStats(),
+	}
+	for i, shard := range s.shards {
+		shard.mu.RLock()
+		stats.ShardKeys[i] = len(shard.store)
+		stats.ExpiringKeys += shard.heap.Len()
+		for key, value := range shard.store {
+			stats.DataBytes += int64(len(key) + len(value.Value) + entryOverhead)
+		}
+		shard.mu.RUnlock()
+		stats.Keys += stats.ShardKeys[i]
+	}
+	stats.DataBytes += int64(stats.ExpiringKeys) * heapEntryOverhead
+	if wal, ok := s.wal.(*WAL); ok {
+		stats.WAL = wal.Stats()
+	}
+	return stats
+}
+
+// ShardKeyCounts returns the number of keys in each shard, including expired
+// keys not removed yet.
+func (s *ShardedInMemoryStore) ShardKeyCounts() []int {
+	counts := make([]int, len(s.shards))
+	for i, shard := range s.shards {
+		shard.mu.RLock()
+		counts[i] = len(shard.store)
+		shard.mu.RUnlock()
+	}
+	return counts
+}
+
+// Stats describes the WAL file and the entries not yet written to it.
+func (wal *WAL) Stats() WALStats {
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+	stats := WALStats{
+		Enabled:    true,
+		Path:       wal.file.Name(),
+		LastFlush:  wal.lastFlush,
+		QueueDepth: len(wal.queue) + len(wal.buffer),
+	}
+	if info, err := wal.file.Stat(); err == nil {
+		stats.SizeBytes = info.Size()
+	}
+	return stats
+}
+
+// QueueDepth returns the number of entries queued or buffered but not yet
+// written to the file.
+func (wal *WAL) QueueDepth() int {
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+	return len(wal.queue) + len(wal.buffer)
+}
@@ -0,0 +1,34 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	wal, err := NewWAL(filepath.Join(t.TempDir(), "wal.bin"), 1, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewShardedInMemoryStore(4, wal)
+	store.Set("a", "1", 0)
+	store.Set("b", "22", 60)
+	store.Set("c", "333", 60)
+	defer store.Close()
+	// With a buffer of one entry, each entry is written as soon as it is queued.
+	for deadline := time.Now().Add(time.Second); wal.Stats().SizeBytes == 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+
+	stats := store.Stats()
+	if stats.Keys != 3 || stats.ExpiringKeys != 2 || len(stats.ShardKeys) != 4 {
+		t.Fatalf("unexpected key counts %+v", stats)
+	}
+	if stats.DataBytes < 9 {
+		t.Fatalf("expected at least the key and value bytes, got %d", stats.DataBytes)
+	}
+	if !stats.WAL.Enabled || stats.WAL.SizeBytes == 0 || stats.WAL.LastFlush.IsZero() {
+		t.Fatalf("unexpected WAL stats %+v", stats.WAL)
+	}
+}
@@ -2,11 +2,12 @@ package db
 
 import (
 	"bytes"
-	"container/heap"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"hash/crc32"
 	"io"
+	"log/slog"
 	"os"
 	"sync"
 	"time"
@@ -16,18 +17,32 @@ import (
 
 // ValueWithTTL represents a value with its expiration time.
 type ValueWithTTL struct {
-	Value      string
-	Expiration int64 // Unix timestamp in seconds
+	Value      []byte
+	Expiration int64 // Unix timestamp in milliseconds, 0 means no expiration
+	Compressed bool  // Value is flate-compressed
 }
 
+// WAL entry actions.
+const (
+	ActionSet     = "set"     // key set, with an optional absolute expiration
+	ActionDelete  = "delete"  // key deleted by a client
+	ActionExpire  = "expire"  // expiration of an existing key changed
+	ActionPersist = "persist" // expiration of an existing key removed
+	ActionExpired = "expired" // key removed because its expiration passed
+)
+
 // WriteAheadLogEntry represents a binary log entry for WAL.
 type WriteAheadLogEntry struct {
-	Action    string
-	Key       string
-	Value     string
-	TTL       int64
-	Timestamp int64
-	Checksum  uint32 // Integrity check using CRC32
+	Action     string
+	Key        string
+	Value      []byte
+	ExpiresAt  int64  // absolute expiration in Unix milliseconds, 0 means none
+	Timestamp  int64  // time the entry was written in Unix milliseconds
+	Checksum   uint32 // Integrity check using CRC32
+	Compressed bool   // Value is flate-compressed
+	KeyID      string // ID of the key the record was encrypted with, empty if plaintext
+	legacy     bool   // decoded from a pre-v2 record (relative TTL, seconds)
+	v2         bool   // decoded from a v2 record, which has no flags byte
 }
 
 // WAL represents the Write-Ahead Log.
@@ -40,11 +55,13 @@ type WAL struct {
 	flushDone   chan struct{}
 	queue       chan WriteAheadLogEntry
 	queueWG     sync.WaitGroup
+	keys        *Keyring  // encrypts records when set
+	lastFlush   time.Time // time of the last successful write to the file
 }
 
 // NewWAL creates a new WAL instance.
 func NewWAL(filename string, bufferSize int, flushInterval time.Duration) (*WAL, error) {
-	file, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
 	if err != nil {
 		return nil, err
 	}
@@ -64,14 +81,20 @@ func NewWAL(filename string, bufferSize int, flushInterval time.Duration) (*WAL,
 	return wal, nil
 }
 
+// UseKeyring encrypts the records written from now on with the active key of
+// keys, or stops encrypting if keys is nil.
+func (wal *WAL) UseKeyring(keys *Keyring) {
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+	wal.keys = keys
+}
+
 // DummyWAL is a no-op WAL implementation.
 type DummyWAL struct{}
 
 func (d *DummyWAL) Log(entry WriteAheadLogEntry) error { return nil }
 func (d *DummyWAL) Close() error                       { return nil }
 
-var isWalRecovery bool
-
 // Log writes a log entry to the WAL.
 func (wal *WAL) Log(entry WriteAheadLogEntry) error {
 	wal.queue <- entry
@@ -84,17 +107,24 @@ func (wal *WAL) flush() error {
 		return nil
 	}
 
+	start := time.Now()
 	var buf bytes.Buffer
 	for _, entry := range wal.buffer {
-		if err := encodeEntry(&buf, entry); err != nil {
+		if err := encodeRecord(&buf, entry, wal.keys); err != nil {
+			walFlushErrors.Inc()
 			return err
 		}
 	}
 
 	// Write binary data to the WAL file
-	if _, err := wal.file.Write(buf.Bytes()); err != nil {
+	n, err := wal.file.Write(buf.Bytes())
+	walWrittenBytes.Add(float64(n))
+	if err != nil {
+		walFlushErrors.Inc()
 		return err
 	}
+	walFlushDuration.Observe(time.Since(start).Seconds())
+	wal.lastFlush = time.Now()
 
 	wal.buffer = wal.buffer[:0] // Clear the buffer
 	return nil
@@ -108,7 +138,7 @@ func (wal *WAL) startFlushRoutine() {
 		case <-wal.flushTicker.C:
 			wal.mu.Lock()
 			if err := wal.flush(); err != nil {
-				fmt.Println("Error flushing WAL: ", err.Error())
+				slog.Error("flushing WAL failed", "err", err)
 			}
 			wal.mu.Unlock()
 		}
@@ -120,11 +150,11 @@ func (wal *WAL) startQueueProcessor() {
 	defer wal.queueWG.Done()
 	for entry := range wal.queue {
 		wal.mu.Lock()
-		entry.Checksum = crc32.ChecksumIEEE([]byte(entry.Key + entry.Value))
+		entry.Checksum = entry.computeChecksum()
 		wal.buffer = append(wal.buffer, entry)
 		if len(wal.buffer) >= wal.bufferSize {
 			if err := wal.flush(); err != nil {
-				fmt.Println("Error flushing WAL: ", err.Error())
+				slog.Error("flushing WAL failed", "err", err)
 			}
 		}
 		wal.mu.Unlock()
@@ -144,90 +174,252 @@ func (wal *WAL) Close() error {
 	return wal.file.Close()
 }
 
+// walFormatV2 marks a record written with absolute expirations. Legacy
+// records start directly with the (non-negative) action length.
+const walFormatV2 int32 = -2
+
+// walFormatV3 marks a v2 record followed by a flags byte describing how the
+// value is encoded.
+const walFormatV3 int32 = -3
+
+// Record flags of v3 records.
+const flagCompressed uint8 = 1 << 0
+
 // encodeEntry encodes a WriteAheadLogEntry into binary format.
 func encodeEntry(buf *bytes.Buffer, entry WriteAheadLogEntry) error {
-	if err := binary.Write(buf, binary.LittleEndian, int32(len(entry.Action))); err != nil {
-		return err
+	marker := walFormatV3
+	if entry.v2 {
+		marker = walFormatV2
 	}
-	if _, err := buf.WriteString(entry.Action); err != nil {
+	if err := binary.Write(buf, binary.LittleEndian, marker); err != nil {
 		return err
 	}
-	if err := binary.Write(buf, binary.LittleEndian, int32(len(entry.Key))); err != nil {
+	if err := writeEntryBody(buf, entry); err != nil {
 		return err
 	}
-	if _, err := buf.WriteString(entry.Key); err != nil {
-		return err
+	return binary.Write(buf, binary.LittleEndian, entry.Checksum)
+}
+
+// writeEntryBody writes the checksummed part of an entry.
+func writeEntryBody(w io.Writer, entry WriteAheadLogEntry) error {
+	for _, field := range []string{entry.Action, entry.Key} {
+		if err := writeField(w, []byte(field)); err != nil {
+			return err
+		}
 	}
-	if err := binary.Write(buf, binary.LittleEndian, int32(len(entry.Value))); err != nil {
+	if err := writeField(w, entry.Value); err != nil {
 		return err
 	}
-	if _, err := buf.WriteString(entry.Value); err != nil {
+	if err := binary.Write(w, binary.LittleEndian, entry.ExpiresAt); err != nil {
 		return err
 	}
-	if err := binary.Write(buf, binary.LittleEndian, entry.TTL); err != nil {
+	if err := binary.Write(w, binary.LittleEndian, entry.Timestamp); err != nil {
 		return err
 	}
-	if err := binary.Write(buf, binary.LittleEndian, entry.Timestamp); err != nil {
+	if entry.v2 {
+		return nil
+	}
+	var flags uint8
+	if entry.Compressed {
+		flags |= flagCompressed
+	}
+	_, err := w.Write([]byte{flags})
+	return err
+}
+
+// writeField writes a length-prefixed field to the given writer.
+func writeField(w io.Writer, field []byte) error {
+	if err := binary.Write(w, binary.LittleEndian, int32(len(field))); err != nil {
 		return err
 	}
-	return binary.Write(buf, binary.LittleEndian, entry.Checksum)
+	_, err := w.Write(field)
+	return err
 }
 
-// decodeEntry decodes a binary WAL entry from the given reader.
-func decodeEntry(r io.Reader) (WriteAheadLogEntry, error) {
+// maxFieldLen bounds the length prefixes accepted by decodeEntry so that a
+// corrupted WAL cannot trigger huge allocations.
+const maxFieldLen = 512 << 20
+
+// ErrCorruptEntry is returned when a WAL entry cannot be decoded.
+var ErrCorruptEntry = errors.New("corrupt WAL entry")
+
+// readField reads a length-prefixed string field from the given reader.
+func readField(r io.Reader) (string, error) {
+	field, err := readBytesField(r)
+	return string(field), err
+}
+
+// readBytesField reads a length-prefixed binary field from the given reader.
+func readBytesField(r io.Reader) ([]byte, error) {
+	var length int32
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return nil, err
+	}
+	return readFieldData(r, length)
+}
+
+// readFieldData reads a field whose length prefix has already been consumed.
+func readFieldData(r io.Reader, length int32) ([]byte, error) {
+	if length < 0 || length > maxFieldLen {
+		return nil, fmt.Errorf("%w: invalid field length %d", ErrCorruptEntry, length)
+	}
+	field := make([]byte, length)
+	if _, err := io.ReadFull(r, field); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return field, nil
+}
+
+// decodeEntry decodes a binary WAL entry from the given reader. It returns
+// io.EOF only when the reader is exhausted before the entry starts; a
+// truncated entry yields io.ErrUnexpectedEOF.
+func decodeEntry(r io.Reader, keys *Keyring) (WriteAheadLogEntry, error) {
 	var entry WriteAheadLogEntry
 
-	var actionLen, keyLen, valueLen int32
-	if err := binary.Read(r, binary.LittleEndian, &actionLen); err != nil {
+	var marker int32
+	if err := binary.Read(r, binary.LittleEndian, &marker); err != nil {
 		return entry, err
 	}
 
-	action := make([]byte, actionLen)
-	if _, err := io.ReadFull(r, action); err != nil {
-		return entry, err
+	var err error
+	switch {
+	case marker == walFormatEncrypted:
+		entry, err = decodeEncryptedEntry(r, keys)
+	case marker == walFormatV3, marker == walFormatV2:
+		entry, err = decodeEntryV2(r, marker == walFormatV2)
+	case marker >= 0:
+		entry, err = decodeLegacyEntry(r, marker)
+	default:
+		err = fmt.Errorf("%w: unknown record format %d", ErrCorruptEntry, marker)
 	}
-	entry.Action = string(action)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return entry, err
+}
 
-	if err := binary.Read(r, binary.LittleEndian, &keyLen); err != nil {
+// decodeEntryV2 decodes a record with absolute millisecond expirations,
+// followed by a flags byte unless it is a v2 record.
+func decodeEntryV2(r io.Reader, v2 bool) (WriteAheadLogEntry, error) {
+	entry := WriteAheadLogEntry{v2: v2}
+	var err error
+	if entry.Action, err = readField(r); err != nil {
 		return entry, err
 	}
-
-	key := make([]byte, keyLen)
-	if _, err := io.ReadFull(r, key); err != nil {
+	if entry.Key, err = readField(r); err != nil {
 		return entry, err
 	}
-	entry.Key = string(key)
-
-	if err := binary.Read(r, binary.LittleEndian, &valueLen); err != nil {
+	if entry.Value, err = readBytesField(r); err != nil {
 		return entry, err
 	}
-
-	value := make([]byte, valueLen)
-	if _, err := io.ReadFull(r, value); err != nil {
+	if err := binary.Read(r, binary.LittleEndian, &entry.ExpiresAt); err != nil {
+		return entry, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &entry.Timestamp); err != nil {
+		return entry, err
+	}
+	if !v2 {
+		var flags uint8
+		if err := binary.Read(r, binary.LittleEndian, &flags); err != nil {
+			return entry, err
+		}
+		if flags&^flagCompressed != 0 {
+			return entry, fmt.Errorf("%w: unknown record flags %#x", ErrCorruptEntry, flags)
+		}
+		entry.Compressed = flags&flagCompressed != 0
+	}
+	if err := binary.Read(r, binary.LittleEndian, &entry.Checksum); err != nil {
 		return entry, err
 	}
-	entry.Value = string(value)
+	return entry, nil
+}
 
-	if err := binary.Read(r, binary.LittleEndian, &entry.TTL); err != nil {
+// decodeLegacyEntry decodes a record written before absolute expirations,
+// which stored a relative TTL and a timestamp in seconds. Both are converted
+// to the current millisecond representation.
+func decodeLegacyEntry(r io.Reader, actionLen int32) (WriteAheadLogEntry, error) {
+	entry := WriteAheadLogEntry{legacy: true}
+	action, err := readFieldData(r, actionLen)
+	if err != nil {
+		return entry, err
+	}
+	entry.Action = string(action)
+	if entry.Key, err = readField(r); err != nil {
+		return entry, err
+	}
+	if entry.Value, err = readBytesField(r); err != nil {
 		return entry, err
 	}
 
+	var ttl int64
+	if err := binary.Read(r, binary.LittleEndian, &ttl); err != nil {
+		return entry, err
+	}
 	if err := binary.Read(r, binary.LittleEndian, &entry.Timestamp); err != nil {
 		return entry, err
 	}
-
 	if err := binary.Read(r, binary.LittleEndian, &entry.Checksum); err != nil {
 		return entry, err
 	}
 
+	if ttl != 0 {
+		entry.ExpiresAt = (entry.Timestamp + ttl) * 1000
+	}
+	entry.Timestamp *= 1000
 	return entry, nil
 }
 
+// computeChecksum returns the CRC32 integrity checksum of the entry.
+func (entry *WriteAheadLogEntry) computeChecksum() uint32 {
+	if entry.legacy {
+		return crc32.Update(crc32.ChecksumIEEE([]byte(entry.Key)), crc32.IEEETable, entry.Value)
+	}
+	hash := crc32.NewIEEE()
+	writeEntryBody(hash, *entry)
+	return hash.Sum32()
+}
+
+// ValidChecksum reports whether the stored checksum matches the entry contents.
+func (entry *WriteAheadLogEntry) ValidChecksum() bool {
+	return entry.Checksum == entry.computeChecksum()
+}
+
+// IsLegacy reports whether the entry was decoded from the legacy record format.
+func (entry *WriteAheadLogEntry) IsLegacy() bool {
+	return entry.legacy
+}
+
+// upgrade converts a legacy or v2 entry with a valid checksum to the current
+// format so that it can be re-encoded. Corrupt entries are left untouched.
+func (entry *WriteAheadLogEntry) upgrade() {
+	if (entry.legacy || entry.v2) && entry.ValidChecksum() {
+		entry.legacy = false
+		entry.v2 = false
+		entry.Checksum = entry.computeChecksum()
+	}
+}
+
+// PlainValue returns the value of the entry, decompressing it if needed.
+func (entry *WriteAheadLogEntry) PlainValue() ([]byte, error) {
+	if !entry.Compressed {
+		return entry.Value, nil
+	}
+	return decompressValue(entry.Value)
+}
+
 // ShardedInMemoryStore represents a sharded in-memory key-value store with TTL.
 type ShardedInMemoryStore struct {
-	shards    []*mapShard
-	numShards int
-	wal       WALInterface
+	shards      []*mapShard
+	numShards   int
+	wal         WALInterface
+	clock       Clock
+	expire      expireState
+	compression compressionState
+	hotKeys     hotKeyState
+	keys        *Keyring // encrypts snapshots and decrypts the WAL and snapshots
 }
 
 // mapShard represents a single shard of the in-memory store.
@@ -248,14 +440,14 @@ func NewShardedInMemoryStore(numShards int, wal WALInterface) *ShardedInMemorySt
 	for i := 0; i < numShards; i++ {
 		shards[i] = &mapShard{
 			store: make(map[string]ValueWithTTL),
-			heap:  make(MinHeap, 0),
+			heap:  newMinHeap(),
 		}
-		heap.Init(&shards[i].heap)
 	}
 	return &ShardedInMemoryStore{
 		shards:    shards,
 		numShards: numShards,
 		wal:       wal,
+		clock:     systemClock{},
 	}
 }
 
@@ -265,61 +457,96 @@ func (s *ShardedInMemoryStore) getShard(key string) *mapShard {
 	return s.shards[int(hash)%s.numShards]
 }
 
-// Set adds a key-value pair to the store with an optional TTL.
+// isExpiredAt reports whether the value had expired at the given Unix millisecond time.
+func (v ValueWithTTL) isExpiredAt(now int64) bool {
+	return v.Expiration > 0 && now > v.Expiration
+}
+
+// logEntry stamps an entry with the current time and writes it to the WAL.
+func (s *ShardedInMemoryStore) logEntry(entry WriteAheadLogEntry) {
+	entry.Timestamp = s.nowMillis()
+	if err := s.wal.Log(entry); err != nil {
+		slog.Error("writing to WAL failed", "key", entry.Key, "err", err)
+	}
+}
+
+// Set adds a key-value pair to the store with an optional TTL in seconds.
 func (s *ShardedInMemoryStore) Set(key, value string, ttl int64) {
+	s.pset(key, []byte(value), ttl*1000)
+}
+
+// PSet adds a key-value pair to the store with an optional TTL in milliseconds.
+func (s *ShardedInMemoryStore) PSet(key, value string, ttl int64) {
+	s.pset(key, []byte(value), ttl)
+}
+
+// SetBytes adds a binary value to the store with an optional TTL in seconds.
+// The value is copied, so the caller may reuse it.
+func (s *ShardedInMemoryStore) SetBytes(key string, value []byte, ttl int64) {
+	s.pset(key, bytes.Clone(value), ttl*1000)
+}
+
+// PSetBytes is like SetBytes with the TTL given in milliseconds.
+func (s *ShardedInMemoryStore) PSetBytes(key string, value []byte, ttl int64) {
+	s.pset(key, bytes.Clone(value), ttl)
+}
+
+// pset stores value, which must not be modified afterwards, with an optional
+// TTL in milliseconds.
+func (s *ShardedInMemoryStore) pset(key string, value []byte, ttl int64) {
+	s.touch(key)
+	// Compressing a large value takes a while; the shard stays unlocked.
+	stored := s.encodeValue(value)
 	shard := s.getShard(key)
 	shard.mu.Lock()
 	defer shard.mu.Unlock()
-	_, exists := shard.store[key]
-
-	// delete the key from heap
-	if exists {
-		shard.heap.RemoveByKey(key)
-	}
-
 	var expiration int64
-	if ttl == 0 {
-		expiration = 0
-	} else {
-		expiration = time.Now().Add(time.Duration(ttl) * time.Second).Unix()
-	}
-	shard.store[key] = ValueWithTTL{Value: value, Expiration: expiration}
-
-	// Update the min-heap
-	heap.Push(&shard.heap, heapEntry{key: key, valueWithTTL: shard.store[key]})
-	// Log the operation
-	entry := WriteAheadLogEntry{
-		Action:    "set",
-		Key:       key,
-		Value:     value,
-		TTL:       ttl,
-		Timestamp: time.Now().Unix(),
-	}
-	if !isWalRecovery {
-		if err := s.wal.Log(entry); err != nil {
-			fmt.Println("Error writing to WAL: ", err.Error())
-		}
-	}
+	if ttl != 0 {
+		expiration = s.nowMillis() + ttl
+	}
+	stored.Expiration = expiration
+	shard.store[key] = stored
+
+	// Update the expiration index
+	shard.heap.Update(key, expiration)
+	// Log the operation with its absolute expiration
+	s.logEntry(WriteAheadLogEntry{
+		Action:     ActionSet,
+		Key:        key,
+		Value:      stored.Value,
+		ExpiresAt:  expiration,
+		Compressed: stored.Compressed,
+	})
 }
 
 // Get retrieves a value by key from the store, checking for expiration.
 func (s *ShardedInMemoryStore) Get(key string) (string, bool) {
+	value, ok := s.GetBytes(key)
+	return string(value), ok
+}
+
+// GetBytes retrieves a binary value by key from the store, checking for
+// expiration. The returned slice is shared with the store and must not be
+// modified.
+func (s *ShardedInMemoryStore) GetBytes(key string) ([]byte, bool) {
+	s.touch(key)
 	shard := s.getShard(key)
 	shard.mu.RLock()
 	valueWithTTL, exists := shard.store[key]
 	shard.mu.RUnlock()
 
-	if !exists || (valueWithTTL.Expiration > 0 && time.Now().Unix() > valueWithTTL.Expiration) {
+	if !exists || valueWithTTL.isExpiredAt(s.nowMillis()) {
 		if exists {
-			s.Delete(key)
+			s.deleteExpired(key)
 		}
-		return "", false
+		return nil, false
 	}
-	return valueWithTTL.Value, true
+	return s.plainValue(key, valueWithTTL)
 }
 
 // Delete removes a key-value pair from the store.
 func (s *ShardedInMemoryStore) Delete(key string) {
+	s.touch(key)
 	shard := s.getShard(key)
 	shard.mu.Lock()
 	defer shard.mu.Unlock()
@@ -327,90 +554,43 @@ func (s *ShardedInMemoryStore) Delete(key string) {
 
 	shard.heap.RemoveByKey(key)
 	// Log the delete operation
-	entry := WriteAheadLogEntry{
-		Action:    "delete",
-		Key:       key,
-		Timestamp: time.Now().Unix(),
-	}
-	if !isWalRecovery {
-		if err := s.wal.Log(entry); err != nil {
-			fmt.Println("Error writing to WAL: ", err.Error())
-		}
+	s.logEntry(WriteAheadLogEntry{
+		Action: ActionDelete,
+		Key:    key,
+	})
+}
+
+// deleteExpired removes a key found to be expired on access. The expiration
+// is checked again under the write lock in case the key was updated meanwhile.
+func (s *ShardedInMemoryStore) deleteExpired(key string) {
+	shard := s.getShard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	value, exists := shard.store[key]
+	if !exists || !value.isExpiredAt(s.nowMillis()) {
+		return
 	}
+	delete(shard.store, key)
+	shard.heap.RemoveByKey(key)
+	s.logEntry(WriteAheadLogEntry{Action: ActionExpired, Key: key})
+	expiredKeys.With("lazy").Inc()
 }
 
-// Cleanup removes expired keys from the store using the min-heap.
+// Cleanup removes all expired keys from the store using the min-heap. Shard
+// locks are still taken one batch at a time, but there is no time budget.
 func (s *ShardedInMemoryStore) Cleanup() {
-	for _, shard := range s.shards {
-		shard.mu.Lock()
-		now := time.Now().Unix()
-		for shard.heap.Len() > 0 {
-			// Get the entry with the smallest expiration time
-			entry := heap.Pop(&shard.heap).(heapEntry)
-			// If the smallest expiration time is in the future, stop the cleanup
-			if entry.valueWithTTL.Expiration > now {
-				heap.Push(&shard.heap, entry)
-				// Push it back to the heap
-				break
-			}
-			// Delete the expired entry from the store
-			if entry.valueWithTTL.Expiration > 0 && now > entry.valueWithTTL.Expiration {
-				delete(shard.store, entry.key)
-			}
-		}
-		shard.mu.Unlock()
-	}
+	s.ExpireCycle(DefaultExpireBatchSize, 0)
 }
 
-// StartCleanupRoutine starts a background goroutine to periodically clean up expired keys.
+// StartCleanupRoutine starts a background goroutine to periodically clean up
+// expired keys with the default batch size and time budget.
 func (s *ShardedInMemoryStore) StartCleanupRoutine(interval time.Duration) {
-	go func() {
-		ticker := time.NewTicker(interval)
-		defer ticker.Stop()
-		for {
-			<-ticker.C
-			s.Cleanup()
-		}
-	}()
+	s.StartExpireRoutine(ExpireConfig{Interval: interval})
 }
 
 // RecoverFromWAL replays the WAL to restore the state of the store.
 func (s *ShardedInMemoryStore) RecoverFromWAL(filename string) error {
-	file, err := os.Open(filename)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	isWalRecovery = true
-	for {
-		entry, err := decodeEntry(file)
-		if err != nil {
-			if err == io.EOF {
-				break // End of file reached, exit the loop gracefully
-			}
-			return err // Return any other error
-		}
-
-		// Validate checksum to ensure entry integrity
-		expectedChecksum := crc32.ChecksumIEEE([]byte(entry.Key + entry.Value))
-		if entry.Checksum != expectedChecksum {
-			return fmt.Errorf("invalid checksum for entry: %v", entry)
-		}
-
-		if entry.TTL != 0 && entry.IsExpired() {
-			continue
-		}
-
-		switch entry.Action {
-		case "set":
-			s.Set(entry.Key, entry.Value, entry.TTL)
-		case "delete":
-			s.Delete(entry.Key)
-		}
-	}
-	isWalRecovery = false
-	return nil
+	return s.RecoverToTarget(filename, 0, RecoveryTarget{})
 }
 
 // LoadConfigAndCreateStore loads the config file and initializes the store.
@@ -419,18 +599,36 @@ func LoadConfigAndCreateStore(configPath string) (*ShardedInMemoryStore, error)
 	if err != nil {
 		return nil, err
 	}
+	return NewStoreFromConfig(cfg)
+}
+
+// NewStoreFromConfig initializes the store, its WAL and encryption from the
+// configuration and replays the WAL.
+func NewStoreFromConfig(cfg *config.Config) (*ShardedInMemoryStore, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	keys, err := LoadKeyring(cfg.EncryptionKeyFile)
+	if err != nil {
+		return nil, err
+	}
 
 	var wal WALInterface
 	if cfg.WalEnabled {
-		wal, err = NewWAL(cfg.WalPath, cfg.WalBufferSize, time.Duration(cfg.WalFlushInterval)*time.Second)
+		fileWAL, err := NewWAL(cfg.WalPath, cfg.WalBufferSize, cfg.WalFlushInterval.Duration())
 		if err != nil {
 			return nil, err
 		}
+		fileWAL.UseKeyring(keys)
+		wal = fileWAL
 	} else {
 		wal = &DummyWAL{}
 	}
 
 	store := NewShardedInMemoryStore(cfg.NumShards, wal)
+	store.UseCompression(cfg.CompressionThreshold)
+	store.UseKeyring(keys)
+	store.UseHotKeys(cfg.HotKeysSampleRate)
 
 	if cfg.WalEnabled {
 		if err := store.RecoverFromWAL(cfg.WalPath); err != nil {
@@ -441,15 +639,19 @@ func LoadConfigAndCreateStore(configPath string) (*ShardedInMemoryStore, error)
 	return store, nil
 }
 
-// Close cleans up resources, including closing the WAL.
+// Close stops the expiration routine, removes expired keys and closes the WAL.
 func (s *ShardedInMemoryStore) Close() error {
+	s.stopExpireRoutine()
 	s.Cleanup()
 	return s.wal.Close()
 }
 
 // IsExpired checks if the entry has expired based on the current time.
 func (entry *WriteAheadLogEntry) IsExpired() bool {
-	currentTime := time.Now().Unix()
-	expirationTime := entry.Timestamp + entry.TTL
-	return currentTime > expirationTime // Return true if current time is greater than expiration time
+	return entry.IsExpiredAt(time.Now().UnixMilli())
+}
+
+// IsExpiredAt checks if the entry had expired at the given Unix millisecond time.
+func (entry *WriteAheadLogEntry) IsExpiredAt(at int64) bool {
+	return entry.ExpiresAt != 0 && at > entry.ExpiresAt
 }
@@ -0,0 +1,157 @@
+package db
+
+// Special results returned by TTL and PTTL, mirroring Redis.
+const (
+	TTLNoExpiration int64 = -1 // the key exists but has no expiration
+	TTLKeyNotFound  int64 = -2 // the key does not exist or has expired
+)
+
+// PTTL returns the remaining time to live of a key in milliseconds, or one of
+// TTLNoExpiration and TTLKeyNotFound.
+func (s *ShardedInMemoryStore) PTTL(key string) int64 {
+	s.touch(key)
+	shard := s.getShard(key)
+	shard.mu.RLock()
+	value, exists := shard.store[key]
+	shard.mu.RUnlock()
+
+	now := s.nowMillis()
+	switch {
+	case !exists:
+		return TTLKeyNotFound
+	case value.isExpiredAt(now):
+		s.deleteExpired(key)
+		return TTLKeyNotFound
+	case value.Expiration == 0:
+		return TTLNoExpiration
+	}
+	return value.Expiration - now
+}
+
+// TTL returns the remaining time to live of a key in seconds (rounded), or one
+// of TTLNoExpiration and TTLKeyNotFound.
+func (s *ShardedInMemoryStore) TTL(key string) int64 {
+	ttl := s.PTTL(key)
+	if ttl < 0 {
+		return ttl
+	}
+	return (ttl + 500) / 1000
+}
+
+// Expire sets a key to expire after ttl seconds. A non-positive ttl deletes
+// the key. It returns false if the key does not exist.
+func (s *ShardedInMemoryStore) Expire(key string, ttl int64) bool {
+	return s.PExpireAt(key, s.nowMillis()+ttl*1000)
+}
+
+// PExpire sets a key to expire after ttl milliseconds.
+func (s *ShardedInMemoryStore) PExpire(key string, ttl int64) bool {
+	return s.PExpireAt(key, s.nowMillis()+ttl)
+}
+
+// ExpireAt sets a key to expire at the given Unix time in seconds.
+func (s *ShardedInMemoryStore) ExpireAt(key string, at int64) bool {
+	return s.PExpireAt(key, at*1000)
+}
+
+// PExpireAt sets a key to expire at the given Unix time in milliseconds. A
+// time in the past deletes the key. It returns false if the key does not exist.
+func (s *ShardedInMemoryStore) PExpireAt(key string, at int64) bool {
+	s.touch(key)
+	shard := s.getShard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	value, ok := s.liveValue(shard, key)
+	if !ok {
+		return false
+	}
+
+	if at <= s.nowMillis() {
+		delete(shard.store, key)
+		shard.heap.RemoveByKey(key)
+		s.logEntry(WriteAheadLogEntry{Action: ActionDelete, Key: key})
+		return true
+	}
+	s.setExpiration(shard, key, value, at)
+	return true
+}
+
+// Persist removes the expiration of a key. It returns false if the key does
+// not exist or has no expiration.
+func (s *ShardedInMemoryStore) Persist(key string) bool {
+	s.touch(key)
+	shard := s.getShard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	value, ok := s.liveValue(shard, key)
+	if !ok || value.Expiration == 0 {
+		return false
+	}
+	s.setExpiration(shard, key, value, 0)
+	return true
+}
+
+// GetEx returns the value of a key and resets its expiration to ttl seconds
+// from now, which gives sliding expirations. A ttl of 0 removes the
+// expiration, and a negative ttl deletes the key as Expire does.
+func (s *ShardedInMemoryStore) GetEx(key string, ttl int64) (string, bool) {
+	return s.PGetEx(key, ttl*1000)
+}
+
+// PGetEx is like GetEx with the ttl given in milliseconds.
+func (s *ShardedInMemoryStore) PGetEx(key string, ttl int64) (string, bool) {
+	s.touch(key)
+	shard := s.getShard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	value, ok := s.liveValue(shard, key)
+	if !ok {
+		return "", false
+	}
+
+	plain, ok := s.plainValue(key, value)
+	if ttl < 0 {
+		delete(shard.store, key)
+		shard.heap.RemoveByKey(key)
+		s.logEntry(WriteAheadLogEntry{Action: ActionDelete, Key: key})
+		return string(plain), ok
+	}
+	var expiration int64
+	if ttl != 0 {
+		expiration = s.nowMillis() + ttl
+	}
+	if expiration != value.Expiration {
+		s.setExpiration(shard, key, value, expiration)
+	}
+	return string(plain), ok
+}
+
+// liveValue returns the value of a key, removing it if it has expired. The
+// caller must hold the shard's write lock.
+func (s *ShardedInMemoryStore) liveValue(shard *mapShard, key string) (ValueWithTTL, bool) {
+	value, exists := shard.store[key]
+	if !exists {
+		return value, false
+	}
+	if value.isExpiredAt(s.nowMillis()) {
+		delete(shard.store, key)
+		shard.heap.RemoveByKey(key)
+		s.logEntry(WriteAheadLogEntry{Action: ActionExpired, Key: key})
+		return value, false
+	}
+	return value, true
+}
+
+// setExpiration updates the expiration of an existing key in the store, the
+// shard's min-heap and the WAL. The caller must hold the shard's write lock.
+func (s *ShardedInMemoryStore) setExpiration(shard *mapShard, key string, value ValueWithTTL, expiresAt int64) {
+	value.Expiration = expiresAt
+	shard.store[key] = value
+	shard.heap.Update(key, expiresAt)
+
+	if expiresAt == 0 {
+		s.logEntry(WriteAheadLogEntry{Action: ActionPersist, Key: key})
+		return
+	}
+	s.logEntry(WriteAheadLogEntry{Action: ActionExpire, Key: key, ExpiresAt: expiresAt})
+}
@@ -0,0 +1,135 @@
+package db
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shafigh75/Memorandum/server/db/dbtest"
+)
+
+// recordingWAL keeps logged entries in memory.
+type recordingWAL struct {
+	mu      sync.Mutex
+	entries []WriteAheadLogEntry
+}
+
+func (r *recordingWAL) Log(entry WriteAheadLogEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, entry)
+	return nil
+}
+
+func (r *recordingWAL) Close() error { return nil }
+
+func (r *recordingWAL) actions() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	actions := make([]string, 0, len(r.entries))
+	for _, entry := range r.entries {
+		actions = append(actions, entry.Action)
+	}
+	return actions
+}
+
+// newTestStore returns a store backed by a recording WAL and a fake clock.
+func newTestStore() (*ShardedInMemoryStore, *recordingWAL, *dbtest.FakeClock) {
+	wal := &recordingWAL{}
+	clock := dbtest.NewFakeClock(time.UnixMilli(1_700_000_000_000))
+	store := NewShardedInMemoryStore(4, wal)
+	store.UseClock(clock)
+	return store, wal, clock
+}
+
+func TestExpirationCommands(t *testing.T) {
+	store, wal, _ := newTestStore()
+
+	if ttl := store.TTL("missing"); ttl != TTLKeyNotFound {
+		t.Fatalf("expected %d for a missing key, got %d", TTLKeyNotFound, ttl)
+	}
+	store.Set("k", "v", 0)
+	if ttl := store.TTL("k"); ttl != TTLNoExpiration {
+		t.Fatalf("expected %d for a key without expiration, got %d", TTLNoExpiration, ttl)
+	}
+	if n := store.getShard("k").heap.Len(); n != 0 {
+		t.Fatalf("keys without expiration must not be tracked by the expiration index, got %d", n)
+	}
+	if !store.Expire("k", 100) || store.TTL("k") != 100 {
+		t.Fatalf("expected TTL 100 after Expire, got %d", store.TTL("k"))
+	}
+	if !store.Persist("k") || store.TTL("k") != TTLNoExpiration {
+		t.Fatal("expected Persist to remove the expiration")
+	}
+	if store.Persist("k") {
+		t.Fatal("Persist on a key without expiration must report false")
+	}
+	if v, ok := store.GetEx("k", 50); !ok || v != "v" || store.TTL("k") != 50 {
+		t.Fatalf("expected GetEx to return the value and set TTL 50, got %q %v %d", v, ok, store.TTL("k"))
+	}
+	if v, ok := store.GetEx("gone", -1); ok || v != "" {
+		t.Fatal("GetEx on a missing key must report false")
+	}
+	store.Set("short", "v", 0)
+	if v, ok := store.GetEx("short", -1); !ok || v != "v" {
+		t.Fatalf("expected GetEx with a negative ttl to return the value, got %q %v", v, ok)
+	}
+	if _, ok := store.Get("short"); ok || store.getShard("short").heap.Len() != 0 {
+		t.Fatal("GetEx with a negative ttl must delete the key")
+	}
+	if !store.PExpireAt("k", store.nowMillis()-1) {
+		t.Fatal("expected PExpireAt on an existing key to succeed")
+	}
+	if _, ok := store.Get("k"); ok {
+		t.Fatal("a key expired into the past must be deleted")
+	}
+	if store.Expire("k", 10) {
+		t.Fatal("Expire on a missing key must report false")
+	}
+
+	want := []string{ActionSet, ActionExpire, ActionPersist, ActionExpire, ActionSet, ActionDelete, ActionDelete}
+	got := wal.actions()
+	if len(got) != len(want) {
+		t.Fatalf("expected WAL actions %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected WAL actions %v, got %v", want, got)
+		}
+	}
+}
+
+func TestMillisecondExpiration(t *testing.T) {
+	store, wal, clock := newTestStore()
+
+	store.PSet("window", "1", 100)
+	clock.Advance(100 * time.Millisecond)
+	if _, ok := store.Get("window"); !ok {
+		t.Fatal("key must still exist at its exact deadline")
+	}
+	if ttl := store.PTTL("window"); ttl != 0 {
+		t.Fatalf("expected 0ms left, got %d", ttl)
+	}
+	clock.Advance(time.Millisecond)
+	if _, ok := store.Get("window"); ok {
+		t.Fatal("key must be gone 1ms after its deadline")
+	}
+
+	store.PSet("a", "1", 250)
+	store.PSet("b", "2", 750)
+	clock.Advance(500 * time.Millisecond)
+	store.Cleanup()
+	if _, ok := store.getShard("a").store["a"]; ok {
+		t.Fatal("Cleanup must remove keys whose deadline passed")
+	}
+	if _, ok := store.Get("b"); !ok {
+		t.Fatal("Cleanup must keep keys that have not expired yet")
+	}
+
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+	last := wal.entries[len(wal.entries)-1]
+	if last.Action != ActionExpired || last.Key != "a" || last.Timestamp != clock.Now().UnixMilli() {
+		t.Fatalf("expected an expired entry for a stamped by the store clock, got %+v", last)
+	}
+}
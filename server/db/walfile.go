@@ -0,0 +1,111 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+)
+
+// WALRecord is a decoded WAL entry together with its position in the file.
+type WALRecord struct {
+	Offset int64 // byte offset at which the entry starts
+	Size   int64 // encoded size of the entry in bytes
+	Entry  WriteAheadLogEntry
+}
+
+// WALReader reads entries sequentially from a WAL file. It is used for
+// recovery as well as by the offline inspection tools.
+type WALReader struct {
+	file   *os.File
+	reader *countingReader
+	keys   *Keyring
+}
+
+// OpenWALReader opens a WAL file for reading from its beginning. Encrypted
+// records are decrypted with keys, which may be nil for a plaintext WAL.
+func OpenWALReader(filename string, keys *Keyring) (*WALReader, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &WALReader{
+		file:   file,
+		reader: &countingReader{r: bufio.NewReader(file)},
+		keys:   keys,
+	}, nil
+}
+
+// SeekTo positions the reader at the given byte offset.
+func (r *WALReader) SeekTo(offset int64) error {
+	if _, err := r.file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	r.reader = &countingReader{r: bufio.NewReader(r.file), offset: offset}
+	return nil
+}
+
+// Offset returns the byte offset of the next entry.
+func (r *WALReader) Offset() int64 {
+	return r.reader.offset
+}
+
+// Next decodes the next entry. It returns io.EOF at the clean end of the log;
+// any other error means the log is truncated or corrupt at the current offset.
+// Checksums are not verified here, use WriteAheadLogEntry.ValidChecksum.
+func (r *WALReader) Next() (WALRecord, error) {
+	offset := r.reader.offset
+	entry, err := decodeEntry(r.reader, r.keys)
+	if err != nil {
+		return WALRecord{Offset: offset}, err
+	}
+	return WALRecord{Offset: offset, Size: r.reader.offset - offset, Entry: entry}, nil
+}
+
+// Close closes the underlying file.
+func (r *WALReader) Close() error {
+	return r.file.Close()
+}
+
+// WALWriter writes entries to a new WAL file, e.g. when rewriting a filtered log.
+type WALWriter struct {
+	file   *os.File
+	writer *bufio.Writer
+	buf    bytes.Buffer
+	keys   *Keyring
+}
+
+// CreateWALWriter creates (or truncates) a WAL file for writing. Records are
+// encrypted with the active key of keys unless it is nil.
+func CreateWALWriter(filename string, keys *Keyring) (*WALWriter, error) {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &WALWriter{file: file, writer: bufio.NewWriter(file), keys: keys}, nil
+}
+
+// Write appends an entry, keeping its timestamp and checksum. Legacy entries
+// are rewritten in the current record format.
+func (w *WALWriter) Write(entry WriteAheadLogEntry) error {
+	entry.upgrade()
+	w.buf.Reset()
+	if err := encodeRecord(&w.buf, entry, w.keys); err != nil {
+		return err
+	}
+	_, err := w.writer.Write(w.buf.Bytes())
+	return err
+}
+
+// Close flushes buffered entries and closes the file.
+func (w *WALWriter) Close() error {
+	if err := w.writer.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
@@ -1,14 +1,24 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/shafigh75/Memorandum/config"
+	"github.com/shafigh75/Memorandum/server/acl"
+	"github.com/shafigh75/Memorandum/server/audit"
 	"github.com/shafigh75/Memorandum/server/db"
+	"github.com/shafigh75/Memorandum/server/info"
+	"github.com/shafigh75/Memorandum/server/monitor"
+	"github.com/shafigh75/Memorandum/server/slowlog"
+	"github.com/shafigh75/Memorandum/server/trace"
 	"github.com/shafigh75/Memorandum/utils/logger"
+	"github.com/shafigh75/Memorandum/utils/metrics"
 )
 
 // APIResponse represents a standard API response.
@@ -18,74 +28,259 @@ type APIResponse struct {
 	Error   string      `json:"error,omitempty"`
 }
 
+// errKeyNotFound is the response error used when a key is missing.
+const errKeyNotFound = "Key not found or expired"
+
+// errUserNotFound is returned when deleting a user that does not exist.
+var errUserNotFound = errors.New("User not found")
+
+// contentTypeBinary is used for raw request and response bodies.
+const contentTypeBinary = "application/octet-stream"
+
 // Handler struct to hold the store
 type Handler struct {
-	Store  *db.ShardedInMemoryStore
-	Logger *logger.Logger
+	Store    *db.ShardedInMemoryStore
+	Logger   *logger.Logger
+	ReadOnly bool            // reject writes, e.g. when serving a recovered snapshot
+	ACL      *acl.List       // users allowed to make requests, nil when auth is disabled
+	Audit    *audit.Log      // trail of deletes, ACL changes and denied requests, nil to disable
+	Info     *info.Collector // source of /info, nil to disable it
+	Slowlog  *slowlog.Log    // slow requests, nil to disable
+	Monitor  *monitor.Hub    // subscribers to the commands served, nil to disable
+	Tracer   *trace.Tracer   // exporter of request spans, nil to only put trace IDs in logs
 }
 
-// NewHandler creates a new HTTP handler.
-func NewHandler(store *db.ShardedInMemoryStore, logger *logger.Logger) *Handler {
-	return &Handler{Store: store, Logger: logger}
+// NewHandler creates a new HTTP handler. accessList may be nil to disable
+// authentication.
+func NewHandler(store *db.ShardedInMemoryStore, logger *logger.Logger, accessList *acl.List) *Handler {
+	return &Handler{Store: store, Logger: logger, ACL: accessList}
 }
 
-// ServeHTTP implements the http.Handler interface.
+// ServeHTTP implements the http.Handler interface. Each request gets a span,
+// which continues the trace of its traceparent or X-Request-ID header.
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	configFilePath := "config/config.json"
-	cfg, err := config.LoadConfig(configFilePath)
-	if err != nil {
-		fmt.Println("Error loading config:", err)
+	start := time.Now()
+	parent, requestID := trace.Extract(r.Header)
+	span := h.Tracer.StartServer("http "+operation(r), parent)
+	trace.WriteHeaders(w.Header(), span, requestID)
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	op := &requestOp{requestID: requestID}
+	ctx := context.WithValue(trace.NewContext(r.Context(), span), requestOpKey{}, op)
+	r = r.WithContext(ctx)
+	h.serve(recorder, r)
+	took := time.Since(start)
+	metrics.ObserveRequest("http", operation(r), strconv.Itoa(recorder.status), took)
+	h.logRequest(r, op, recorder.status, took)
+	endSpan(span, r, op, recorder.status)
+	if r.URL.Path == "/monitor" {
+		// The stream lasts as long as someone watches; it is not a command.
 		return
 	}
-	if cfg.AuthEnabled {
-		// Check for authentication if enabled
-		authHeader := r.Header.Get("Authorization")
-		if authHeader != "Bearer "+cfg.AuthToken {
-			// Create a structured log message
-			logMessage := map[string]interface{}{
-				"timestamp": time.Now().Format(time.RFC3339),
-				"method":    r.Method + ": UNAUTHORIZED",
-				"url":       r.URL.String(),
-				"ip":        r.RemoteAddr,
-			}
+	h.Slowlog.Record(slowlog.Entry{
+		Time:      start,
+		Duration:  took,
+		Protocol:  "http",
+		Command:   operation(r),
+		Key:       op.key,
+		ValueSize: op.valueSize,
+		Client:    r.RemoteAddr,
+		TraceID:   span.TraceID(),
+	})
+	if h.Monitor.Active() {
+		value := op.value
+		if op.bytes != nil {
+			value = string(op.bytes)
+		}
+		h.Monitor.Publish(monitor.Event{
+			Time:     start,
+			Protocol: "http",
+			Client:   r.RemoteAddr,
+			Command:  operation(r),
+			Key:      op.key,
+			Value:    value,
+		})
+	}
+}
 
-			// Convert the log message to JSON
-			logJSON, err := json.Marshal(logMessage)
-			if err != nil {
-				// Handle JSON marshaling error (optional)
-				h.Logger.Log("Error marshaling log message to JSON")
-				return
-			}
+// logRequest writes a request to the request log, if it is sampled.
+func (h *Handler) logRequest(r *http.Request, op *requestOp, status int, took time.Duration) {
+	args := []any{
+		"method", r.Method,
+		"url", r.URL.String(),
+		"client", r.RemoteAddr,
+		"user", op.user,
+		"status", status,
+		"duration_us", took.Microseconds(),
+		"trace_id", trace.FromContext(r.Context()).TraceID(),
+	}
+	if op.requestID != "" {
+		args = append(args, "request_id", op.requestID)
+	}
+	if op.value != "" {
+		args = append(args, logger.ValueKey, op.value)
+	} else if op.bytes != nil {
+		args = append(args, logger.ValueKey, op.bytes)
+	}
+	h.Logger.Request("http request", args...)
+}
+
+// endSpan records the outcome of a request in its span and ends it.
+func endSpan(span *trace.Span, r *http.Request, op *requestOp, status int) {
+	span.SetAttr("http.request.method", r.Method)
+	span.SetAttr("url.path", r.URL.Path)
+	span.SetAttr("client.address", r.RemoteAddr)
+	span.SetAttr("http.response.status_code", status)
+	if op.user != "" {
+		span.SetAttr("enduser.id", op.user)
+	}
+	if op.key != "" {
+		span.SetAttr("memorandum.key", op.key)
+	}
+	if status >= http.StatusInternalServerError {
+		span.SetError(http.StatusText(status))
+	}
+	span.End()
+}
+
+// requestOpKey is the context key of the *requestOp of a request.
+type requestOpKey struct{}
+
+// requestOp collects the user, key and value of a request for the request
+// log, the slow log and the monitor.
+type requestOp struct {
+	requestID string // X-Request-ID of the request, if any
+	user      string
+	key       string
+	value     string // value written
+	bytes     []byte // binary value written
+	valueSize int    // size of the value written or read
+}
+
+// noteValue records the value written by a request.
+func noteValue(r *http.Request, value string) {
+	if op, ok := r.Context().Value(requestOpKey{}).(*requestOp); ok {
+		op.value, op.valueSize = value, len(value)
+	}
+}
+
+// noteBytes records the binary value written by a request.
+func noteBytes(r *http.Request, value []byte) {
+	if op, ok := r.Context().Value(requestOpKey{}).(*requestOp); ok {
+		op.bytes, op.valueSize = value, len(value)
+	}
+}
+
+// noteValueSize records the size of the value read by a request.
+func noteValueSize(r *http.Request, size int) {
+	if op, ok := r.Context().Value(requestOpKey{}).(*requestOp); ok {
+		op.valueSize = size
+	}
+}
+
+// statusRecorder remembers the status code of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (s *statusRecorder) Unwrap() http.ResponseWriter { return s.ResponseWriter }
+
+// operation names the operation of a request for metrics.
+func operation(r *http.Request) string {
+	switch r.URL.Path {
+	case "/acl/users":
+		return "acl"
+	case "/metrics", "/info", "/slowlog", "/monitor", "/hotkeys", "/bigkeys", "/ttl", "/pttl", "/expire", "/pexpire", "/expireat", "/pexpireat", "/persist", "/getex", "/pgetex":
+		return r.URL.Path[1:]
+	}
+	switch r.Method {
+	case http.MethodPost:
+		return "set"
+	case http.MethodPut:
+		return "put"
+	case http.MethodGet:
+		return "get"
+	case http.MethodDelete:
+		return "delete"
+	}
+	return "other"
+}
 
-			// Log the structured message as a JSON string
-			h.Logger.Log(string(logJSON))
+// serve authenticates and routes a request.
+func (h *Handler) serve(w http.ResponseWriter, r *http.Request) {
+	var user *acl.User
+	if h.ACL != nil {
+		// Check for authentication if enabled
+		var err error
+		if user, err = h.ACL.AuthenticateRequest(r); err != nil {
+			h.logDenied(r, "UNAUTHORIZED", "")
+			h.Audit.Record(audit.Event{
+				Source:    r.RemoteAddr,
+				Operation: audit.OpAuth,
+				Outcome:   audit.OutcomeDenied,
+				Detail:    err.Error(),
+			})
+			w.Header().Set("WWW-Authenticate", `Basic realm="Memorandum"`)
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
+		r = r.WithContext(acl.WithUser(r.Context(), user))
+		if op, ok := r.Context().Value(requestOpKey{}).(*requestOp); ok {
+			op.user = user.Name
+		}
 	}
 
-	// Create a structured log message
-	logMessage := map[string]interface{}{
-		"timestamp": time.Now().Format(time.RFC3339),
-		"method":    r.Method,
-		"url":       r.URL.String(),
-		"ip":        r.RemoteAddr,
+	if h.ReadOnly && r.Method != http.MethodGet {
+		http.Error(w, "Store is read-only", http.StatusMethodNotAllowed)
+		return
 	}
-
-	// Convert the log message to JSON
-	logJSON, err := json.Marshal(logMessage)
-	if err != nil {
-		// Handle JSON marshaling error (optional)
-		h.Logger.Log("Error marshaling log message to JSON")
+	switch r.URL.Path {
+	case "/acl/users":
+		h.ACLHandler(w, r)
+		return
+	case "/metrics":
+		if h.authorize(w, r, acl.CmdMetrics, "") {
+			metrics.Default.ServeHTTP(w, r)
+		}
+		return
+	case "/info":
+		h.InfoHandler(w, r)
+		return
+	case "/slowlog":
+		h.SlowlogHandler(w, r)
+		return
+	case "/monitor":
+		h.MonitorHandler(w, r)
+		return
+	case "/hotkeys", "/bigkeys":
+		h.KeysReportHandler(w, r)
+		return
+	case "/ttl", "/pttl":
+		h.TTLHandler(w, r)
+		return
+	case "/expire", "/pexpire", "/expireat", "/pexpireat", "/persist":
+		h.ExpireHandler(w, r)
+		return
+	case "/getex", "/pgetex":
+		h.GetExHandler(w, r)
 		return
 	}
-
-	// Log the structured message as a JSON string
-	h.Logger.Log(string(logJSON))
 	switch r.Method {
 	case http.MethodPost:
 		h.SetHandler(w, r)
+	case http.MethodPut:
+		h.PutHandler(w, r)
 	case http.MethodGet:
+		if strings.Contains(r.Header.Get("Accept"), contentTypeBinary) {
+			h.GetRawHandler(w, r)
+			return
+		}
 		h.GetHandler(w, r)
 	case http.MethodDelete:
 		h.DeleteHandler(w, r)
@@ -94,34 +289,484 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// logDenied logs a request rejected by authentication or the ACL. Denials
+// are not sampled.
+func (h *Handler) logDenied(r *http.Request, reason, user string) {
+	h.Logger.Warn("http request denied",
+		"reason", reason,
+		"method", r.Method,
+		"url", r.URL.String(),
+		"client", r.RemoteAddr,
+		"user", user,
+		"trace_id", trace.FromContext(r.Context()).TraceID(),
+	)
+}
+
+// authorize reports whether the user of the request may run command on key,
+// and responds 403 if not.
+func (h *Handler) authorize(w http.ResponseWriter, r *http.Request, command, key string) bool {
+	if op, ok := r.Context().Value(requestOpKey{}).(*requestOp); ok {
+		op.key = key
+	}
+	user := acl.UserFromContext(r.Context())
+	if user.Can(command, key) {
+		return true
+	}
+	h.logDenied(r, "FORBIDDEN", user.Name)
+	h.Audit.Record(audit.Event{
+		Principal: user.Name,
+		Source:    r.RemoteAddr,
+		Operation: command,
+		Key:       key,
+		Outcome:   audit.OutcomeForbidden,
+	})
+	http.Error(w, "Forbidden", http.StatusForbidden)
+	return false
+}
+
 // SetHandler handles the set request.
 func (h *Handler) SetHandler(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Key   string `json:"key"`
 		Value string `json:"value"`
-		TTL   int64  `json:"ttl"` // TTL in seconds
+		TTL   int64  `json:"ttl"`  // TTL in seconds
+		PTTL  int64  `json:"pttl"` // TTL in milliseconds, takes precedence over ttl
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
-	h.Store.Set(req.Key, req.Value, req.TTL)
+	if !h.authorize(w, r, acl.CmdSet, req.Key) {
+		return
+	}
+	noteValue(r, req.Value)
+	if req.PTTL != 0 {
+		h.Store.PSet(req.Key, req.Value, req.PTTL)
+	} else {
+		h.Store.Set(req.Key, req.Value, req.TTL)
+	}
 	json.NewEncoder(w).Encode(APIResponse{Success: true})
 }
 
 // GetHandler handles the get request.
 func (h *Handler) GetHandler(w http.ResponseWriter, r *http.Request) {
 	key := r.URL.Query().Get("key")
+	if !h.authorize(w, r, acl.CmdGet, key) {
+		return
+	}
 	if value, exists := h.Store.Get(key); exists {
+		noteValueSize(r, len(value))
 		json.NewEncoder(w).Encode(APIResponse{Success: true, Data: value})
 	} else {
-		json.NewEncoder(w).Encode(APIResponse{Success: false, Error: "Key not found or expired"})
+		json.NewEncoder(w).Encode(APIResponse{Success: false, Error: errKeyNotFound})
+	}
+}
+
+// PutHandler stores the raw request body as the value of a key. The key and
+// an optional ttl (seconds) or pttl (milliseconds) are query parameters.
+func (h *Handler) PutHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	key := query.Get("key")
+	if key == "" {
+		http.Error(w, "Missing key", http.StatusBadRequest)
+		return
+	}
+	if !h.authorize(w, r, acl.CmdSet, key) {
+		return
+	}
+	ttl, err := parseTTLQuery(query.Get("pttl"), 1)
+	if err == nil && ttl == 0 {
+		ttl, err = parseTTLQuery(query.Get("ttl"), 1000)
+	}
+	if err != nil {
+		http.Error(w, "Invalid ttl", http.StatusBadRequest)
+		return
+	}
+	value, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	noteBytes(r, value)
+	h.Store.PSetBytes(key, value, ttl)
+	json.NewEncoder(w).Encode(APIResponse{Success: true})
+}
+
+// parseTTLQuery parses an optional TTL query parameter and converts it to
+// milliseconds.
+func parseTTLQuery(value string, scale int64) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	ttl, err := strconv.ParseInt(value, 10, 64)
+	return ttl * scale, err
+}
+
+// GetRawHandler writes the value of a key as the raw response body, or
+// responds 404 if the key does not exist.
+func (h *Handler) GetRawHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if !h.authorize(w, r, acl.CmdGet, key) {
+		return
 	}
+	value, exists := h.Store.GetBytes(key)
+	if !exists {
+		http.Error(w, errKeyNotFound, http.StatusNotFound)
+		return
+	}
+	noteValueSize(r, len(value))
+	w.Header().Set("Content-Type", contentTypeBinary)
+	w.Header().Set("Content-Length", strconv.Itoa(len(value)))
+	w.Write(value)
 }
 
 // DeleteHandler handles the delete request.
 func (h *Handler) DeleteHandler(w http.ResponseWriter, r *http.Request) {
 	key := r.URL.Query().Get("key")
+	if !h.authorize(w, r, acl.CmdDelete, key) {
+		return
+	}
 	h.Store.Delete(key)
+	h.Audit.Record(audit.Event{
+		Principal: principal(r),
+		Source:    r.RemoteAddr,
+		Operation: audit.OpDelete,
+		Key:       key,
+		Outcome:   audit.OutcomeOK,
+	})
 	json.NewEncoder(w).Encode(APIResponse{Success: true})
 }
+
+// expireRequest is the body of the expiration endpoints. TTL and At are
+// pointers so that a missing field is rejected rather than read as 0, which
+// would delete the key or remove its expiration.
+type expireRequest struct {
+	Key string `json:"key"`
+	TTL *int64 `json:"ttl"` // seconds (milliseconds for /pexpire and /pgetex)
+	At  *int64 `json:"at"`  // Unix time in seconds (milliseconds for /pexpireat)
+}
+
+// TTLHandler returns the remaining TTL of a key: seconds for /ttl and
+// milliseconds for /pttl, -1 if the key has no expiration.
+func (h *Handler) TTLHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	key := r.URL.Query().Get("key")
+	if !h.authorize(w, r, acl.CmdTTL, key) {
+		return
+	}
+	var ttl int64
+	if r.URL.Path == "/pttl" {
+		ttl = h.Store.PTTL(key)
+	} else {
+		ttl = h.Store.TTL(key)
+	}
+	if ttl == db.TTLKeyNotFound {
+		json.NewEncoder(w).Encode(APIResponse{Success: false, Error: errKeyNotFound})
+		return
+	}
+	json.NewEncoder(w).Encode(APIResponse{Success: true, Data: ttl})
+}
+
+// InfoHandler returns the server information as JSON, or one section of it
+// with ?section=name. With ?format=text it returns the text shown by the CLI.
+func (h *Handler) InfoHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorize(w, r, acl.CmdInfo, "") {
+		return
+	}
+	if h.Info == nil {
+		http.Error(w, "Info is not available", http.StatusNotFound)
+		return
+	}
+	snapshot := h.Info.Collect()
+	section := r.URL.Query().Get("section")
+	if r.URL.Query().Get("format") == "text" {
+		text, err := snapshot.Format(section)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, text)
+		return
+	}
+	var data interface{} = snapshot
+	if section != "" && section != "all" {
+		var err error
+		if data, err = snapshot.Section(section); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(APIResponse{Success: false, Error: err.Error()})
+			return
+		}
+	}
+	json.NewEncoder(w).Encode(APIResponse{Success: true, Data: data})
+}
+
+// SlowlogHandler returns the slow log, newest first, on GET, at most
+// ?count=n entries if given, and empties it on DELETE.
+func (h *Handler) SlowlogHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, acl.CmdSlowlog, "") {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		count := 0
+		if value := r.URL.Query().Get("count"); value != "" {
+			var err error
+			if count, err = strconv.Atoi(value); err != nil {
+				http.Error(w, "Invalid count", http.StatusBadRequest)
+				return
+			}
+		}
+		json.NewEncoder(w).Encode(APIResponse{Success: true, Data: h.Slowlog.Entries(count)})
+	case http.MethodDelete:
+		h.Slowlog.Reset()
+		json.NewEncoder(w).Encode(APIResponse{Success: true})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// MonitorHandler streams the commands served by the node, one line each, until
+// the client disconnects. With ?max_value=n, values are cut after n bytes.
+func (h *Handler) MonitorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorize(w, r, acl.CmdMonitor, "") {
+		return
+	}
+	if h.Monitor == nil {
+		http.Error(w, "Monitor is not available", http.StatusNotFound)
+		return
+	}
+	maxValue := 0
+	if value := r.URL.Query().Get("max_value"); value != "" {
+		var err error
+		if maxValue, err = strconv.Atoi(value); err != nil || maxValue < 0 {
+			http.Error(w, "Invalid max_value", http.StatusBadRequest)
+			return
+		}
+	}
+
+	sub := h.Monitor.Subscribe()
+	defer sub.Close()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	flusher := http.NewResponseController(w)
+	io.WriteString(w, "OK\n")
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			if n := sub.Dropped(); n > 0 {
+				io.WriteString(w, "("+strconv.FormatInt(n, 10)+" commands dropped)\n")
+			}
+			io.WriteString(w, e.Format(maxValue)+"\n")
+			// Flush once the events received so far are written.
+			if len(sub.Events()) == 0 {
+				if err := flusher.Flush(); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// KeysReportHandler returns the most accessed keys on /hotkeys and the
+// largest on /bigkeys, at most ?count=n of them (10 by default).
+func (h *Handler) KeysReportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	command := r.URL.Path[1:]
+	if !h.authorize(w, r, command, "") {
+		return
+	}
+	count := 10
+	if value := r.URL.Query().Get("count"); value != "" {
+		var err error
+		if count, err = strconv.Atoi(value); err != nil || count < 1 || count > db.MaxKeyReport {
+			http.Error(w, "Invalid count, use 1 to "+strconv.Itoa(db.MaxKeyReport), http.StatusBadRequest)
+			return
+		}
+	}
+	if command == acl.CmdHotKeys {
+		json.NewEncoder(w).Encode(APIResponse{Success: true, Data: h.Store.HotKeys(count)})
+	} else {
+		json.NewEncoder(w).Encode(APIResponse{Success: true, Data: h.Store.BigKeys(count)})
+	}
+}
+
+// ExpireHandler sets or removes the expiration of a key.
+func (h *Handler) ExpireHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req expireRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	switch r.URL.Path {
+	case "/expire", "/pexpire":
+		if req.TTL == nil {
+			http.Error(w, "Invalid request: ttl is required", http.StatusBadRequest)
+			return
+		}
+	case "/expireat", "/pexpireat":
+		if req.At == nil {
+			http.Error(w, "Invalid request: at is required", http.StatusBadRequest)
+			return
+		}
+	}
+	if !h.authorize(w, r, acl.CmdExpire, req.Key) {
+		return
+	}
+
+	var ok bool
+	switch r.URL.Path {
+	case "/expire":
+		ok = h.Store.Expire(req.Key, *req.TTL)
+	case "/pexpire":
+		ok = h.Store.PExpire(req.Key, *req.TTL)
+	case "/expireat":
+		ok = h.Store.ExpireAt(req.Key, *req.At)
+	case "/pexpireat":
+		ok = h.Store.PExpireAt(req.Key, *req.At)
+	case "/persist":
+		if !h.Store.Persist(req.Key) {
+			json.NewEncoder(w).Encode(APIResponse{Success: false, Error: "Key not found or has no expiration"})
+			return
+		}
+		ok = true
+	}
+	if !ok {
+		json.NewEncoder(w).Encode(APIResponse{Success: false, Error: errKeyNotFound})
+		return
+	}
+	json.NewEncoder(w).Encode(APIResponse{Success: true})
+}
+
+// GetExHandler returns a value and resets its expiration (sliding expiration).
+// A ttl of 0 removes the expiration and a negative one deletes the key.
+func (h *Handler) GetExHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req expireRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.TTL == nil {
+		http.Error(w, "Invalid request: ttl is required", http.StatusBadRequest)
+		return
+	}
+	if !h.authorize(w, r, acl.CmdGetEx, req.Key) {
+		return
+	}
+
+	var value string
+	var exists bool
+	if r.URL.Path == "/pgetex" {
+		value, exists = h.Store.PGetEx(req.Key, *req.TTL)
+	} else {
+		value, exists = h.Store.GetEx(req.Key, *req.TTL)
+	}
+	if !exists {
+		json.NewEncoder(w).Encode(APIResponse{Success: false, Error: errKeyNotFound})
+		return
+	}
+	noteValueSize(r, len(value))
+	json.NewEncoder(w).Encode(APIResponse{Success: true, Data: value})
+}
+
+// principal returns the name of the user of a request, or "" when
+// authentication is disabled.
+func principal(r *http.Request) string {
+	if user := acl.UserFromContext(r.Context()); user != nil {
+		return user.Name
+	}
+	return ""
+}
+
+// auditACL records a change to the users.
+func (h *Handler) auditACL(r *http.Request, operation, name, detail string, err error) {
+	event := audit.Event{
+		Principal: principal(r),
+		Source:    r.RemoteAddr,
+		Operation: operation,
+		Outcome:   audit.OutcomeOK,
+		Detail:    strings.TrimSpace("user=" + name + " " + detail),
+	}
+	if err != nil {
+		event.Outcome, event.Detail = audit.OutcomeError, event.Detail+": "+err.Error()
+	}
+	h.Audit.Record(event)
+}
+
+// aclUserRequest is the body of POST /acl/users.
+type aclUserRequest struct {
+	acl.User
+	Password string `json:"password"` // empty to keep the password of an existing user
+}
+
+// ACLHandler manages users: GET lists them, POST adds or replaces one and
+// DELETE removes the user named by the name query parameter.
+func (h *Handler) ACLHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, acl.CmdACL, "") {
+		return
+	}
+	if h.ACL == nil {
+		json.NewEncoder(w).Encode(APIResponse{Success: false, Error: "Authentication is disabled"})
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(APIResponse{Success: true, Data: h.ACL.Users()})
+	case http.MethodPost:
+		var req aclUserRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		err := h.ACL.SetUser(req.User, req.Password)
+		h.auditACL(r, audit.OpSetUser, req.Name, "role="+req.Role, err)
+		if err != nil {
+			json.NewEncoder(w).Encode(APIResponse{Success: false, Error: err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(APIResponse{Success: true})
+	case http.MethodDelete:
+		name := r.URL.Query().Get("name")
+		deleted, err := h.ACL.DeleteUser(name)
+		if err == nil && !deleted {
+			err = errUserNotFound
+		}
+		h.auditACL(r, audit.OpDeleteUser, name, "", err)
+		if err != nil {
+			json.NewEncoder(w).Encode(APIResponse{Success: false, Error: err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(APIResponse{Success: true})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
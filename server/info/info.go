@@ -0,0 +1,340 @@
+// Package info reports the state of a running server for the INFO command:
+// the server itself, its clients, memory, keyspace, persistence and
+// replication.
+package info
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/shafigh75/Memorandum/config"
+	"github.com/shafigh75/Memorandum/server/db"
+)
+
+// Version is the version of the server, set at build time with
+// -ldflags "-X github.com/shafigh75/Memorandum/server/info.Version=v1.2.3".
+var Version = "dev"
+
+// Sections lists the sections of Info in the order they are shown.
+var Sections = []string{"server", "clients", "memory", "keyspace", "persistence", "replication"}
+
+// Info is a snapshot of the server, by section.
+type Info struct {
+	Server      Server      `json:"server"`
+	Clients     Clients     `json:"clients"`
+	Memory      Memory      `json:"memory"`
+	Keyspace    Keyspace    `json:"keyspace"`
+	Persistence Persistence `json:"persistence"`
+	Replication Replication `json:"replication"`
+}
+
+// Server describes the process and a summary of its configuration.
+type Server struct {
+	Version              string    `json:"version"`
+	GoVersion            string    `json:"go_version"`
+	PID                  int       `json:"pid"`
+	StartTime            time.Time `json:"start_time"`
+	UptimeSeconds        int64     `json:"uptime_seconds"`
+	ConfigFile           string    `json:"config_file"`
+	HTTPPort             string    `json:"http_port"`
+	RPCPort              string    `json:"rpc_port"`
+	ClusterPort          string    `json:"cluster_port"`
+	AuthEnabled          bool      `json:"auth_enabled"`
+	TLSEnabled           bool      `json:"tls_enabled"`
+	ShardCount           int       `json:"shard_count"`
+	CompressionThreshold int       `json:"compression_threshold"`
+}
+
+// Clients counts open connections.
+type Clients struct {
+	HTTPConnections int64 `json:"http_connections"`
+	RPCConnections  int64 `json:"rpc_connections"`
+}
+
+// Memory reports the Go runtime's memory use and an estimate of the memory
+// taken by the stored data.
+type Memory struct {
+	DataBytes      int64  `json:"data_bytes"` // estimated, see db.Stats
+	HeapAllocBytes uint64 `json:"heap_alloc_bytes"`
+	HeapInuseBytes uint64 `json:"heap_inuse_bytes"`
+	SysBytes       uint64 `json:"sys_bytes"`
+	GCCycles       uint32 `json:"gc_cycles"`
+	Goroutines     int    `json:"goroutines"`
+}
+
+// Keyspace describes the keys in the store.
+type Keyspace struct {
+	Keys             int     `json:"keys"`
+	ExpiringKeys     int     `json:"expiring_keys"`
+	ShardKeys        []int   `json:"shard_keys"`
+	ExpiredKeys      int64   `json:"expired_keys"`
+	ExpireCycles     int64   `json:"expire_cycles"`
+	CompressedValues int64   `json:"compressed_values"`
+	CompressionRatio float64 `json:"compression_ratio"`
+}
+
+// Persistence describes the write-ahead log.
+type Persistence struct {
+	WALEnabled    bool       `json:"wal_enabled"`
+	WALPath       string     `json:"wal_path,omitempty"`
+	WALSizeBytes  int64      `json:"wal_size_bytes"`
+	WALLastFlush  *time.Time `json:"wal_last_flush,omitempty"`
+	WALQueueDepth int        `json:"wal_queue_depth"`
+}
+
+// Replication describes cluster membership.
+type Replication struct {
+	ClusterEnabled bool   `json:"cluster_enabled"`
+	ReplicaCount   int    `json:"replica_count"`
+	Nodes          []Node `json:"nodes,omitempty"`
+}
+
+// Node is a member of the cluster.
+type Node struct {
+	Address string `json:"address"`
+	Active  bool   `json:"active"`
+}
+
+// Collector gathers Info from the parts of a running server. Fields other
+// than Store and Configs may be nil.
+type Collector struct {
+	Store           *db.ShardedInMemoryStore
+	Configs         *config.Manager
+	ConfigFile      string
+	HTTPConnections func() int64
+	RPCConnections  func() int64
+	ClusterNodes    func() []Node
+
+	started time.Time
+}
+
+// NewCollector returns a collector that counts uptime from now.
+func NewCollector(store *db.ShardedInMemoryStore, configs *config.Manager) *Collector {
+	return &Collector{Store: store, Configs: configs, started: time.Now()}
+}
+
+// Collect takes a snapshot of the server. It visits every key, see
+// db.ShardedInMemoryStore.Stats.
+func (c *Collector) Collect() Info {
+	cfg := c.Configs.Get()
+	stats := c.Store.Stats()
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	info := Info{
+		Server: Server{
+			Version:              Version,
+			GoVersion:            runtime.Version(),
+			PID:                  os.Getpid(),
+			StartTime:            c.started,
+			UptimeSeconds:        int64(time.Since(c.started).Seconds()),
+			ConfigFile:           c.ConfigFile,
+			HTTPPort:             cfg.HTTPPort,
+			RPCPort:              cfg.RPCPort,
+			ClusterPort:          cfg.ClusterPort,
+			AuthEnabled:          cfg.AuthEnabled,
+			TLSEnabled:           cfg.TLSEnabled,
+			ShardCount:           len(stats.ShardKeys),
+			CompressionThreshold: cfg.CompressionThreshold,
+		},
+		Clients: Clients{
+			HTTPConnections: count(c.HTTPConnections),
+			RPCConnections:  count(c.RPCConnections),
+		},
+		Memory: Memory{
+			DataBytes:      stats.DataBytes,
+			HeapAllocBytes: mem.HeapAlloc,
+			HeapInuseBytes: mem.HeapInuse,
+			SysBytes:       mem.Sys,
+			GCCycles:       mem.NumGC,
+			Goroutines:     runtime.NumGoroutine(),
+		},
+		Keyspace: Keyspace{
+			Keys:             stats.Keys,
+			ExpiringKeys:     stats.ExpiringKeys,
+			ShardKeys:        stats.ShardKeys,
+			ExpiredKeys:      stats.Expire.ExpiredKeys,
+			ExpireCycles:     stats.Expire.Cycles,
+			CompressedValues: stats.Compression.Compressed,
+			CompressionRatio: stats.Compression.Ratio,
+		},
+		Persistence: Persistence{
+			WALEnabled:    stats.WAL.Enabled,
+			WALPath:       stats.WAL.Path,
+			WALSizeBytes:  stats.WAL.SizeBytes,
+			WALQueueDepth: stats.WAL.QueueDepth,
+		},
+		Replication: Replication{
+			ClusterEnabled: cfg.ClusterEnabled,
+			ReplicaCount:   cfg.ReplicaCount,
+		},
+	}
+	if !stats.WAL.LastFlush.IsZero() {
+		info.Persistence.WALLastFlush = &stats.WAL.LastFlush
+	}
+	if c.ClusterNodes != nil {
+		info.Replication.Nodes = c.ClusterNodes()
+	}
+	return info
+}
+
+func count(fn func() int64) int64 {
+	if fn == nil {
+		return 0
+	}
+	return fn()
+}
+
+// Section returns one section by name, e.g. for a JSON response.
+func (i Info) Section(name string) (interface{}, error) {
+	switch strings.ToLower(name) {
+	case "server":
+		return i.Server, nil
+	case "clients":
+		return i.Clients, nil
+	case "memory":
+		return i.Memory, nil
+	case "keyspace":
+		return i.Keyspace, nil
+	case "persistence":
+		return i.Persistence, nil
+	case "replication":
+		return i.Replication, nil
+	}
+	return nil, fmt.Errorf("unknown info section %q, use one of %s", name, strings.Join(Sections, ", "))
+}
+
+// Format returns the named section, or every section if name is "" or
+// "all", as "# Section" headers followed by field:value lines.
+func (i Info) Format(name string) (string, error) {
+	names := Sections
+	if name != "" && !strings.EqualFold(name, "all") {
+		if _, err := i.Section(name); err != nil {
+			return "", err
+		}
+		names = []string{strings.ToLower(name)}
+	}
+
+	var b strings.Builder
+	for n, section := range names {
+		if n > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString("# " + strings.ToUpper(section[:1]) + section[1:] + "\n")
+		for _, field := range i.fields(section) {
+			b.WriteString(field[0] + ":" + field[1] + "\n")
+		}
+	}
+	return b.String(), nil
+}
+
+// fields returns the name and value of each field of a section, in order.
+func (i Info) fields(section string) [][2]string {
+	itoa := func(n int64) string { return strconv.FormatInt(n, 10) }
+	utoa := func(n uint64) string { return strconv.FormatUint(n, 10) }
+	btoa := func(b bool) string { return strconv.FormatBool(b) }
+
+	switch section {
+	case "server":
+		s := i.Server
+		return [][2]string{
+			{"version", s.Version},
+			{"go_version", s.GoVersion},
+			{"pid", strconv.Itoa(s.PID)},
+			{"start_time", s.StartTime.UTC().Format(time.RFC3339)},
+			{"uptime_seconds", itoa(s.UptimeSeconds)},
+			{"config_file", s.ConfigFile},
+			{"http_port", s.HTTPPort},
+			{"rpc_port", s.RPCPort},
+			{"cluster_port", s.ClusterPort},
+			{"auth_enabled", btoa(s.AuthEnabled)},
+			{"tls_enabled", btoa(s.TLSEnabled)},
+			{"shard_count", strconv.Itoa(s.ShardCount)},
+			{"compression_threshold", strconv.Itoa(s.CompressionThreshold)},
+		}
+	case "clients":
+		return [][2]string{
+			{"http_connections", itoa(i.Clients.HTTPConnections)},
+			{"rpc_connections", itoa(i.Clients.RPCConnections)},
+		}
+	case "memory":
+		m := i.Memory
+		return [][2]string{
+			{"data_bytes", itoa(m.DataBytes)},
+			{"heap_alloc_bytes", utoa(m.HeapAllocBytes)},
+			{"heap_inuse_bytes", utoa(m.HeapInuseBytes)},
+			{"sys_bytes", utoa(m.SysBytes)},
+			{"gc_cycles", utoa(uint64(m.GCCycles))},
+			{"goroutines", strconv.Itoa(m.Goroutines)},
+		}
+	case "keyspace":
+		k := i.Keyspace
+		fields := [][2]string{
+			{"keys", strconv.Itoa(k.Keys)},
+			{"expiring_keys", strconv.Itoa(k.ExpiringKeys)},
+			{"expired_keys", itoa(k.ExpiredKeys)},
+			{"expire_cycles", itoa(k.ExpireCycles)},
+			{"compressed_values", itoa(k.CompressedValues)},
+			{"compression_ratio", strconv.FormatFloat(k.CompressionRatio, 'f', 2, 64)},
+		}
+		for shard, keys := range k.ShardKeys {
+			fields = append(fields, [2]string{"shard_" + strconv.Itoa(shard), strconv.Itoa(keys)})
+		}
+		return fields
+	case "persistence":
+		p := i.Persistence
+		lastFlush := ""
+		if p.WALLastFlush != nil {
+			lastFlush = p.WALLastFlush.UTC().Format(time.RFC3339)
+		}
+		return [][2]string{
+			{"wal_enabled", btoa(p.WALEnabled)},
+			{"wal_path", p.WALPath},
+			{"wal_size_bytes", itoa(p.WALSizeBytes)},
+			{"wal_last_flush", lastFlush},
+			{"wal_queue_depth", strconv.Itoa(p.WALQueueDepth)},
+		}
+	case "replication":
+		r := i.Replication
+		fields := [][2]string{
+			{"cluster_enabled", btoa(r.ClusterEnabled)},
+			{"replica_count", strconv.Itoa(r.ReplicaCount)},
+			{"nodes", strconv.Itoa(len(r.Nodes))},
+		}
+		for n, node := range r.Nodes {
+			status := "down"
+			if node.Active {
+				status = "up"
+			}
+			fields = append(fields, [2]string{"node_" + strconv.Itoa(n), node.Address + "," + status})
+		}
+		return fields
+	}
+	return nil
+}
+
+// ConnCounter counts the open connections of an http.Server. Set its
+// ConnState method as the server's ConnState hook.
+type ConnCounter struct {
+	open atomic.Int64
+}
+
+// ConnState tracks a connection changing state.
+func (c *ConnCounter) ConnState(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		c.open.Add(1)
+	case http.StateHijacked, http.StateClosed:
+		c.open.Add(-1)
+	}
+}
+
+// Count returns the number of open connections.
+func (c *ConnCounter) Count() int64 { return c.open.Load() }
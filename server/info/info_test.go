@@ -0,0 +1,45 @@
+package info
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/shafigh75/Memorandum/config"
+	"github.com/shafigh75/Memorandum/server/db"
+)
+
+func TestCollectAndFormat(t *testing.T) {
+	store := db.NewShardedInMemoryStore(2, &db.DummyWAL{})
+	store.Set("a", "1", 0)
+	store.Set("b", "2", 60)
+	collector := NewCollector(store, config.NewStaticManager(config.Default()))
+	collector.RPCConnections = func() int64 { return 3 }
+	collector.ClusterNodes = func() []Node { return []Node{{Address: "10.0.0.2:1234", Active: true}} }
+
+	snapshot := collector.Collect()
+	if snapshot.Keyspace.Keys != 2 || snapshot.Keyspace.ExpiringKeys != 1 || snapshot.Clients.RPCConnections != 3 {
+		t.Fatalf("unexpected snapshot %+v", snapshot)
+	}
+
+	text, err := snapshot.Format("keyspace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(text, "# Keyspace\nkeys:2\nexpiring_keys:1\n") || strings.Contains(text, "# Server") {
+		t.Fatalf("unexpected keyspace section:\n%s", text)
+	}
+
+	all, err := snapshot.Format("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"# Server\nversion:dev\n", "rpc_connections:3\n", "node_0:10.0.0.2:1234,up\n"} {
+		if !strings.Contains(all, want) {
+			t.Errorf("expected %q in:\n%s", want, all)
+		}
+	}
+
+	if _, err := snapshot.Format("cpu"); err == nil {
+		t.Fatal("expected an error for an unknown section")
+	}
+}
@@ -0,0 +1,146 @@
+// Package monitor streams the commands served by a node to subscribers, like
+// Redis' MONITOR, for watching client behaviour in real time.
+package monitor
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// bufferSize is the number of events a subscriber may fall behind by before
+// events are dropped for it.
+const bufferSize = 1024
+
+// An Event is a command served by the node.
+type Event struct {
+	Time     time.Time
+	Protocol string // http, rpc or cluster
+	Client   string // client address
+	Command  string
+	Key      string
+	Value    string // value written, empty for reads
+}
+
+// Format returns the event as a line such as
+//
+//	1718000000.123456 [http 127.0.0.1:50412] set "session:1" "value"
+//
+// Values longer than maxValue bytes are cut, unless maxValue is 0.
+func (e Event) Format(maxValue int) string {
+	var b strings.Builder
+	b.WriteString(strconv.FormatInt(e.Time.Unix(), 10))
+	b.WriteByte('.')
+	micros := strconv.Itoa(e.Time.Nanosecond() / 1000)
+	b.WriteString(strings.Repeat("0", 6-len(micros)) + micros)
+	b.WriteString(" [" + e.Protocol + " " + e.Client + "] " + e.Command)
+	if e.Key != "" {
+		b.WriteString(" " + strconv.Quote(e.Key))
+	}
+	if e.Value != "" {
+		value, cut := e.Value, 0
+		if maxValue > 0 && len(value) > maxValue {
+			value, cut = value[:maxValue], len(value)-maxValue
+		}
+		b.WriteString(" " + strconv.Quote(value))
+		if cut > 0 {
+			b.WriteString("... (" + strconv.Itoa(cut) + " more bytes)")
+		}
+	}
+	return b.String()
+}
+
+// A Hub delivers published events to every subscriber. A nil *Hub publishes
+// nothing, so callers do not need to check whether monitoring is enabled.
+type Hub struct {
+	mu     sync.Mutex
+	subs   map[*Subscription]struct{}
+	active atomic.Int32 // number of subscribers
+}
+
+// NewHub returns a hub without subscribers.
+func NewHub() *Hub {
+	return &Hub{subs: make(map[*Subscription]struct{})}
+}
+
+// Active reports whether anyone is subscribed, so that callers can skip
+// building events nobody reads.
+func (h *Hub) Active() bool {
+	return h != nil && h.active.Load() > 0
+}
+
+// Publish sends an event to every subscriber without blocking. Subscribers
+// that are too far behind miss the event, which their Dropped count records.
+func (h *Hub) Publish(e Event) {
+	if !h.Active() {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs {
+		select {
+		case sub.events <- e:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+// Subscribe starts delivering events to a new subscription, which must be
+// closed when no longer read.
+func (h *Hub) Subscribe() *Subscription {
+	sub := &Subscription{hub: h, events: make(chan Event, bufferSize)}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subs[sub] = struct{}{}
+	h.active.Add(1)
+	return sub
+}
+
+// Close ends every subscription, e.g. when the server shuts down.
+func (h *Hub) Close() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs {
+		h.remove(sub)
+	}
+}
+
+// remove ends a subscription. h.mu must be held.
+func (h *Hub) remove(sub *Subscription) {
+	if _, ok := h.subs[sub]; !ok {
+		return
+	}
+	delete(h.subs, sub)
+	h.active.Add(-1)
+	close(sub.events)
+}
+
+// A Subscription receives the events published after it was created.
+type Subscription struct {
+	hub     *Hub
+	events  chan Event
+	dropped atomic.Int64
+}
+
+// Events returns the channel of events, which is closed when the
+// subscription or the hub is closed.
+func (s *Subscription) Events() <-chan Event { return s.events }
+
+// Dropped returns the number of events missed since the last call.
+func (s *Subscription) Dropped() int64 { return s.dropped.Swap(0) }
+
+// Close stops the delivery of events. It may be called more than once.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.remove(s)
+}
@@ -0,0 +1,56 @@
+package monitor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHub(t *testing.T) {
+	hub := NewHub()
+	hub.Publish(Event{Command: "get"}) // nobody subscribed yet
+	if hub.Active() {
+		t.Fatal("expected no subscribers")
+	}
+
+	sub := hub.Subscribe()
+	for i := 0; i < bufferSize+3; i++ {
+		hub.Publish(Event{Command: "set", Key: "k"})
+	}
+	if len(sub.Events()) != bufferSize || sub.Dropped() != 3 || sub.Dropped() != 0 {
+		t.Fatalf("expected a full buffer and 3 dropped events")
+	}
+
+	sub.Close()
+	sub.Close()
+	if hub.Active() {
+		t.Fatal("expected Close to unsubscribe")
+	}
+	for range sub.Events() {
+	}
+
+	other := hub.Subscribe()
+	hub.Close()
+	if _, ok := <-other.Events(); ok {
+		t.Fatal("expected closing the hub to close subscriptions")
+	}
+
+	var disabled *Hub
+	disabled.Publish(Event{})
+}
+
+func TestFormat(t *testing.T) {
+	e := Event{
+		Time:     time.Unix(1718000000, 1500),
+		Protocol: "http",
+		Client:   "127.0.0.1:50412",
+		Command:  "set",
+		Key:      "session:1",
+		Value:    "a\nlong value",
+	}
+	if got, want := e.Format(0), `1718000000.000001 [http 127.0.0.1:50412] set "session:1" "a\nlong value"`; got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+	if got, want := e.Format(6), `1718000000.000001 [http 127.0.0.1:50412] set "session:1" "a\nlong"... (6 more bytes)`; got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
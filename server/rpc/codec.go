@@ -0,0 +1,197 @@
+package rpc
+
+import (
+	"bufio"
+	"encoding/gob"
+	"io"
+	"log/slog"
+	"net"
+	"net/rpc"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shafigh75/Memorandum/server/monitor"
+	"github.com/shafigh75/Memorandum/server/slowlog"
+	"github.com/shafigh75/Memorandum/server/trace"
+	"github.com/shafigh75/Memorandum/utils/metrics"
+)
+
+// rpcMethods holds the names of the methods of RPCService, so that metrics
+// are not labelled with arbitrary method names sent by clients.
+var rpcMethods = func() map[string]bool {
+	methods := make(map[string]bool)
+	t := reflect.TypeOf(&RPCService{})
+	for i := 0; i < t.NumMethod(); i++ {
+		methods[t.Method(i).Name] = true
+	}
+	return methods
+}()
+
+// serverCodec is the gob codec of net/rpc, extended to record the duration
+// and outcome of each call in the metrics, slow calls in the slow log,
+// every call in the monitor and a span for each call, which continues the
+// trace of the TraceParent of an RPCRequest.
+type serverCodec struct {
+	rwc     io.ReadWriteCloser
+	dec     *gob.Decoder
+	enc     *gob.Encoder
+	encBuf  *bufio.Writer
+	closed  bool
+	client  string        // remote address
+	slowlog *slowlog.Log  // may be nil
+	monitor *monitor.Hub  // may be nil
+	tracer  *trace.Tracer // may be nil
+
+	mu      sync.Mutex
+	calls   map[uint64]*call // in progress, by sequence number
+	lastSeq uint64           // of the request whose body is read next
+}
+
+// call is a request read but not yet answered.
+type call struct {
+	method    string
+	start     time.Time
+	key       string
+	value     string // only kept while the monitor has subscribers
+	valueSize int
+	span      *trace.Span
+}
+
+func newServerCodec(conn net.Conn, slow *slowlog.Log, hub *monitor.Hub, tracer *trace.Tracer) *serverCodec {
+	buf := bufio.NewWriter(conn)
+	return &serverCodec{
+		rwc:     conn,
+		dec:     gob.NewDecoder(conn),
+		enc:     gob.NewEncoder(buf),
+		encBuf:  buf,
+		client:  conn.RemoteAddr().String(),
+		slowlog: slow,
+		monitor: hub,
+		tracer:  tracer,
+		calls:   make(map[uint64]*call),
+	}
+}
+
+func (c *serverCodec) ReadRequestHeader(r *rpc.Request) error {
+	if err := c.dec.Decode(r); err != nil {
+		return err
+	}
+	method := strings.TrimPrefix(r.ServiceMethod, "RPCService.")
+	if !rpcMethods[method] {
+		method = "other"
+	}
+	c.mu.Lock()
+	c.calls[r.Seq] = &call{method: method, start: time.Now()}
+	c.lastSeq = r.Seq
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *serverCodec) ReadRequestBody(body interface{}) error {
+	if err := c.dec.Decode(body); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	started, ok := c.calls[c.lastSeq]
+	if !ok {
+		return nil
+	}
+	req, isRequest := body.(*RPCRequest)
+	var parent trace.SpanContext
+	if isRequest {
+		parent, _ = trace.ParseTraceParent(req.TraceParent)
+	}
+	started.span = c.tracer.StartServer("rpc "+started.method, parent)
+	if isRequest {
+		// Logs of the call show the trace ID of its span.
+		req.TraceParent = started.span.Context().TraceParent()
+		started.key = req.Key
+		started.valueSize = len(req.Value) + len(req.Bytes)
+		if c.monitor.Active() {
+			started.value = req.Value + string(req.Bytes)
+		}
+	}
+	return nil
+}
+
+func (c *serverCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	c.mu.Lock()
+	started, ok := c.calls[r.Seq]
+	delete(c.calls, r.Seq)
+	c.mu.Unlock()
+	if ok {
+		status := "ok"
+		if r.Error != "" {
+			status = "error"
+		}
+		took := time.Since(started.start)
+		metrics.ObserveRequest("rpc", started.method, status, took)
+		started.span.SetAttr("rpc.method", started.method)
+		started.span.SetAttr("client.address", c.client)
+		if started.key != "" {
+			started.span.SetAttr("memorandum.key", started.key)
+		}
+		if r.Error != "" {
+			started.span.SetError(r.Error)
+		}
+		started.span.End()
+		// Monitor calls wait for commands to stream; they are neither
+		// commands to watch nor slow ones.
+		streaming := started.method == "Monitor" || started.method == "MonitorStop"
+		if c.monitor.Active() && !streaming {
+			c.monitor.Publish(monitor.Event{
+				Time:     started.start,
+				Protocol: "rpc",
+				Client:   c.client,
+				Command:  started.method,
+				Key:      started.key,
+				Value:    started.value,
+			})
+		}
+		if c.slowlog.Slow(took) && !streaming {
+			// Reads carry the value in the response.
+			if resp, ok := body.(*RPCResponse); ok && started.key != "" && started.valueSize == 0 {
+				started.valueSize = len(resp.Data) + len(resp.Bytes)
+			}
+			c.slowlog.Record(slowlog.Entry{
+				Time:      started.start,
+				Duration:  took,
+				Protocol:  "rpc",
+				Command:   started.method,
+				Key:       started.key,
+				ValueSize: started.valueSize,
+				Client:    c.client,
+				TraceID:   started.span.TraceID(),
+			})
+		}
+	}
+
+	if err := c.enc.Encode(r); err != nil {
+		if c.encBuf.Flush() == nil {
+			// Gob couldn't encode the header. Should not happen, so if it
+			// does, shut down the connection to signal that it is broken.
+			slog.Error("rpc: gob error encoding response", "err", err)
+			c.Close()
+		}
+		return err
+	}
+	if err := c.enc.Encode(body); err != nil {
+		if c.encBuf.Flush() == nil {
+			slog.Error("rpc: gob error encoding body", "err", err)
+			c.Close()
+		}
+		return err
+	}
+	return c.encBuf.Flush()
+}
+
+func (c *serverCodec) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.rwc.Close()
+}
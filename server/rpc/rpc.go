@@ -1,135 +1,727 @@
 package rpc
 
 import (
-	"encoding/json"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net"
 	"net/rpc"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/shafigh75/Memorandum/config"
+	"github.com/shafigh75/Memorandum/server/acl"
+	"github.com/shafigh75/Memorandum/server/audit"
 	"github.com/shafigh75/Memorandum/server/db"
+	"github.com/shafigh75/Memorandum/server/info"
+	"github.com/shafigh75/Memorandum/server/monitor"
+	"github.com/shafigh75/Memorandum/server/slowlog"
+	"github.com/shafigh75/Memorandum/server/trace"
 	"github.com/shafigh75/Memorandum/utils/logger"
 )
 
 // RPCRequest represents the structure of an RPC request.
 type RPCRequest struct {
-	Key   string `json:"key"`
-	Value string `json:"value,omitempty"`
-	TTL   int64  `json:"ttl"` // TTL in seconds
+	Key      string `json:"key"`
+	Value    string `json:"value,omitempty"`
+	Bytes    []byte `json:"-"`                   // binary value for the *Bytes methods, logged like Value
+	TTL      int64  `json:"ttl"`                 // TTL in seconds (milliseconds for the P* methods)
+	ExpireAt int64  `json:"expire_at,omitempty"` // Unix time in seconds (milliseconds for RPCPExpireAt)
+
+	// TraceParent is the W3C trace context of the caller, e.g. of the cluster
+	// request this call serves. The server replaces it with that of its span.
+	TraceParent string `json:"traceparent,omitempty"`
+}
+
+// LogValue writes the request to logs with its value under logger.ValueKey,
+// so that it is redacted unless values are logged.
+func (r *RPCRequest) LogValue() slog.Value {
+	attrs := []slog.Attr{slog.String("key", r.Key)}
+	if r.Value != "" {
+		attrs = append(attrs, slog.String(logger.ValueKey, r.Value))
+	}
+	if r.Bytes != nil {
+		attrs = append(attrs, slog.Any(logger.ValueKey, r.Bytes))
+	}
+	if r.TTL != 0 {
+		attrs = append(attrs, slog.Int64("ttl", r.TTL))
+	}
+	if r.ExpireAt != 0 {
+		attrs = append(attrs, slog.Int64("expire_at", r.ExpireAt))
+	}
+	return slog.GroupValue(attrs...)
 }
 
 // RPCResponse represents the structure of an RPC response.
 type RPCResponse struct {
 	Success bool   `json:"success"`
 	Data    string `json:"data,omitempty"`
+	Bytes   []byte `json:"-"`             // binary value returned by RPCGetBytes
+	TTL     int64  `json:"ttl,omitempty"` // remaining TTL for RPCTTL and RPCPTTL
 	Error   string `json:"error,omitempty"`
 }
 
-// RPCService provides the RPC methods for the InMemoryStore.
+// errKeyNotFound is the response error used when a key is missing.
+const errKeyNotFound = "Key not found or expired"
+
+// RPCService provides the RPC methods for the InMemoryStore. Each connection
+// is served by its own RPCService, which holds the connection's auth state.
 type RPCService struct {
 	Store  *db.ShardedInMemoryStore
 	Logger *logger.Logger
+
+	acl        *acl.List       // users allowed to connect, nil when auth is disabled
+	audit      *audit.Log      // trail of deletes, ACL changes and denied calls, nil to disable
+	info       *info.Collector // source of Info, nil to disable it
+	slowlog    *slowlog.Log    // slow calls, nil to disable
+	hub        *monitor.Hub    // source of Monitor, nil to disable it
+	remoteAddr string
+
+	mu      sync.Mutex
+	user    *acl.User             // set by Auth
+	monitor *monitor.Subscription // set by Monitor
 }
 
-// RPCSet sets a key-value pair in the store.
-func (s *RPCService) RPCSet(req *RPCRequest, resp *RPCResponse) error {
-	s.Store.Set(req.Key, req.Value, req.TTL)
+// AuthRequest is the argument of RPCService.Auth. Clients authenticate with
+// either the shared token or a user name and password.
+type AuthRequest struct {
+	Token    string
+	Username string
+	Password string
+}
+
+// errUnauthorized is returned by every method except Auth and Ping until the
+// connection has authenticated.
+var errUnauthorized = errors.New("unauthorized: call RPCService.Auth with valid credentials first")
+
+// Auth authenticates the connection. When auth is enabled it must succeed
+// before any other method except Ping can be called, and the permissions of
+// the authenticated user apply to the rest of the connection.
+func (s *RPCService) Auth(req *AuthRequest, resp *RPCResponse) error {
+	if s.acl == nil {
+		resp.Success = true
+		return nil
+	}
+	password := req.Password
+	if req.Username == "" {
+		password = req.Token
+	}
+	user, err := s.acl.Authenticate(req.Username, password)
+	if err != nil {
+		resp.Error = "Invalid credentials"
+		s.logDenied("rpc-auth", "UNAUTHORIZED", req.Username)
+		s.audit.Record(audit.Event{
+			Principal: req.Username,
+			Source:    s.remoteAddr,
+			Operation: audit.OpAuth,
+			Outcome:   audit.OutcomeDenied,
+			Detail:    err.Error(),
+		})
+		return nil
+	}
+	s.mu.Lock()
+	s.user = user
+	s.mu.Unlock()
 	resp.Success = true
-	// Create a structured log message
-	logMessage := map[string]interface{}{
-		"timestamp": time.Now().Format(time.RFC3339),
-		"method":    "rpc-set",
-		"request":   req,
+	return nil
+}
+
+// checkAuth rejects calls on connections that have not authenticated or
+// whose user may not run command on key.
+func (s *RPCService) checkAuth(method, command, key string) error {
+	if s.acl == nil {
+		return nil
+	}
+	s.mu.Lock()
+	user := s.user
+	s.mu.Unlock()
+	if user == nil {
+		s.logDenied(method, "UNAUTHORIZED", "")
+		s.audit.Record(audit.Event{
+			Source:    s.remoteAddr,
+			Operation: command,
+			Key:       key,
+			Outcome:   audit.OutcomeDenied,
+			Detail:    "not authenticated",
+		})
+		return errUnauthorized
+	}
+	if user.Expired() {
+		s.logDenied(method, "TOKEN EXPIRED", user.Name)
+		return fmt.Errorf("unauthorized: %w, call RPCService.Auth again", acl.ErrTokenExpired)
+	}
+	if !user.Can(command, key) {
+		s.logDenied(method, "FORBIDDEN", user.Name)
+		s.audit.Record(audit.Event{
+			Principal: user.Name,
+			Source:    s.remoteAddr,
+			Operation: command,
+			Key:       key,
+			Outcome:   audit.OutcomeForbidden,
+		})
+		if key == "" {
+			return fmt.Errorf("forbidden: user %s may not run %s", user.Name, command)
+		}
+		return fmt.Errorf("forbidden: user %s may not run %s on key %q", user.Name, command, key)
 	}
+	return nil
+}
+
+// logDenied logs a rejected call. Denials are not sampled.
+func (s *RPCService) logDenied(method, reason, user string) {
+	s.Logger.Warn("rpc request denied",
+		"reason", reason,
+		"method", method,
+		"client", s.remoteAddr,
+		"user", user,
+	)
+}
+
+// principal returns the name of the authenticated user, or "".
+func (s *RPCService) principal() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.user == nil {
+		return ""
+	}
+	return s.user.Name
+}
 
-	// Convert the log message to JSON
-	logJSON, err := json.Marshal(logMessage)
+// auditACL records a change to the users.
+func (s *RPCService) auditACL(operation, name, detail string, err error) {
+	event := audit.Event{
+		Principal: s.principal(),
+		Source:    s.remoteAddr,
+		Operation: operation,
+		Outcome:   audit.OutcomeOK,
+		Detail:    strings.TrimSpace("user=" + name + " " + detail),
+	}
 	if err != nil {
-		// Handle JSON marshaling error (optional)
-		s.Logger.Log("Error marshaling log message to JSON")
+		event.Outcome, event.Detail = audit.OutcomeError, event.Detail+": "+err.Error()
+	}
+	s.audit.Record(event)
+}
+
+// ACLUserRequest is the argument of the ACL methods.
+type ACLUserRequest struct {
+	acl.User
+	Password string // empty to keep the password of an existing user
+}
+
+// ACLUsersResponse is the reply of ACLUsers.
+type ACLUsersResponse struct {
+	Users []acl.User
+	Error string
+}
+
+// ACLSetUser adds or replaces a user.
+func (s *RPCService) ACLSetUser(req *ACLUserRequest, resp *RPCResponse) error {
+	if err := s.checkAuth("rpc-acl-setuser", acl.CmdACL, ""); err != nil {
 		return err
 	}
+	if s.acl == nil {
+		resp.Error = "Authentication is disabled"
+		return nil
+	}
+	err := s.acl.SetUser(req.User, req.Password)
+	s.auditACL(audit.OpSetUser, req.Name, "role="+req.Role, err)
+	if err != nil {
+		resp.Error = err.Error()
+		return nil
+	}
+	resp.Success = true
+	s.logRequest("rpc-acl-setuser", map[string]string{"name": req.Name, "role": req.Role})
+	return nil
+}
 
-	// Log the structured message as a JSON string
-	s.Logger.Log(string(logJSON))
+// ACLDeleteUser removes the user named req.Name.
+func (s *RPCService) ACLDeleteUser(req *ACLUserRequest, resp *RPCResponse) error {
+	if err := s.checkAuth("rpc-acl-deluser", acl.CmdACL, ""); err != nil {
+		return err
+	}
+	if s.acl == nil {
+		resp.Error = "Authentication is disabled"
+		return nil
+	}
+	deleted, err := s.acl.DeleteUser(req.Name)
+	if err == nil && !deleted {
+		err = errors.New("User not found")
+	}
+	s.auditACL(audit.OpDeleteUser, req.Name, "", err)
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	resp.Success = err == nil
+	s.logRequest("rpc-acl-deluser", map[string]string{"name": req.Name})
+	return nil
+}
+
+// ACLUsers lists the users without their password hashes.
+func (s *RPCService) ACLUsers(req *ACLUserRequest, resp *ACLUsersResponse) error {
+	if err := s.checkAuth("rpc-acl-users", acl.CmdACL, ""); err != nil {
+		return err
+	}
+	if s.acl == nil {
+		resp.Error = "Authentication is disabled"
+		return nil
+	}
+	resp.Users = s.acl.Users()
+	return nil
+}
+
+// RPCSet sets a key-value pair in the store.
+func (s *RPCService) RPCSet(req *RPCRequest, resp *RPCResponse) error {
+	if err := s.checkAuth("rpc-set", acl.CmdSet, req.Key); err != nil {
+		return err
+	}
+	s.Store.Set(req.Key, req.Value, req.TTL)
+	resp.Success = true
+	s.logRequest("rpc-set", req)
+	return nil
+}
+
+// RPCPSet sets a key-value pair with a TTL in milliseconds.
+func (s *RPCService) RPCPSet(req *RPCRequest, resp *RPCResponse) error {
+	if err := s.checkAuth("rpc-pset", acl.CmdSet, req.Key); err != nil {
+		return err
+	}
+	s.Store.PSet(req.Key, req.Value, req.TTL)
+	resp.Success = true
+	s.logRequest("rpc-pset", req)
 	return nil
 }
 
 // RPCGet retrieves a value by key from the store.
 func (s *RPCService) RPCGet(req *RPCRequest, resp *RPCResponse) error {
+	if err := s.checkAuth("rpc-get", acl.CmdGet, req.Key); err != nil {
+		return err
+	}
 	if value, exists := s.Store.Get(req.Key); exists {
 		resp.Success = true
 		resp.Data = value
 	} else {
 		resp.Success = false
-		resp.Error = "Key not found or expired"
+		resp.Error = errKeyNotFound
 	}
-	// Create a structured log message
-	logMessage := map[string]interface{}{
-		"timestamp": time.Now().Format(time.RFC3339),
-		"method":    "rpc-get",
-		"request":   req,
+	s.logRequest("rpc-get", req)
+	return nil
+}
+
+// RPCSetBytes sets a key to the binary value in req.Bytes.
+func (s *RPCService) RPCSetBytes(req *RPCRequest, resp *RPCResponse) error {
+	if err := s.checkAuth("rpc-setbytes", acl.CmdSet, req.Key); err != nil {
+		return err
 	}
+	s.Store.SetBytes(req.Key, req.Bytes, req.TTL)
+	resp.Success = true
+	s.logRequest("rpc-setbytes", req)
+	return nil
+}
 
-	// Convert the log message to JSON
-	logJSON, err := json.Marshal(logMessage)
-	if err != nil {
-		// Handle JSON marshaling error (optional)
-		s.Logger.Log("Error marshaling log message to JSON")
+// RPCPSetBytes sets a key to a binary value with a TTL in milliseconds.
+func (s *RPCService) RPCPSetBytes(req *RPCRequest, resp *RPCResponse) error {
+	if err := s.checkAuth("rpc-psetbytes", acl.CmdSet, req.Key); err != nil {
 		return err
 	}
+	s.Store.PSetBytes(req.Key, req.Bytes, req.TTL)
+	resp.Success = true
+	s.logRequest("rpc-psetbytes", req)
+	return nil
+}
 
-	// Log the structured message as a JSON string
-	s.Logger.Log(string(logJSON))
+// RPCGetBytes retrieves a binary value by key into resp.Bytes.
+func (s *RPCService) RPCGetBytes(req *RPCRequest, resp *RPCResponse) error {
+	if err := s.checkAuth("rpc-getbytes", acl.CmdGet, req.Key); err != nil {
+		return err
+	}
+	resp.Bytes, resp.Success = s.Store.GetBytes(req.Key)
+	if !resp.Success {
+		resp.Error = errKeyNotFound
+	}
+	s.logRequest("rpc-getbytes", req)
 	return nil
 }
 
 // RPCDelete removes a key-value pair from the store.
 func (s *RPCService) RPCDelete(req *RPCRequest, resp *RPCResponse) error {
+	if err := s.checkAuth("rpc-delete", acl.CmdDelete, req.Key); err != nil {
+		return err
+	}
 	s.Store.Delete(req.Key)
+	s.audit.Record(audit.Event{
+		Principal: s.principal(),
+		Source:    s.remoteAddr,
+		Operation: audit.OpDelete,
+		Key:       req.Key,
+		Outcome:   audit.OutcomeOK,
+	})
 	resp.Success = true
-	// Create a structured log message
-	logMessage := map[string]interface{}{
-		"timestamp": time.Now().Format(time.RFC3339),
-		"method":    "rpc-delete",
-		"request":   req,
+	s.logRequest("rpc-delete", req)
+	return nil
+}
+
+// RPCTTL returns the remaining TTL of a key in seconds (-1 no expiration, -2 missing).
+func (s *RPCService) RPCTTL(req *RPCRequest, resp *RPCResponse) error {
+	if err := s.checkAuth("rpc-ttl", acl.CmdTTL, req.Key); err != nil {
+		return err
+	}
+	resp.TTL = s.Store.TTL(req.Key)
+	resp.Success = resp.TTL != db.TTLKeyNotFound
+	if !resp.Success {
+		resp.Error = errKeyNotFound
+	}
+	s.logRequest("rpc-ttl", req)
+	return nil
+}
+
+// RPCPTTL returns the remaining TTL of a key in milliseconds.
+func (s *RPCService) RPCPTTL(req *RPCRequest, resp *RPCResponse) error {
+	if err := s.checkAuth("rpc-pttl", acl.CmdTTL, req.Key); err != nil {
+		return err
+	}
+	resp.TTL = s.Store.PTTL(req.Key)
+	resp.Success = resp.TTL != db.TTLKeyNotFound
+	if !resp.Success {
+		resp.Error = errKeyNotFound
+	}
+	s.logRequest("rpc-pttl", req)
+	return nil
+}
+
+// RPCExpire sets a key to expire after req.TTL seconds.
+func (s *RPCService) RPCExpire(req *RPCRequest, resp *RPCResponse) error {
+	if err := s.checkAuth("rpc-expire", acl.CmdExpire, req.Key); err != nil {
+		return err
+	}
+	keyResult(resp, s.Store.Expire(req.Key, req.TTL))
+	s.logRequest("rpc-expire", req)
+	return nil
+}
+
+// RPCPExpire sets a key to expire after req.TTL milliseconds.
+func (s *RPCService) RPCPExpire(req *RPCRequest, resp *RPCResponse) error {
+	if err := s.checkAuth("rpc-pexpire", acl.CmdExpire, req.Key); err != nil {
+		return err
+	}
+	keyResult(resp, s.Store.PExpire(req.Key, req.TTL))
+	s.logRequest("rpc-pexpire", req)
+	return nil
+}
+
+// RPCExpireAt sets a key to expire at req.ExpireAt (Unix seconds).
+func (s *RPCService) RPCExpireAt(req *RPCRequest, resp *RPCResponse) error {
+	if err := s.checkAuth("rpc-expireat", acl.CmdExpire, req.Key); err != nil {
+		return err
+	}
+	keyResult(resp, s.Store.ExpireAt(req.Key, req.ExpireAt))
+	s.logRequest("rpc-expireat", req)
+	return nil
+}
+
+// RPCPExpireAt sets a key to expire at req.ExpireAt (Unix milliseconds).
+func (s *RPCService) RPCPExpireAt(req *RPCRequest, resp *RPCResponse) error {
+	if err := s.checkAuth("rpc-pexpireat", acl.CmdExpire, req.Key); err != nil {
+		return err
+	}
+	keyResult(resp, s.Store.PExpireAt(req.Key, req.ExpireAt))
+	s.logRequest("rpc-pexpireat", req)
+	return nil
+}
+
+// RPCPersist removes the expiration of a key.
+func (s *RPCService) RPCPersist(req *RPCRequest, resp *RPCResponse) error {
+	if err := s.checkAuth("rpc-persist", acl.CmdExpire, req.Key); err != nil {
+		return err
+	}
+	resp.Success = s.Store.Persist(req.Key)
+	if !resp.Success {
+		resp.Error = "Key not found or has no expiration"
+	}
+	s.logRequest("rpc-persist", req)
+	return nil
+}
+
+// RPCGetEx returns a value and resets its expiration to req.TTL seconds. A
+// TTL of 0 removes the expiration and a negative one deletes the key.
+func (s *RPCService) RPCGetEx(req *RPCRequest, resp *RPCResponse) error {
+	if err := s.checkAuth("rpc-getex", acl.CmdGetEx, req.Key); err != nil {
+		return err
+	}
+	resp.Data, resp.Success = s.Store.GetEx(req.Key, req.TTL)
+	if !resp.Success {
+		resp.Error = errKeyNotFound
+	}
+	s.logRequest("rpc-getex", req)
+	return nil
+}
+
+// RPCPGetEx returns a value and resets its expiration to req.TTL milliseconds.
+func (s *RPCService) RPCPGetEx(req *RPCRequest, resp *RPCResponse) error {
+	if err := s.checkAuth("rpc-pgetex", acl.CmdGetEx, req.Key); err != nil {
+		return err
+	}
+	resp.Data, resp.Success = s.Store.PGetEx(req.Key, req.TTL)
+	if !resp.Success {
+		resp.Error = errKeyNotFound
+	}
+	s.logRequest("rpc-pgetex", req)
+	return nil
+}
+
+// keyResult fills a response for commands that only fail on missing keys.
+func keyResult(resp *RPCResponse, found bool) {
+	resp.Success = found
+	if !found {
+		resp.Error = errKeyNotFound
+	}
+}
+
+// logRequest writes an RPC call to the request log, if it is sampled. req
+// must not contain secrets; the values of an *RPCRequest are redacted as the
+// logger is configured to.
+func (s *RPCService) logRequest(method string, req any) {
+	args := []any{
+		"method", method,
+		"client", s.remoteAddr,
+		"user", s.principal(),
+		"request", req,
 	}
+	if r, ok := req.(*RPCRequest); ok {
+		if c, ok := trace.ParseTraceParent(r.TraceParent); ok {
+			args = append(args, "trace_id", c.TraceID.String())
+		}
+	}
+	s.Logger.Request("rpc request", args...)
+}
 
-	// Convert the log message to JSON
-	logJSON, err := json.Marshal(logMessage)
+// InfoRequest is the argument of RPCService.Info.
+type InfoRequest struct {
+	Section string // one of info.Sections, or "" for all of them
+}
+
+// Info returns the server information as text in resp.Data, see info.Info.Format.
+func (s *RPCService) Info(req *InfoRequest, resp *RPCResponse) error {
+	if err := s.checkAuth("rpc-info", acl.CmdInfo, ""); err != nil {
+		return err
+	}
+	if s.info == nil {
+		resp.Error = "info is not available"
+		return nil
+	}
+	text, err := s.info.Collect().Format(req.Section)
 	if err != nil {
-		// Handle JSON marshaling error (optional)
-		s.Logger.Log("Error marshaling log message to JSON")
+		resp.Error = err.Error()
+		return nil
+	}
+	resp.Success = true
+	resp.Data = text
+	return nil
+}
+
+// SlowlogRequest is the argument of RPCService.SlowlogGet and SlowlogReset.
+type SlowlogRequest struct {
+	Count int // entries to return, all if not positive
+}
+
+// SlowlogResponse is the reply of RPCService.SlowlogGet.
+type SlowlogResponse struct {
+	Entries []slowlog.Entry // newest first
+	Len     int             // entries in the slow log
+}
+
+// SlowlogGet returns the newest entries of the slow log.
+func (s *RPCService) SlowlogGet(req *SlowlogRequest, resp *SlowlogResponse) error {
+	if err := s.checkAuth("rpc-slowlog-get", acl.CmdSlowlog, ""); err != nil {
 		return err
 	}
+	resp.Entries = s.slowlog.Entries(req.Count)
+	resp.Len = s.slowlog.Len()
+	return nil
+}
+
+// SlowlogReset empties the slow log.
+func (s *RPCService) SlowlogReset(req *SlowlogRequest, resp *RPCResponse) error {
+	if err := s.checkAuth("rpc-slowlog-reset", acl.CmdSlowlog, ""); err != nil {
+		return err
+	}
+	s.slowlog.Reset()
+	resp.Success = true
+	return nil
+}
+
+// MonitorRequest is the argument of RPCService.Monitor and MonitorStop.
+type MonitorRequest struct {
+	MaxValue int // cut values after this many bytes, 0 to show them whole
+}
+
+// MonitorResponse is the reply of RPCService.Monitor.
+type MonitorResponse struct {
+	Lines []string // one per command, see monitor.Event.Format
+}
+
+// monitorBatch is the most lines returned by one call to Monitor.
+const monitorBatch = 1000
+
+// Monitor returns the commands served by the node since the previous call,
+// waiting up to a second for one. The first call subscribes the connection;
+// MonitorStop or closing the connection ends the subscription.
+func (s *RPCService) Monitor(req *MonitorRequest, resp *MonitorResponse) error {
+	if err := s.checkAuth("rpc-monitor", acl.CmdMonitor, ""); err != nil {
+		return err
+	}
+	if s.hub == nil {
+		return errors.New("monitor is not available")
+	}
+	s.mu.Lock()
+	if s.monitor == nil {
+		s.monitor = s.hub.Subscribe()
+	}
+	sub := s.monitor
+	s.mu.Unlock()
+
+	add := func(e monitor.Event) {
+		if n := sub.Dropped(); n > 0 {
+			resp.Lines = append(resp.Lines, fmt.Sprintf("(%d commands dropped)", n))
+		}
+		resp.Lines = append(resp.Lines, e.Format(req.MaxValue))
+	}
+	// Wait for the first event, then take those already queued.
+	timer := time.NewTimer(time.Second)
+	defer timer.Stop()
+	select {
+	case e, ok := <-sub.Events():
+		if !ok {
+			return errors.New("monitor closed, the server is shutting down")
+		}
+		add(e)
+	case <-timer.C:
+		return nil
+	}
+	for len(resp.Lines) < monitorBatch {
+		select {
+		case e, ok := <-sub.Events():
+			if !ok {
+				return nil
+			}
+			add(e)
+		default:
+			return nil
+		}
+	}
+	return nil
+}
 
-	// Log the structured message as a JSON string
-	s.Logger.Log(string(logJSON))
+// MonitorStop ends the subscription started by Monitor.
+func (s *RPCService) MonitorStop(req *MonitorRequest, resp *RPCResponse) error {
+	s.close()
+	resp.Success = true
 	return nil
 }
 
+// close releases the resources of the connection's service.
+func (s *RPCService) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.monitor != nil {
+		s.monitor.Close()
+		s.monitor = nil
+	}
+}
+
+// KeysReportRequest is the argument of RPCService.HotKeys and BigKeys.
+type KeysReportRequest struct {
+	Count int // keys to return, at most db.MaxKeyReport, 10 if 0
+}
+
+// count returns the number of keys requested.
+func (r *KeysReportRequest) count() int {
+	if r.Count <= 0 {
+		return 10
+	}
+	return r.Count
+}
+
+// HotKeysResponse is the reply of RPCService.HotKeys.
+type HotKeysResponse struct {
+	Keys []db.HotKey // hottest first, empty if tracking is disabled
+}
+
+// BigKeysResponse is the reply of RPCService.BigKeys.
+type BigKeysResponse struct {
+	Keys []db.BigKey // largest first
+}
+
+// HotKeys returns the most accessed keys.
+func (s *RPCService) HotKeys(req *KeysReportRequest, resp *HotKeysResponse) error {
+	if err := s.checkAuth("rpc-hotkeys", acl.CmdHotKeys, ""); err != nil {
+		return err
+	}
+	resp.Keys = s.Store.HotKeys(req.count())
+	return nil
+}
+
+// BigKeys returns the largest keys. It scans the whole store.
+func (s *RPCService) BigKeys(req *KeysReportRequest, resp *BigKeysResponse) error {
+	if err := s.checkAuth("rpc-bigkeys", acl.CmdBigKeys, ""); err != nil {
+		return err
+	}
+	resp.Keys = s.Store.BigKeys(req.count())
+	return nil
+}
+
+// openConnections counts the RPC connections being served.
+var openConnections atomic.Int64
+
+// OpenConnections returns the number of open RPC connections.
+func OpenConnections() int64 { return openConnections.Load() }
+
 func (s *RPCService) Ping(args struct{}, reply *bool) error {
 	*reply = true
 	return nil
 }
 
-// StartRPCServer starts the RPC server.
-func StartRPCServer(store *db.ShardedInMemoryStore, port string, logger *logger.Logger) {
-	rpcService := &RPCService{Store: store, Logger: logger}
-	rpc.Register(rpcService)
-
-	listener, err := net.Listen("tcp", port)
+// StartRPCServer starts the RPC server on cfg.RPCPort, over TLS if tlsConfig
+// is not nil. Unless accessList is nil, connections must call
+// RPCService.Auth before using the store. auditLog, collector, slow and hub
+// may be nil.
+func StartRPCServer(store *db.ShardedInMemoryStore, cfg *config.Config, accessList *acl.List, auditLog *audit.Log, collector *info.Collector, slow *slowlog.Log, hub *monitor.Hub, tracer *trace.Tracer, logger *logger.Logger, tlsConfig *tls.Config) {
+	listener, err := net.Listen("tcp", cfg.RPCPort)
 	if err != nil {
 		panic("Error starting RPC server: " + err.Error())
 	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
 	defer listener.Close()
 
-	fmt.Println("Starting RPC server on", port)
+	slog.Info("starting RPC server", "addr", cfg.RPCPort, "tls", tlsConfig != nil)
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
 			continue
 		}
-		go rpc.ServeConn(conn) // Handle each RPC connection in a new goroutine
+		// Each connection gets its own service so that it authenticates separately.
+		server := rpc.NewServer()
+		service := &RPCService{
+			Store:      store,
+			Logger:     logger,
+			acl:        accessList,
+			audit:      auditLog,
+			info:       collector,
+			slowlog:    slow,
+			hub:        hub,
+			remoteAddr: conn.RemoteAddr().String(),
+		}
+		server.RegisterName("RPCService", service)
+		// Handle each RPC connection in a new goroutine
+		openConnections.Add(1)
+		go func() {
+			defer openConnections.Add(-1)
+			defer service.close()
+			server.ServeCodec(newServerCodec(conn, slow, hub, tracer))
+		}()
 	}
 }
@@ -0,0 +1,153 @@
+// Package slowlog keeps the most recent operations that took at least a
+// threshold to serve, for finding the keys and clients behind latency spikes.
+package slowlog
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// An Entry is one slow operation.
+type Entry struct {
+	ID        int64         `json:"id"` // increases with each entry, also across Reset
+	Time      time.Time     `json:"time"`
+	Duration  time.Duration `json:"-"`        // written in microseconds as duration_us
+	Protocol  string        `json:"protocol"` // http, rpc or cluster
+	Command   string        `json:"command"`
+	Key       string        `json:"key,omitempty"`
+	ValueSize int           `json:"value_size"` // bytes of the value written or read, 0 if none
+	Client    string        `json:"client"`     // client address
+	TraceID   string        `json:"trace_id,omitempty"`
+}
+
+// MarshalJSON writes the duration in microseconds, as Redis does.
+func (e Entry) MarshalJSON() ([]byte, error) {
+	type entry Entry // without this method
+	return json.Marshal(struct {
+		entry
+		DurationUS int64 `json:"duration_us"`
+	}{entry(e), e.Duration.Microseconds()})
+}
+
+// A Log holds the latest slow operations in a ring of fixed size. A nil *Log
+// records nothing, so callers do not need to check whether it is enabled.
+type Log struct {
+	mu        sync.Mutex
+	threshold time.Duration
+	entries   []Entry // ring, entries[start] is the oldest
+	start     int
+	size      int
+	nextID    int64
+}
+
+// New returns a log of at most maxLen entries that records operations taking
+// at least threshold. A threshold of 0 records every operation and a
+// negative one none.
+func New(threshold time.Duration, maxLen int) *Log {
+	return &Log{threshold: threshold, entries: make([]Entry, max(maxLen, 1))}
+}
+
+// Configure changes the threshold and the maximum length. Shrinking the log
+// drops its oldest entries.
+func (l *Log) Configure(threshold time.Duration, maxLen int) {
+	if l == nil {
+		return
+	}
+	maxLen = max(maxLen, 1)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.threshold = threshold
+	if maxLen == len(l.entries) {
+		return
+	}
+	entries := l.ordered(maxLen)
+	l.entries = make([]Entry, maxLen)
+	l.size = copy(l.entries, entries)
+	l.start = 0
+}
+
+// Slow reports whether an operation taking took should be recorded, so that
+// callers can skip gathering its details.
+func (l *Log) Slow(took time.Duration) bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.threshold >= 0 && took >= l.threshold
+}
+
+// Record adds an entry if its duration reaches the threshold. ID is set by
+// Record and Time defaults to now.
+func (l *Log) Record(e Entry) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.threshold < 0 || e.Duration < l.threshold {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.ID = l.nextID
+	l.nextID++
+	if l.size < len(l.entries) {
+		l.entries[(l.start+l.size)%len(l.entries)] = e
+		l.size++
+		return
+	}
+	l.entries[l.start] = e
+	l.start = (l.start + 1) % len(l.entries)
+}
+
+// Entries returns up to count entries, newest first, or all of them if count
+// is not positive.
+func (l *Log) Entries(count int) []Entry {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if count <= 0 || count > l.size {
+		count = l.size
+	}
+	entries := make([]Entry, count)
+	for i := range entries {
+		entries[i] = l.entries[(l.start+l.size-1-i)%len(l.entries)]
+	}
+	return entries
+}
+
+// Len returns the number of entries in the log.
+func (l *Log) Len() int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.size
+}
+
+// Reset removes every entry.
+func (l *Log) Reset() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.start, l.size = 0, 0
+	clear(l.entries)
+}
+
+// ordered returns the newest n entries, oldest first.
+func (l *Log) ordered(n int) []Entry {
+	n = min(n, l.size)
+	entries := make([]Entry, n)
+	for i := range entries {
+		entries[i] = l.entries[(l.start+l.size-n+i)%len(l.entries)]
+	}
+	return entries
+}
@@ -0,0 +1,48 @@
+package slowlog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLog(t *testing.T) {
+	l := New(10*time.Millisecond, 3)
+	l.Record(Entry{Command: "get", Duration: time.Millisecond})
+	for i := 0; i < 5; i++ {
+		l.Record(Entry{Command: "set", Key: string(rune('a' + i)), Duration: 20 * time.Millisecond})
+	}
+
+	entries := l.Entries(0)
+	if len(entries) != 3 || entries[0].Key != "e" || entries[2].Key != "c" {
+		t.Fatalf("expected the 3 newest slow entries, newest first, got %+v", entries)
+	}
+	if entries[0].ID != 4 || entries[0].Time.IsZero() {
+		t.Fatalf("expected ID and Time to be set, got %+v", entries[0])
+	}
+	if got := l.Entries(1); len(got) != 1 || got[0].Key != "e" {
+		t.Fatalf("expected only the newest entry, got %+v", got)
+	}
+
+	l.Configure(10*time.Millisecond, 2)
+	if entries := l.Entries(0); len(entries) != 2 || entries[0].Key != "e" || entries[1].Key != "d" {
+		t.Fatalf("expected shrinking to keep the newest entries, got %+v", entries)
+	}
+
+	l.Reset()
+	l.Record(Entry{Key: "f", Duration: time.Second})
+	if entries := l.Entries(0); len(entries) != 1 || entries[0].ID != 5 {
+		t.Fatalf("expected IDs to continue after Reset, got %+v", entries)
+	}
+
+	l.Configure(-1, 2)
+	l.Record(Entry{Key: "g", Duration: time.Hour})
+	if l.Len() != 1 || l.Slow(time.Hour) {
+		t.Fatal("expected a negative threshold to disable the log")
+	}
+
+	var disabled *Log
+	disabled.Record(Entry{Duration: time.Hour})
+	if disabled.Len() != 0 || disabled.Entries(0) != nil {
+		t.Fatal("expected a nil log to record nothing")
+	}
+}
@@ -0,0 +1,351 @@
+package trace
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	queueSize      = 4096            // spans waiting to be exported before new ones are dropped
+	batchSize      = 512             // spans per export
+	exportInterval = time.Second     // longest a span waits to be exported
+	exportTimeout  = 5 * time.Second // for posting to a collector
+	scopeName      = "github.com/shafigh75/Memorandum"
+)
+
+// Options configures a Tracer.
+type Options struct {
+	File       string // append OTLP JSON lines to this file, "" for none
+	Endpoint   string // post OTLP JSON to this collector URL, e.g. http://localhost:4318/v1/traces, "" for none
+	SampleRate int    // export one trace in SampleRate, for requests that do not carry a sampling decision
+	Instance   string // identifies this node in exported spans
+}
+
+// SpanData is a finished span.
+type SpanData struct {
+	TraceID      string
+	SpanID       string
+	ParentSpanID string // "" for the root span of a trace
+	Name         string
+	Kind         Kind
+	Start, End   time.Time
+	Attrs        []Attr
+	Error        string // "" if the operation succeeded
+	Instance     string // node that recorded the span, set by ReadFile
+}
+
+// A Tracer exports finished spans in batches from a background goroutine. A
+// nil *Tracer exports nothing.
+type Tracer struct {
+	opts       Options
+	sampleRate atomic.Int64
+	count      atomic.Uint64
+	file       *os.File
+	client     *http.Client
+
+	mu      sync.RWMutex // guards closed against export after Close
+	closed  bool
+	spans   chan SpanData
+	dropped atomic.Int64
+	done    chan struct{}
+}
+
+// NewTracer returns a tracer exporting to opts.File, opts.Endpoint or both.
+func NewTracer(opts Options) (*Tracer, error) {
+	t := &Tracer{
+		opts:   opts,
+		client: &http.Client{Timeout: exportTimeout},
+		spans:  make(chan SpanData, queueSize),
+		done:   make(chan struct{}),
+	}
+	t.sampleRate.Store(int64(opts.SampleRate))
+	if opts.File != "" {
+		file, err := os.OpenFile(opts.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		t.file = file
+	}
+	go t.run()
+	return t, nil
+}
+
+// SetSampleRate changes the share of traces exported, one in rate, 0 for
+// none but those that clients ask for.
+func (t *Tracer) SetSampleRate(rate int) {
+	if t != nil {
+		t.sampleRate.Store(int64(rate))
+	}
+}
+
+// sample decides whether a new trace is exported.
+func (t *Tracer) sample() bool {
+	if t == nil {
+		return false
+	}
+	rate := t.sampleRate.Load()
+	return rate == 1 || (rate > 1 && t.count.Add(1)%uint64(rate) == 0)
+}
+
+// export queues a span without blocking. Spans are dropped when the exporter
+// falls behind, and the number dropped is logged.
+func (t *Tracer) export(span SpanData) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.closed {
+		return
+	}
+	select {
+	case t.spans <- span:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+// run exports the queued spans in batches.
+func (t *Tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	batch := make([]SpanData, 0, batchSize)
+	flush := func() {
+		if dropped := t.dropped.Swap(0); dropped > 0 {
+			slog.Warn("spans dropped, the trace exporter is too slow", "dropped", dropped)
+		}
+		if len(batch) > 0 {
+			t.write(batch)
+			batch = batch[:0]
+		}
+	}
+	for {
+		select {
+		case span, ok := <-t.spans:
+			if !ok {
+				flush()
+				return
+			}
+			if batch = append(batch, span); len(batch) == batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// write exports a batch as one OTLP request.
+func (t *Tracer) write(batch []SpanData) {
+	line, err := json.Marshal(t.otlp(batch))
+	if err != nil {
+		slog.Error("encoding spans failed", "err", err)
+		return
+	}
+	if t.file != nil {
+		if _, err := t.file.Write(append(line, '\n')); err != nil {
+			slog.Error("writing spans failed", "file", t.opts.File, "err", err)
+		}
+	}
+	if t.opts.Endpoint != "" {
+		resp, err := t.client.Post(t.opts.Endpoint, "application/json", bytes.NewReader(line))
+		if err != nil {
+			slog.Warn("exporting spans failed", "endpoint", t.opts.Endpoint, "err", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			slog.Warn("exporting spans failed", "endpoint", t.opts.Endpoint, "status", resp.StatusCode)
+		}
+	}
+}
+
+// Close exports the queued spans and closes the file.
+func (t *Tracer) Close() error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	close(t.spans)
+	t.mu.Unlock()
+	<-t.done
+	if t.file != nil {
+		return t.file.Close()
+	}
+	return nil
+}
+
+// The OTLP JSON encoding of ExportTraceServiceRequest, as accepted by
+// OpenTelemetry collectors on /v1/traces and written by their file exporter.
+// 64-bit integers are strings.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope struct {
+			Name string `json:"name"`
+		} `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              Kind           `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"` // 1 ok, 2 error
+		Message string `json:"message,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string `json:"stringValue,omitempty"`
+		IntValue    *string `json:"intValue,omitempty"`
+		BoolValue   *bool   `json:"boolValue,omitempty"`
+	}
+)
+
+// otlp encodes a batch with the resource attributes of this node.
+func (t *Tracer) otlp(batch []SpanData) otlpRequest {
+	scope := otlpScopeSpans{Spans: make([]otlpSpan, len(batch))}
+	scope.Scope.Name = scopeName
+	for i, span := range batch {
+		s := otlpSpan{
+			TraceID:           span.TraceID,
+			SpanID:            span.SpanID,
+			ParentSpanID:      span.ParentSpanID,
+			Name:              span.Name,
+			Kind:              span.Kind,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Status:            otlpStatus{Code: 1},
+		}
+		if span.Error != "" {
+			s.Status = otlpStatus{Code: 2, Message: span.Error}
+		}
+		for _, attr := range span.Attrs {
+			s.Attributes = append(s.Attributes, otlpAttr(attr))
+		}
+		scope.Spans[i] = s
+	}
+	resource := otlpResource{Attributes: []otlpKeyValue{
+		otlpAttr(Attr{"service.name", "memorandum"}),
+		otlpAttr(Attr{"service.instance.id", t.opts.Instance}),
+	}}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{Resource: resource, ScopeSpans: []otlpScopeSpans{scope}}}}
+}
+
+func otlpAttr(attr Attr) otlpKeyValue {
+	kv := otlpKeyValue{Key: attr.Key}
+	switch v := attr.Value.(type) {
+	case int:
+		s := strconv.Itoa(v)
+		kv.Value.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		kv.Value.IntValue = &s
+	case bool:
+		kv.Value.BoolValue = &v
+	default:
+		s := fmt.Sprint(v)
+		kv.Value.StringValue = &s
+	}
+	return kv
+}
+
+// ReadFile returns the spans of a file written by a tracer, e.g. to show a
+// trace across the files of several nodes.
+func ReadFile(path string) ([]SpanData, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var spans []SpanData
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var req otlpRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			return nil, fmt.Errorf("%s: line %d: %w", path, line, err)
+		}
+		for _, rs := range req.ResourceSpans {
+			var instance string
+			for _, kv := range rs.Resource.Attributes {
+				if kv.Key == "service.instance.id" {
+					instance = kv.Value.String()
+				}
+			}
+			for _, ss := range rs.ScopeSpans {
+				for _, s := range ss.Spans {
+					span := SpanData{
+						TraceID:      s.TraceID,
+						SpanID:       s.SpanID,
+						ParentSpanID: s.ParentSpanID,
+						Name:         s.Name,
+						Kind:         s.Kind,
+						Start:        unixNano(s.StartTimeUnixNano),
+						End:          unixNano(s.EndTimeUnixNano),
+						Error:        s.Status.Message,
+						Instance:     instance,
+					}
+					if s.Status.Code == 2 && span.Error == "" {
+						span.Error = "error"
+					}
+					for _, kv := range s.Attributes {
+						span.Attrs = append(span.Attrs, Attr{kv.Key, kv.Value.String()})
+					}
+					spans = append(spans, span)
+				}
+			}
+		}
+	}
+	return spans, scanner.Err()
+}
+
+// String returns the value as text.
+func (v otlpValue) String() string {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.IntValue != nil:
+		return *v.IntValue
+	case v.BoolValue != nil:
+		return strconv.FormatBool(*v.BoolValue)
+	}
+	return ""
+}
+
+func unixNano(s string) time.Time {
+	n, _ := strconv.ParseInt(s, 10, 64)
+	return time.Unix(0, n)
+}
@@ -0,0 +1,274 @@
+// Package trace correlates the requests served by the nodes of a cluster. A
+// trace ID is taken from the W3C traceparent or X-Request-ID header of an
+// HTTP request, or made up, and carried to other nodes in RPC requests. Logs
+// include it, and the timing of each hop is recorded as a span, exported in
+// the OpenTelemetry (OTLP) JSON format.
+package trace
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// A TraceID identifies all the spans of a request across nodes.
+type TraceID [16]byte
+
+// A SpanID identifies one span of a trace.
+type SpanID [8]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+
+// IsZero reports whether the ID is unset, which W3C trace context forbids.
+func (t TraceID) IsZero() bool { return t == TraceID{} }
+
+// IsZero reports whether the ID is unset.
+func (s SpanID) IsZero() bool { return s == SpanID{} }
+
+// newTraceID returns a random trace ID. IDs need to be unique, not secret.
+func newTraceID() TraceID {
+	var t TraceID
+	for t.IsZero() {
+		for i := 0; i < len(t); i += 8 {
+			binary.BigEndian.PutUint64(t[i:], rand.Uint64())
+		}
+	}
+	return t
+}
+
+// newSpanID returns a random span ID.
+func newSpanID() SpanID {
+	var s SpanID
+	for s.IsZero() {
+		binary.BigEndian.PutUint64(s[:], rand.Uint64())
+	}
+	return s
+}
+
+// SpanContext is the part of a span passed to other nodes.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool // the span is exported, and so should its children be
+}
+
+// IsValid reports whether the context has a trace ID.
+func (c SpanContext) IsValid() bool { return !c.TraceID.IsZero() }
+
+// TraceParent formats the context as a W3C traceparent header value, such as
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01.
+func (c SpanContext) TraceParent() string {
+	flags := "00"
+	if c.Sampled {
+		flags = "01"
+	}
+	return "00-" + c.TraceID.String() + "-" + c.SpanID.String() + "-" + flags
+}
+
+// ParseTraceParent parses a W3C traceparent header value. It reports false
+// for values that are malformed or carry zero IDs, which are to be ignored.
+func ParseTraceParent(value string) (SpanContext, bool) {
+	var c SpanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	// Later versions may append fields, version ff is invalid.
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return c, false
+	}
+	if !decodeHex(c.TraceID[:], parts[1]) || !decodeHex(c.SpanID[:], parts[2]) || c.TraceID.IsZero() || c.SpanID.IsZero() {
+		return c, false
+	}
+	var flags [1]byte
+	if !decodeHex(flags[:], parts[3]) {
+		return c, false
+	}
+	c.Sampled = flags[0]&1 == 1
+	return c, true
+}
+
+// decodeHex decodes lowercase hex of exactly len(dst) bytes.
+func decodeHex(dst []byte, s string) bool {
+	if len(s) != 2*len(dst) || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
+
+// Header names of the trace context.
+const (
+	HeaderTraceParent = "traceparent"
+	HeaderRequestID   = "X-Request-ID"
+)
+
+// Extract returns the span context of an incoming HTTP request and its
+// X-Request-ID. Without a valid traceparent, the trace ID is derived from the
+// request ID, so that every node given the same request ID logs the same
+// trace ID; the returned context then has no span ID.
+func Extract(h http.Header) (parent SpanContext, requestID string) {
+	requestID = h.Get(HeaderRequestID)
+	if parent, ok := ParseTraceParent(h.Get(HeaderTraceParent)); ok {
+		return parent, requestID
+	}
+	if requestID != "" {
+		if !decodeHex(parent.TraceID[:], strings.ToLower(requestID)) || parent.TraceID.IsZero() {
+			sum := sha256.Sum256([]byte(requestID))
+			copy(parent.TraceID[:], sum[:])
+		}
+	}
+	return parent, requestID
+}
+
+// WriteHeaders sets the trace context of a response, so that clients can
+// find the request in logs and traces. The X-Request-ID of the request is
+// echoed, or the trace ID is used in its place.
+func WriteHeaders(h http.Header, span *Span, requestID string) {
+	c := span.Context()
+	h.Set(HeaderTraceParent, c.TraceParent())
+	if requestID == "" {
+		requestID = c.TraceID.String()
+	}
+	h.Set(HeaderRequestID, requestID)
+}
+
+// Kind tells whether a span serves a request or makes one, as in OTLP.
+type Kind int
+
+const (
+	KindServer Kind = 2
+	KindClient Kind = 3
+)
+
+// An Attr is a span attribute. Values are strings, integers or booleans.
+type Attr struct {
+	Key   string
+	Value any
+}
+
+// A Span times one operation of a trace. A nil *Span does nothing, so that
+// callers need not check whether a request is traced.
+type Span struct {
+	tracer   *Tracer // nil if the span is not exported
+	ctx      SpanContext
+	parent   SpanID
+	name     string
+	kind     Kind
+	start    time.Time
+	attrs    []Attr
+	errorMsg string
+	ended    atomic.Bool
+}
+
+// Context returns the IDs of the span.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.ctx
+}
+
+// TraceID returns the trace ID of the span as hex, "" for a nil span.
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return s.ctx.TraceID.String()
+}
+
+// SetAttr adds an attribute. It must not be called after End or from
+// several goroutines at once.
+func (s *Span) SetAttr(key string, value any) {
+	if s != nil && s.tracer != nil {
+		s.attrs = append(s.attrs, Attr{key, value})
+	}
+}
+
+// SetError marks the operation as failed.
+func (s *Span) SetError(msg string) {
+	if s != nil {
+		s.errorMsg = msg
+	}
+}
+
+// End records the span. Calls after the first do nothing.
+func (s *Span) End() {
+	if s == nil || s.ended.Swap(true) || s.tracer == nil {
+		return
+	}
+	s.tracer.export(SpanData{
+		TraceID:      s.ctx.TraceID.String(),
+		SpanID:       s.ctx.SpanID.String(),
+		ParentSpanID: parentString(s.parent),
+		Name:         s.name,
+		Kind:         s.kind,
+		Start:        s.start,
+		End:          time.Now(),
+		Attrs:        s.attrs,
+		Error:        s.errorMsg,
+	})
+}
+
+func parentString(id SpanID) string {
+	if id.IsZero() {
+		return ""
+	}
+	return id.String()
+}
+
+// StartServer starts a span for a request received from a client, continuing
+// the trace of parent if it is valid. Requests that do not carry a sampling
+// decision are sampled at the tracer's rate. A nil tracer gives spans that
+// have IDs for logs but are not exported.
+func (t *Tracer) StartServer(name string, parent SpanContext) *Span {
+	s := &Span{name: name, kind: KindServer, start: time.Now(), parent: parent.SpanID}
+	s.ctx = SpanContext{TraceID: parent.TraceID, SpanID: newSpanID()}
+	if !parent.IsValid() {
+		s.ctx.TraceID = newTraceID()
+	}
+	if parent.SpanID.IsZero() {
+		s.ctx.Sampled = t.sample()
+	} else {
+		s.ctx.Sampled = parent.Sampled
+	}
+	if s.ctx.Sampled && t != nil {
+		s.tracer = t
+	}
+	return s
+}
+
+// StartClient starts a span for a request made to another node on behalf of
+// parent. Its context is sent with the request, see SpanContext.TraceParent.
+func StartClient(parent *Span, name string) *Span {
+	if parent == nil {
+		s := (*Tracer)(nil).StartServer(name, SpanContext{})
+		s.kind = KindClient
+		return s
+	}
+	return &Span{
+		tracer: parent.tracer,
+		ctx:    SpanContext{TraceID: parent.ctx.TraceID, SpanID: newSpanID(), Sampled: parent.ctx.Sampled},
+		parent: parent.ctx.SpanID,
+		name:   name,
+		kind:   KindClient,
+		start:  time.Now(),
+	}
+}
+
+type spanKey struct{}
+
+// NewContext returns a context carrying span.
+func NewContext(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// FromContext returns the span of ctx, or nil.
+func FromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
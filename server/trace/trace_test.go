@@ -0,0 +1,90 @@
+package trace
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestTraceParent(t *testing.T) {
+	const header = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	c, ok := ParseTraceParent(header)
+	if !ok || !c.Sampled || c.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("got %+v, %v", c, ok)
+	}
+	if c.TraceParent() != header {
+		t.Errorf("expected %s, got %s", header, c.TraceParent())
+	}
+	for _, bad := range []string{
+		"",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01", // zero trace ID
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", // zero span ID
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", // uppercase
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", // invalid version
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		if _, ok := ParseTraceParent(bad); ok {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestExtract(t *testing.T) {
+	h := http.Header{}
+	h.Set(HeaderRequestID, "checkout-42")
+	first, id := Extract(h)
+	second, _ := Extract(h)
+	if id != "checkout-42" || !first.IsValid() || first.TraceID != second.TraceID || !first.SpanID.IsZero() {
+		t.Fatalf("expected the same trace ID for the same request ID, got %+v and %+v", first, second)
+	}
+
+	h.Set(HeaderTraceParent, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	if c, _ := Extract(h); c.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || c.Sampled {
+		t.Errorf("expected traceparent to take precedence, got %+v", c)
+	}
+}
+
+func TestExport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.json")
+	tracer, err := NewTracer(Options{File: path, SampleRate: 1, Instance: "node-a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := tracer.StartServer("cluster set", SpanContext{})
+	client := StartClient(server, "rpc RPCSet")
+	client.SetAttr("server.address", "node-b:1234")
+	client.SetError("connection refused")
+	client.End()
+	server.End()
+	server.End()
+
+	// Remote parents decide sampling.
+	parent, _ := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	tracer.StartServer("rpc RPCGet", parent).End()
+	if err := tracer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	spans, err := ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %+v", spans)
+	}
+	got, root := spans[0], spans[1]
+	if root.ParentSpanID != "" || root.Instance != "node-a" || root.Kind != KindServer {
+		t.Errorf("unexpected root span %+v", root)
+	}
+	if got.TraceID != root.TraceID || got.ParentSpanID != root.SpanID || got.Kind != KindClient ||
+		got.Error != "connection refused" || len(got.Attrs) != 1 || got.Attrs[0].Value != "node-b:1234" {
+		t.Errorf("unexpected child span %+v", got)
+	}
+
+	var disabled *Tracer
+	span := disabled.StartServer("get", SpanContext{})
+	if !span.Context().IsValid() {
+		t.Error("expected spans of a nil tracer to have IDs for logs")
+	}
+	span.End()
+}
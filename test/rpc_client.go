@@ -3,6 +3,7 @@ package main
 import (
         "fmt"
         "net/rpc"
+        "os"
 )
 
 // RPCRequest and RPCResponse structures
@@ -12,6 +13,10 @@ type RPCRequest struct {
         TTL   int64  `json:"ttl"` // TTL in seconds
 }
 
+type AuthRequest struct {
+        Token string
+}
+
 type RPCResponse struct {
         Success bool   `json:"success"`
         Data    string `json:"data,omitempty"`
@@ -27,6 +32,16 @@ func main() {
         }
         defer client.Close()
 
+        // Authenticate the connection if the server requires a token
+        if token := os.Getenv("MEMORANDUM_AUTH_TOKEN"); token != "" {
+                var authResp RPCResponse
+                err = client.Call("RPCService.Auth", &AuthRequest{Token: token}, &authResp)
+                if err != nil || !authResp.Success {
+                        fmt.Println("Error authenticating:", err, authResp.Error)
+                        return
+                }
+        }
+
         // Example: Set a key
         setReq := RPCRequest{Key: "exampleKey", Value: "exampleValue", TTL: 60}
         var setResp RPCResponse
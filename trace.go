@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shafigh75/Memorandum/server/trace"
+	"github.com/spf13/cobra"
+)
+
+var traceCmd = &cobra.Command{
+	Use:   "trace [span-file...]",
+	Short: "Show the request traces recorded in span files",
+	Long: `Lists the most recent traces in the span files written by trace_file, or
+shows the spans of one trace as a tree with --id. Pass the files of several
+nodes to follow a request across the cluster. The file defaults to trace_file
+from the config.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, _ := cmd.Flags().GetString("id")
+		limit, _ := cmd.Flags().GetInt("limit")
+		return showTraces(args, id, limit)
+	},
+}
+
+func init() {
+	traceCmd.Flags().String("id", "", "trace ID, e.g. from the X-Request-ID or traceparent response header")
+	traceCmd.Flags().Int("limit", 20, "number of traces listed")
+	rootCmd.AddCommand(traceCmd)
+}
+
+func showTraces(paths []string, id string, limit int) error {
+	if len(paths) == 0 {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		if cfg.TraceFile == "" {
+			return errors.New("trace_file is not set in the config")
+		}
+		paths = []string{cfg.TraceFile}
+	}
+
+	traces := make(map[string][]trace.SpanData)
+	for _, path := range paths {
+		spans, err := trace.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for _, span := range spans {
+			traces[span.TraceID] = append(traces[span.TraceID], span)
+		}
+	}
+	if id != "" {
+		spans, ok := traces[strings.ToLower(id)]
+		if !ok {
+			return fmt.Errorf("trace %s not found", id)
+		}
+		printTrace(spans)
+		return nil
+	}
+
+	type summary struct {
+		id         string
+		start, end time.Time
+		name       string
+		spans      int
+		failed     bool
+	}
+	summaries := make([]summary, 0, len(traces))
+	for id, spans := range traces {
+		s := summary{id: id, start: spans[0].Start, end: spans[0].End, name: spans[0].Name, spans: len(spans)}
+		for _, span := range spans {
+			if span.Start.Before(s.start) {
+				s.start, s.name = span.Start, span.Name
+			}
+			if span.End.After(s.end) {
+				s.end = span.End
+			}
+			s.failed = s.failed || span.Error != ""
+		}
+		summaries = append(summaries, s)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].start.After(summaries[j].start) })
+	for _, s := range summaries[:min(limit, len(summaries))] {
+		status := "ok"
+		if s.failed {
+			status = Red + "error" + Reset
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\tspans=%d\t%s\n", s.id, s.start.Format("2006-01-02 15:04:05.000"),
+			s.end.Sub(s.start), s.name, s.spans, status)
+	}
+	fmt.Printf("(%d of %d traces)\n", min(limit, len(summaries)), len(summaries))
+	return nil
+}
+
+// printTrace prints the spans of a trace as a tree, each with its start
+// relative to the trace and its duration.
+func printTrace(spans []trace.SpanData) {
+	sort.Slice(spans, func(i, j int) bool { return spans[i].Start.Before(spans[j].Start) })
+	known := make(map[string]bool, len(spans))
+	for _, span := range spans {
+		known[span.SpanID] = true
+	}
+	children := make(map[string][]trace.SpanData)
+	for _, span := range spans {
+		parent := span.ParentSpanID
+		if !known[parent] {
+			parent = "" // the root, or the parent was not sampled or is in a file not given
+		}
+		children[parent] = append(children[parent], span)
+	}
+
+	start := spans[0].Start
+	var print func(parent string, depth int)
+	print = func(parent string, depth int) {
+		for _, span := range children[parent] {
+			line := fmt.Sprintf("%s%s\t+%s\t%s\t%s", strings.Repeat("  ", depth), span.Name,
+				span.Start.Sub(start), span.End.Sub(span.Start), span.Instance)
+			for _, attr := range span.Attrs {
+				line += fmt.Sprintf("\t%s=%v", attr.Key, attr.Value)
+			}
+			if span.Error != "" {
+				line += "\t" + Red + "error: " + span.Error + Reset
+			}
+			fmt.Println(line)
+			print(span.SpanID, depth+1)
+		}
+	}
+	print("", 0)
+}
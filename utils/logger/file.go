@@ -0,0 +1,245 @@
+package logger
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// queueSize is the number of lines that may wait to be written before new
+// lines are dropped.
+const queueSize = 4096
+
+// backupTimeFormat names rotated files, e.g. http.log.2024-06-10T12-00-00.000,
+// so that they sort by age.
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// rotatingFile is a buffered log file that is renamed and replaced by a new
+// one once it grows too large or too old.
+type rotatingFile struct {
+	mu             sync.Mutex
+	path           string
+	file           *os.File
+	buf            *bufio.Writer
+	size           int64
+	opened         time.Time
+	maxSize        int64
+	rotateInterval time.Duration
+	maxBackups     int
+}
+
+// openRotatingFile opens path for appending.
+func openRotatingFile(path string, opts Options) (*rotatingFile, error) {
+	f := &rotatingFile{}
+	f.configure(opts)
+	if err := f.open(path); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// configure changes the rotation limits.
+func (f *rotatingFile) configure(opts Options) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.maxSize, f.rotateInterval, f.maxBackups = opts.MaxSize, opts.RotateInterval, opts.MaxBackups
+}
+
+// open makes path the current file. f.mu must be held or f not yet shared.
+func (f *rotatingFile) open(path string) error {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.path, f.file, f.size, f.opened = path, file, info.Size(), time.Now()
+	f.buf = bufio.NewWriterSize(file, 64<<10)
+	return nil
+}
+
+// write appends a line, rotating the file first if it is due.
+func (f *rotatingFile) write(line []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.size > 0 && ((f.maxSize > 0 && f.size+int64(len(line)) > f.maxSize) ||
+		(f.rotateInterval > 0 && time.Since(f.opened) >= f.rotateInterval)) {
+		if err := f.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := f.buf.Write(line)
+	f.size += int64(n)
+	return err
+}
+
+// flush writes the buffered lines to the file.
+func (f *rotatingFile) flush() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.buf.Flush()
+}
+
+// rotate renames the current file with the time as suffix, starts a new one
+// and removes the oldest rotated files beyond maxBackups. f.mu must be held.
+func (f *rotatingFile) rotate() error {
+	f.buf.Flush()
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.path, backupName(f.path)); err != nil {
+		f.open(f.path) // keep appending to the current file
+		return err
+	}
+	if err := f.open(f.path); err != nil {
+		return err
+	}
+	if f.maxBackups > 0 {
+		backups, err := listBackups(f.path)
+		if err != nil {
+			return err
+		}
+		for _, old := range backups[:max(len(backups)-f.maxBackups, 0)] {
+			os.Remove(old)
+		}
+	}
+	return nil
+}
+
+// backupName returns an unused name for a rotated file of path. Names are
+// taken a millisecond later when files are rotated faster than that.
+func backupName(path string) string {
+	at := time.Now()
+	for {
+		name := path + "." + at.Format(backupTimeFormat)
+		if _, err := os.Lstat(name); os.IsNotExist(err) {
+			return name
+		}
+		at = at.Add(time.Millisecond)
+	}
+}
+
+// listBackups returns the rotated files of path, oldest first.
+func listBackups(path string) ([]string, error) {
+	dir, prefix := filepath.Dir(path), filepath.Base(path)+"."
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if _, err := time.Parse(backupTimeFormat, name[len(prefix):]); err == nil {
+			backups = append(backups, filepath.Join(dir, name))
+		}
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+// reopen switches to another file. On error the current file stays open.
+func (f *rotatingFile) reopen(path string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.buf.Flush()
+	old := f.file
+	if err := f.open(path); err != nil {
+		return err
+	}
+	return old.Close()
+}
+
+// close flushes and closes the file.
+func (f *rotatingFile) close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.buf.Flush()
+	return f.file.Close()
+}
+
+// asyncWriter hands lines to a goroutine that writes them to the file, so
+// that logging never waits on the disk. Lines are dropped when the queue is
+// full; the number dropped is logged once there is room again.
+type asyncWriter struct {
+	mu      sync.RWMutex // guards closed against Write after Close
+	closed  bool
+	lines   chan []byte
+	dropped atomic.Int64
+	done    chan struct{}
+	file    *rotatingFile
+}
+
+func newAsyncWriter(file *rotatingFile) *asyncWriter {
+	w := &asyncWriter{lines: make(chan []byte, queueSize), done: make(chan struct{}), file: file}
+	go w.run()
+	return w
+}
+
+// Write queues a copy of p, which slog passes one record at a time.
+func (w *asyncWriter) Write(p []byte) (int, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+	select {
+	case w.lines <- append([]byte(nil), p...):
+	default:
+		w.dropped.Add(1)
+	}
+	return len(p), nil
+}
+
+// run writes the queued lines, flushing whenever the queue runs empty so
+// that lines are batched under load and visible quickly otherwise.
+func (w *asyncWriter) run() {
+	defer close(w.done)
+	for line := range w.lines {
+		if dropped := w.dropped.Swap(0); dropped > 0 {
+			w.writeLine([]byte(fmt.Sprintf(`{"time":%q,"level":"WARN","msg":"log lines dropped, the disk is too slow","dropped":%d}`+"\n",
+				time.Now().Format(time.RFC3339Nano), dropped)))
+		}
+		w.writeLine(line)
+		if len(w.lines) == 0 {
+			w.file.flush()
+		}
+	}
+}
+
+// writeLine writes a line, reporting failures on stderr since the log
+// itself cannot record them.
+func (w *asyncWriter) writeLine(line []byte) {
+	if err := w.file.write(line); err != nil {
+		fmt.Fprintln(os.Stderr, "Error writing log:", err)
+	}
+}
+
+// Close writes the queued lines and stops the writer.
+func (w *asyncWriter) Close() {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return
+	}
+	w.closed = true
+	close(w.lines)
+	w.mu.Unlock()
+	<-w.done
+}
@@ -1,67 +1,169 @@
+// Package logger writes leveled, structured logs built on log/slog. Logs to a
+// file are JSON lines, written in the background so that requests do not wait
+// on the disk, and the file is rotated by size and age with a bounded number
+// of old files kept. Logs without a file go to stderr as text.
+//
+// Attributes named ValueKey hold stored values and are replaced with their
+// size unless Options.LogValues is set. Attributes named password, token or
+// secret are always redacted.
 package logger
 
 import (
-	"encoding/json"
 	"fmt"
+	"log/slog"
 	"os"
+	"sync/atomic"
 	"time"
 )
 
-// LogEntry represents a single log entry.
-type LogEntry struct {
-	Timestamp string `json:"timestamp"`
-	Message   string `json:"message"`
+// ValueKey is the attribute name of stored values, which are redacted unless
+// Options.LogValues is set.
+const ValueKey = "value"
+
+// Options configures a Logger. SampleRate must be set for requests to be
+// logged: the zero value logs no requests, only messages at the Info level
+// and above, without rotation.
+type Options struct {
+	Level          slog.Level    // least severe level written
+	MaxSize        int64         // rotate the file once it reaches this many bytes, 0 for no limit
+	RotateInterval time.Duration // rotate the file once it is this old, 0 for no limit
+	MaxBackups     int           // rotated files kept, the oldest are removed, 0 to keep all
+	SampleRate     int           // log one request in SampleRate, see Request; 0 logs none
+	LogValues      bool          // write stored values instead of their size
 }
 
-// Logger is a custom logger that writes logs to both the terminal and a JSON file.
+// A Logger writes structured log records. A nil *Logger writes nothing, so
+// callers do not need to check whether logging is enabled.
 type Logger struct {
-	filePath string
-	file     *os.File
+	slog       *slog.Logger
+	level      slog.LevelVar
+	sampleRate atomic.Int64
+	logValues  atomic.Bool
+	requests   atomic.Uint64
+
+	file *rotatingFile // nil when writing to stderr
+	out  *asyncWriter  // nil when writing to stderr
 }
 
-// NewLogger creates a new Logger instance.
-func NewLogger(filePath string) (*Logger, error) {
-	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+// NewLogger returns a logger writing JSON lines to filePath, or text to
+// stderr if filePath is empty.
+func NewLogger(filePath string, opts Options) (*Logger, error) {
+	l := &Logger{}
+	l.Configure(opts)
+	handlerOptions := &slog.HandlerOptions{Level: &l.level, ReplaceAttr: l.replaceAttr}
+	if filePath == "" {
+		l.slog = slog.New(slog.NewTextHandler(os.Stderr, handlerOptions))
+		return l, nil
+	}
+	file, err := openRotatingFile(filePath, opts)
 	if err != nil {
 		return nil, err
 	}
+	l.file = file
+	l.out = newAsyncWriter(file)
+	l.slog = slog.New(slog.NewJSONHandler(l.out, handlerOptions))
+	return l, nil
+}
 
-	return &Logger{
-		filePath: filePath,
-		file:     file,
-	}, nil
+// Configure applies new options, e.g. when the configuration is reloaded.
+func (l *Logger) Configure(opts Options) {
+	if l == nil {
+		return
+	}
+	l.level.Set(opts.Level)
+	l.sampleRate.Store(int64(opts.SampleRate))
+	l.logValues.Store(opts.LogValues)
+	if l.file != nil {
+		l.file.configure(opts)
+	}
 }
 
-// Log logs a message or error to the terminal and the JSON file.
-func (l *Logger) Log(message interface{}) {
-	// Create a log entry
-	entry := LogEntry{
-		Timestamp: time.Now().Format(time.RFC3339),
-		Message:   fmt.Sprintf("%v", message),
+// Slog returns the underlying logger, e.g. for slog.SetDefault. l must not
+// be nil.
+func (l *Logger) Slog() *slog.Logger { return l.slog }
+
+// Debug logs at the Debug level. args are key-value pairs or slog.Attrs.
+func (l *Logger) Debug(msg string, args ...any) {
+	if l != nil {
+		l.slog.Debug(msg, args...)
 	}
+}
 
-	// Print to terminal
-	fmt.Printf("[%s] : %s\n", entry.Timestamp, entry.Message)
+// Info logs at the Info level.
+func (l *Logger) Info(msg string, args ...any) {
+	if l != nil {
+		l.slog.Info(msg, args...)
+	}
+}
 
-	// Write to JSON file
-	if err := l.writeJSONLog(entry); err != nil {
-		fmt.Printf("Error writing to log file: %v\n", err)
+// Warn logs at the Warn level.
+func (l *Logger) Warn(msg string, args ...any) {
+	if l != nil {
+		l.slog.Warn(msg, args...)
 	}
 }
 
-// writeJSONLog writes a log entry to the JSON file.
-func (l *Logger) writeJSONLog(entry LogEntry) error {
-	encoder := json.NewEncoder(l.file)
-	encoder.SetIndent("", "  ") // Pretty print
-	if err := encoder.Encode(entry); err != nil {
-		return err
+// Error logs at the Error level.
+func (l *Logger) Error(msg string, args ...any) {
+	if l != nil {
+		l.slog.Error(msg, args...)
 	}
-	return nil
 }
 
-// Close closes the log file.
-func (l *Logger) Close() {
-	if l.file != nil {
-		l.file.Close()
+// Request logs a served request at the Info level if it is sampled: one
+// request in Options.SampleRate is logged, none if it is 0. Failures worth keeping should be
+// logged with Warn or Error, which are not sampled.
+func (l *Logger) Request(msg string, args ...any) {
+	if l == nil {
+		return
+	}
+	rate := l.sampleRate.Load()
+	if rate <= 0 || (rate > 1 && l.requests.Add(1)%uint64(rate) != 0) {
+		return
+	}
+	l.slog.Info(msg, args...)
+}
+
+// Reopen switches the logger to another file, e.g. when the log path changes
+// in the configuration. On error the logger keeps writing to the current file.
+func (l *Logger) Reopen(filePath string) error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	return l.file.reopen(filePath)
+}
+
+// Close writes the pending lines and closes the log file.
+func (l *Logger) Close() error {
+	if l == nil || l.out == nil {
+		return nil
+	}
+	l.out.Close()
+	return l.file.close()
+}
+
+// replaceAttr redacts stored values and secrets.
+func (l *Logger) replaceAttr(groups []string, a slog.Attr) slog.Attr {
+	switch a.Key {
+	case ValueKey:
+		if !l.logValues.Load() {
+			return slog.String(a.Key, redact(a.Value))
+		}
+	case "password", "token", "secret":
+		return slog.String(a.Key, "[redacted]")
+	}
+	return a
+}
+
+// redact describes a value by its size.
+func redact(v slog.Value) string {
+	switch v.Kind() {
+	case slog.KindString:
+		return fmt.Sprintf("[redacted, %d bytes]", len(v.String()))
+	case slog.KindAny:
+		if b, ok := v.Any().([]byte); ok {
+			return fmt.Sprintf("[redacted, %d bytes]", len(b))
+		}
 	}
+	return "[redacted]"
 }
@@ -0,0 +1,102 @@
+package logger
+
+import (
+	"bufio"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readLines returns the JSON records of a log file.
+func readLines(t *testing.T, path string) []map[string]any {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var records []map[string]any
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("expected a JSON line, got %q: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestLogger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "http.log")
+	l, err := NewLogger(path, Options{Level: slog.LevelInfo, SampleRate: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Debug("hidden")
+	for i := 0; i < 4; i++ {
+		l.Request("request", "key", "k", ValueKey, "secret value")
+	}
+	l.Warn("denied", "token", "abc")
+	l.Configure(Options{Level: slog.LevelInfo, SampleRate: 1, LogValues: true})
+	l.Request("request", ValueKey, "shown")
+	l.Configure(Options{Level: slog.LevelInfo})
+	l.Request("request", ValueKey, "dropped")
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records := readLines(t, path)
+	if len(records) != 4 {
+		t.Fatalf("expected 2 sampled requests, the warning and the last request, got %v", records)
+	}
+	if records[0][ValueKey] != "[redacted, 12 bytes]" || records[0]["key"] != "k" {
+		t.Errorf("expected the value to be redacted, got %v", records[0])
+	}
+	if records[2]["level"] != "WARN" || records[2]["token"] != "[redacted]" {
+		t.Errorf("expected a warning with the token redacted, got %v", records[2])
+	}
+	if records[3][ValueKey] != "shown" {
+		t.Errorf("expected the value with LogValues, got %v", records[3])
+	}
+
+	var disabled *Logger
+	disabled.Error("nothing")
+}
+
+func TestRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rpc.log")
+	f, err := openRotatingFile(path, Options{MaxSize: 200, MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := []byte(strings.Repeat("x", 99) + "\n")
+	for i := 0; i < 10; i++ {
+		if err := f.write(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	backups, err := listBackups(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("expected 2 rotated files to be kept, got %v", backups)
+	}
+	for _, file := range append(backups, path) {
+		info, err := os.Stat(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != 200 {
+			t.Errorf("expected %s to hold 2 lines, got %d bytes", file, info.Size())
+		}
+	}
+}
@@ -0,0 +1,337 @@
+// Package metrics collects counters, gauges and histograms and serves them in
+// the Prometheus text exposition format, without external dependencies.
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefBuckets are the default histogram buckets, in seconds. They are finer
+// than Prometheus' defaults because in-memory operations are fast.
+var DefBuckets = []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5}
+
+// Default is the registry served on /metrics.
+var Default = NewRegistry()
+
+// A collector writes the samples of one metric.
+type collector interface {
+	write(w *bufio.Writer, name string)
+}
+
+type family struct {
+	help, kind string
+	collector  collector
+}
+
+// A Registry holds named metrics. It is safe for concurrent use.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]family
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]family)}
+}
+
+// register adds a metric. Registering a name again replaces the metric, so
+// that e.g. a store created again re-registers its gauges.
+func (r *Registry) register(name, help, kind string, c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.families[name] = family{help: help, kind: kind, collector: c}
+}
+
+// WriteTo writes all metrics, sorted by name, in the text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	families := make(map[string]family, len(r.families))
+	for name, f := range r.families {
+		families[name] = f
+	}
+	r.mu.Unlock()
+	sort.Strings(names)
+
+	counter := &countingWriter{w: w}
+	buf := bufio.NewWriter(counter)
+	for _, name := range names {
+		f := families[name]
+		buf.WriteString("# HELP " + name + " " + strings.ReplaceAll(f.help, "\n", " ") + "\n")
+		buf.WriteString("# TYPE " + name + " " + f.kind + "\n")
+		f.collector.write(buf, name)
+	}
+	err := buf.Flush()
+	return counter.n, err
+}
+
+// ServeHTTP serves the metrics to a Prometheus scraper.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// vec holds the children of a metric by label values.
+type vec[T any] struct {
+	labels []string
+	create func() *T
+
+	mu       sync.RWMutex
+	children map[string]*T
+	values   map[string][]string
+}
+
+func newVec[T any](labels []string, create func() *T) *vec[T] {
+	return &vec[T]{labels: labels, create: create, children: make(map[string]*T), values: make(map[string][]string)}
+}
+
+// with returns the child for the label values, creating it on first use.
+func (v *vec[T]) with(values []string) *T {
+	if len(values) != len(v.labels) {
+		panic("metrics: expected " + strconv.Itoa(len(v.labels)) + " label values, got " + strconv.Itoa(len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.RLock()
+	child, ok := v.children[key]
+	v.mu.RUnlock()
+	if ok {
+		return child
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if child, ok = v.children[key]; !ok {
+		child = v.create()
+		v.children[key] = child
+		v.values[key] = append([]string(nil), values...)
+	}
+	return child
+}
+
+// each calls fn for every child, sorted by label values.
+func (v *vec[T]) each(fn func(labels string, child *T)) {
+	v.mu.RLock()
+	keys := make([]string, 0, len(v.children))
+	for key := range v.children {
+		keys = append(keys, key)
+	}
+	v.mu.RUnlock()
+	sort.Strings(keys)
+	for _, key := range keys {
+		v.mu.RLock()
+		child, values := v.children[key], v.values[key]
+		v.mu.RUnlock()
+		fn(formatLabels(v.labels, values), child)
+	}
+}
+
+// A Counter is a value that only goes up.
+type Counter struct {
+	bits atomic.Uint64
+}
+
+// Inc adds 1 to the counter.
+func (c *Counter) Inc() { c.Add(1) }
+
+// Add adds delta, which must not be negative, to the counter.
+func (c *Counter) Add(delta float64) {
+	for {
+		old := c.bits.Load()
+		if c.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+// Value returns the current value of the counter.
+func (c *Counter) Value() float64 { return math.Float64frombits(c.bits.Load()) }
+
+// A CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	*vec[Counter]
+}
+
+// NewCounterVec registers a counter with the given labels.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{newVec(labels, func() *Counter { return &Counter{} })}
+	r.register(name, help, "counter", v)
+	return v
+}
+
+// NewCounter registers a counter without labels.
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return r.NewCounterVec(name, help).With()
+}
+
+// With returns the counter for the label values, in the order of the labels.
+func (v *CounterVec) With(values ...string) *Counter { return v.with(values) }
+
+func (v *CounterVec) write(w *bufio.Writer, name string) {
+	v.each(func(labels string, c *Counter) {
+		writeSample(w, name, labels, c.Value())
+	})
+}
+
+// A Histogram counts observations in buckets.
+type Histogram struct {
+	upperBounds []float64
+
+	mu     sync.Mutex
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// Observe adds an observation, e.g. a duration in seconds.
+func (h *Histogram) Observe(value float64) {
+	i := sort.SearchFloat64s(h.upperBounds, value)
+	h.mu.Lock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.sum += value
+	h.count++
+	h.mu.Unlock()
+}
+
+// A HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	*vec[Histogram]
+	upperBounds []float64
+}
+
+// NewHistogramVec registers a histogram with the given bucket upper bounds,
+// which must be sorted, and labels.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	v := &HistogramVec{upperBounds: buckets}
+	v.vec = newVec(labels, func() *Histogram {
+		return &Histogram{upperBounds: buckets, counts: make([]uint64, len(buckets))}
+	})
+	r.register(name, help, "histogram", v)
+	return v
+}
+
+// NewHistogram registers a histogram without labels.
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	return r.NewHistogramVec(name, help, buckets).With()
+}
+
+// With returns the histogram for the label values, in the order of the labels.
+func (v *HistogramVec) With(values ...string) *Histogram { return v.with(values) }
+
+func (v *HistogramVec) write(w *bufio.Writer, name string) {
+	v.each(func(labels string, h *Histogram) {
+		h.mu.Lock()
+		counts := append([]uint64(nil), h.counts...)
+		sum, count := h.sum, h.count
+		h.mu.Unlock()
+
+		var cumulative uint64
+		for i, bound := range v.upperBounds {
+			cumulative += counts[i]
+			writeSample(w, name+"_bucket", addLabel(labels, "le", formatFloat(bound)), float64(cumulative))
+		}
+		writeSample(w, name+"_bucket", addLabel(labels, "le", "+Inf"), float64(count))
+		writeSample(w, name+"_sum", labels, sum)
+		writeSample(w, name+"_count", labels, float64(count))
+	})
+}
+
+// funcCollector reads its samples when the metrics are written.
+type funcCollector struct {
+	labels  []string
+	collect func(emit func(value float64, labelValues ...string))
+}
+
+func (f *funcCollector) write(w *bufio.Writer, name string) {
+	f.collect(func(value float64, labelValues ...string) {
+		writeSample(w, name, formatLabels(f.labels, labelValues), value)
+	})
+}
+
+// NewGaugeFunc registers a gauge whose value is read from fn.
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.NewGaugeVecFunc(name, help, nil, func(emit func(float64, ...string)) { emit(fn()) })
+}
+
+// NewCounterFunc registers a counter whose value is read from fn, e.g. from
+// statistics kept elsewhere.
+func (r *Registry) NewCounterFunc(name, help string, fn func() float64) {
+	r.register(name, help, "counter", &funcCollector{collect: func(emit func(float64, ...string)) { emit(fn()) }})
+}
+
+// NewGaugeVecFunc registers a gauge with labels whose samples are produced by
+// collect, which calls emit once per sample with label values in the order
+// of labels.
+func (r *Registry) NewGaugeVecFunc(name, help string, labels []string, collect func(emit func(value float64, labelValues ...string))) {
+	r.register(name, help, "gauge", &funcCollector{labels: labels, collect: collect})
+}
+
+func writeSample(w *bufio.Writer, name, labels string, value float64) {
+	w.WriteString(name)
+	w.WriteString(labels)
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+// formatLabels returns {name="value",...}, or "" without labels.
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name + `="` + escapeLabel(values[i]) + `"`)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// addLabel appends a label to formatted labels.
+func addLabel(labels, name, value string) string {
+	pair := name + `="` + escapeLabel(value) + `"`
+	if labels == "" {
+		return "{" + pair + "}"
+	}
+	return labels[:len(labels)-1] + "," + pair + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string { return labelEscaper.Replace(value) }
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExposition(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("requests_total", "Requests.", "op")
+	requests.With("get").Inc()
+	requests.With("get").Add(2)
+	requests.With(`se"t`).Inc()
+	latency := r.NewHistogram("latency_seconds", "Latency.", []float64{0.1, 1})
+	latency.Observe(0.05)
+	latency.Observe(0.5)
+	latency.Observe(5)
+	r.NewGaugeVecFunc("keys", "Keys.", []string{"shard"}, func(emit func(float64, ...string)) {
+		emit(3, "0")
+		emit(4, "1")
+	})
+
+	var out strings.Builder
+	if _, err := r.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP keys Keys.
+# TYPE keys gauge
+keys{shard="0"} 3
+keys{shard="1"} 4
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 5.55
+latency_seconds_count 3
+# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{op="get"} 3
+requests_total{op="se\"t"} 1
+`
+	if out.String() != want {
+		t.Fatalf("unexpected output:\n%s", out.String())
+	}
+}
@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"runtime"
+	"time"
+)
+
+var (
+	requests = Default.NewCounterVec("memorandum_requests_total",
+		"Requests served, by protocol (http, rpc, cluster), operation and status.",
+		"protocol", "operation", "status")
+	requestDuration = Default.NewHistogramVec("memorandum_request_duration_seconds",
+		"Time taken to serve requests, by protocol and operation.",
+		DefBuckets, "protocol", "operation")
+)
+
+// ObserveRequest records a request served over protocol. The operation and
+// status must come from a small fixed set, not from client input.
+func ObserveRequest(protocol, operation, status string, took time.Duration) {
+	requests.With(protocol, operation, status).Inc()
+	requestDuration.With(protocol, operation).Observe(took.Seconds())
+}
+
+func init() {
+	Default.NewGaugeFunc("go_goroutines", "Number of goroutines.", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+	Default.NewGaugeVecFunc("go_memstats_bytes", "Memory obtained from the OS (sys), allocated on the heap (heap_alloc) and in use by heap spans (heap_inuse).",
+		[]string{"kind"}, func(emit func(float64, ...string)) {
+			var m runtime.MemStats
+			runtime.ReadMemStats(&m)
+			emit(float64(m.Sys), "sys")
+			emit(float64(m.HeapAlloc), "heap_alloc")
+			emit(float64(m.HeapInuse), "heap_inuse")
+		})
+	Default.NewCounterFunc("go_gc_cycles_total", "Completed garbage collection cycles.", func() float64 {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		return float64(m.NumGC)
+	})
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/shafigh75/Memorandum/server/db"
+	"github.com/spf13/cobra"
+)
+
+var (
+	walDumpPrefix    string
+	walDumpAction    string
+	walDumpNoValues  bool
+	walDumpLimit     int
+	walStatsTop      int
+	walStatsSep      string
+	walFilterOut     string
+	walFilterDrop    []string
+	walFilterKeep    []string
+	walFilterActions []string
+)
+
+var walCmd = &cobra.Command{
+	Use:   "wal [command] [wal-file]",
+	Short: "Inspect and rewrite WAL files offline",
+	Long: `Offline tools for the binary write-ahead log. Every subcommand takes the WAL
+file as an optional argument and defaults to WAL_path from the config.`,
+}
+
+var walDumpCmd = &cobra.Command{
+	Use:   "dump [wal-file]",
+	Short: "Print WAL entries as JSON lines",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, err := walPathArg(args)
+		if err != nil {
+			return err
+		}
+		return walDump(path)
+	},
+}
+
+var walVerifyCmd = &cobra.Command{
+	Use:   "verify [wal-file]",
+	Short: "Verify entry checksums and report corruption offsets",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, err := walPathArg(args)
+		if err != nil {
+			return err
+		}
+		return walVerify(path)
+	},
+}
+
+var walStatsCmd = &cobra.Command{
+	Use:   "stats [wal-file]",
+	Short: "Print WAL statistics: ops by action, top keys and size by key prefix",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, err := walPathArg(args)
+		if err != nil {
+			return err
+		}
+		return walStats(path)
+	},
+}
+
+var walFilterCmd = &cobra.Command{
+	Use:   "filter [wal-file]",
+	Short: "Rewrite a WAL into a new file, dropping or keeping entries by key prefix or action",
+	Long: `Copies the entries of a WAL that pass the filters into a new file. Swap it in
+while the server is stopped. The entries of the new file have other offsets,
+so snapshots taken against the old WAL cannot be used as a recover --snapshot
+base with it; recover rejects them. Write a new snapshot with recover --out.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, err := walPathArg(args)
+		if err != nil {
+			return err
+		}
+		return walFilter(path)
+	},
+}
+
+func init() {
+	walDumpCmd.Flags().StringVar(&walDumpPrefix, "prefix", "", "only dump entries whose key has this prefix")
+	walDumpCmd.Flags().StringVar(&walDumpAction, "action", "", "only dump entries with this action (set, delete, expire, persist, expired)")
+	walDumpCmd.Flags().BoolVar(&walDumpNoValues, "no-values", false, "omit values from the output")
+	walDumpCmd.Flags().IntVar(&walDumpLimit, "limit", 0, "stop after this many entries (0 = no limit)")
+
+	walStatsCmd.Flags().IntVar(&walStatsTop, "top", 10, "number of most frequently written keys to show")
+	walStatsCmd.Flags().StringVar(&walStatsSep, "separator", ":", "separator that ends a key prefix")
+
+	walFilterCmd.Flags().StringVar(&walFilterOut, "out", "", "file to write the filtered WAL to (required)")
+	walFilterCmd.Flags().StringSliceVar(&walFilterDrop, "drop-prefix", nil, "drop entries whose key has this prefix (repeatable)")
+	walFilterCmd.Flags().StringSliceVar(&walFilterKeep, "keep-prefix", nil, "keep only entries whose key has this prefix (repeatable)")
+	walFilterCmd.Flags().StringSliceVar(&walFilterActions, "drop-action", nil, "drop entries with this action (repeatable)")
+	walFilterCmd.MarkFlagRequired("out")
+
+	walCmd.AddCommand(walDumpCmd, walVerifyCmd, walStatsCmd, walFilterCmd)
+	rootCmd.AddCommand(walCmd)
+}
+
+// walPathArg returns the WAL file given on the command line or the configured one.
+func walPathArg(args []string) (string, error) {
+	if len(args) == 1 {
+		return args[0], nil
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return "", fmt.Errorf("no WAL file given and the config could not be loaded: %w", err)
+	}
+	if cfg.WalPath == "" {
+		return "", fmt.Errorf("no WAL file given and WAL_path is not set in the config")
+	}
+	return cfg.WalPath, nil
+}
+
+// walEntryJSON is the JSON representation of a WAL entry used by `wal dump`.
+type walEntryJSON struct {
+	Offset     int64   `json:"offset"`
+	Action     string  `json:"action"`
+	Key        string  `json:"key"`
+	Value      *string `json:"value,omitempty"`
+	ValueB64   string  `json:"value_base64,omitempty"` // binary values that are not valid UTF-8
+	ExpiresAt  int64   `json:"expires_at,omitempty"`   // Unix milliseconds
+	Expires    string  `json:"expires,omitempty"`
+	Timestamp  int64   `json:"timestamp"` // Unix milliseconds
+	Time       string  `json:"time"`
+	Checksum   uint32  `json:"checksum"`
+	Valid      bool    `json:"valid"`
+	Legacy     bool    `json:"legacy,omitempty"`
+	Compressed bool    `json:"compressed,omitempty"` // the value is stored compressed
+	KeyID      string  `json:"key_id,omitempty"`     // encryption key of the record
+}
+
+// formatMillis renders a Unix millisecond timestamp for humans.
+func formatMillis(ms int64) string {
+	return time.UnixMilli(ms).UTC().Format("2006-01-02T15:04:05.000Z07:00")
+}
+
+// walEach calls fn for every decodable entry and returns the decoding error
+// (other than io.EOF) that stopped the scan, if any.
+func walEach(path string, fn func(record db.WALRecord) bool) error {
+	keys, err := loadKeyring("")
+	if err != nil {
+		return err
+	}
+	reader, err := db.OpenWALReader(path, keys)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("cannot decode entry at offset %d: %w", record.Offset, err)
+		}
+		if !fn(record) {
+			return nil
+		}
+	}
+}
+
+func walDump(path string) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetEscapeHTML(false)
+	dumped := 0
+	var writeErr error
+	err := walEach(path, func(record db.WALRecord) bool {
+		entry := record.Entry
+		if walDumpPrefix != "" && !strings.HasPrefix(entry.Key, walDumpPrefix) {
+			return true
+		}
+		if walDumpAction != "" && entry.Action != walDumpAction {
+			return true
+		}
+		out := walEntryJSON{
+			Offset:     record.Offset,
+			Action:     entry.Action,
+			Key:        entry.Key,
+			ExpiresAt:  entry.ExpiresAt,
+			Timestamp:  entry.Timestamp,
+			Time:       formatMillis(entry.Timestamp),
+			Checksum:   entry.Checksum,
+			Valid:      entry.ValidChecksum(),
+			Legacy:     entry.IsLegacy(),
+			Compressed: entry.Compressed,
+			KeyID:      entry.KeyID,
+		}
+		if entry.ExpiresAt != 0 {
+			out.Expires = formatMillis(entry.ExpiresAt)
+		}
+		if !walDumpNoValues {
+			plain, err := entry.PlainValue()
+			if err != nil {
+				writeErr = fmt.Errorf("entry at offset %d: %w", record.Offset, err)
+				return false
+			}
+			if utf8.Valid(plain) {
+				value := string(plain)
+				out.Value = &value
+			} else {
+				out.ValueB64 = base64.StdEncoding.EncodeToString(plain)
+			}
+		}
+		if writeErr = encoder.Encode(out); writeErr != nil {
+			return false
+		}
+		dumped++
+		return walDumpLimit == 0 || dumped < walDumpLimit
+	})
+	if writeErr != nil {
+		return writeErr
+	}
+	return err
+}
+
+func walVerify(path string) error {
+	var entries, bad int
+	var size int64
+	err := walEach(path, func(record db.WALRecord) bool {
+		entries++
+		size = record.Offset + record.Size
+		if !record.Entry.ValidChecksum() {
+			bad++
+			fmt.Printf(Red+"checksum mismatch at offset %d (key %q)"+Reset+"\n", record.Offset, record.Entry.Key)
+		}
+		return true
+	})
+	if err != nil {
+		fmt.Println(Red + err.Error() + Reset)
+		if info, statErr := os.Stat(path); statErr == nil {
+			fmt.Printf("%d trailing bytes after offset %d cannot be read\n", info.Size()-size, size)
+		}
+	}
+
+	fmt.Printf("%d entries checked, %d bad checksums\n", entries, bad)
+	if err != nil || bad > 0 {
+		return fmt.Errorf("WAL %s is corrupt", path)
+	}
+	fmt.Println(Green + "WAL is intact." + Reset)
+	return nil
+}
+
+// keyCount pairs a key (or prefix) with a counter for sorted reports.
+type keyCount struct {
+	Key   string
+	Count int64
+}
+
+// sortedCounts returns the map entries ordered by descending count.
+func sortedCounts(counts map[string]int64) []keyCount {
+	sorted := make([]keyCount, 0, len(counts))
+	for key, count := range counts {
+		sorted = append(sorted, keyCount{Key: key, Count: count})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Count != sorted[j].Count {
+			return sorted[i].Count > sorted[j].Count
+		}
+		return sorted[i].Key < sorted[j].Key
+	})
+	return sorted
+}
+
+// keyPrefix returns the part of the key before the first separator.
+func keyPrefix(key, sep string) string {
+	if sep == "" {
+		return key
+	}
+	if i := strings.Index(key, sep); i >= 0 {
+		return key[:i+len(sep)]
+	}
+	return key
+}
+
+func walStats(path string) error {
+	var entries, totalBytes, first, last int64
+	actions := make(map[string]int64)
+	keys := make(map[string]int64)
+	prefixBytes := make(map[string]int64)
+	prefixOps := make(map[string]int64)
+
+	err := walEach(path, func(record db.WALRecord) bool {
+		entry := record.Entry
+		entries++
+		totalBytes += record.Size
+		if first == 0 || entry.Timestamp < first {
+			first = entry.Timestamp
+		}
+		if entry.Timestamp > last {
+			last = entry.Timestamp
+		}
+		actions[entry.Action]++
+		keys[entry.Key]++
+		prefix := keyPrefix(entry.Key, walStatsSep)
+		prefixBytes[prefix] += record.Size
+		prefixOps[prefix]++
+		return true
+	})
+	if err != nil {
+		fmt.Println(Red + err.Error() + Reset)
+	}
+
+	fmt.Printf("File:     %s\n", path)
+	fmt.Printf("Entries:  %d\n", entries)
+	fmt.Printf("Bytes:    %d\n", totalBytes)
+	if entries > 0 {
+		fmt.Printf("First:    %s\n", formatMillis(first))
+		fmt.Printf("Last:     %s\n", formatMillis(last))
+	}
+	fmt.Printf("Distinct keys: %d\n", len(keys))
+
+	fmt.Println("\nOps by action:")
+	for _, kc := range sortedCounts(actions) {
+		fmt.Printf("  %-10s %d\n", kc.Key, kc.Count)
+	}
+
+	fmt.Printf("\nTop %d keys by ops:\n", walStatsTop)
+	for i, kc := range sortedCounts(keys) {
+		if i >= walStatsTop {
+			break
+		}
+		fmt.Printf("  %-40q %d\n", kc.Key, kc.Count)
+	}
+
+	fmt.Println("\nSize by key prefix:")
+	for _, kc := range sortedCounts(prefixBytes) {
+		fmt.Printf("  %-40q %10d bytes %8d ops\n", kc.Key, kc.Count, prefixOps[kc.Key])
+	}
+	return err
+}
+
+// hasAnyPrefix reports whether key starts with one of the prefixes.
+func hasAnyPrefix(key string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func walFilter(path string) error {
+	if len(walFilterDrop) == 0 && len(walFilterKeep) == 0 && len(walFilterActions) == 0 {
+		return fmt.Errorf("no filter given: use --drop-prefix, --keep-prefix or --drop-action")
+	}
+	inPath, _ := filepath.Abs(path)
+	outPath, _ := filepath.Abs(walFilterOut)
+	if inPath == outPath {
+		return fmt.Errorf("refusing to overwrite the input WAL; write to a new file and swap it in while the server is stopped")
+	}
+
+	keys, err := loadKeyring("")
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file so that a failed filter leaves no partial WAL.
+	tmp := walFilterOut + ".tmp"
+	writer, err := db.CreateWALWriter(tmp, keys)
+	if err != nil {
+		return err
+	}
+
+	var kept, dropped int
+	var writeErr error
+	err = walEach(path, func(record db.WALRecord) bool {
+		entry := record.Entry
+		drop := hasAnyPrefix(entry.Key, walFilterDrop) ||
+			(len(walFilterKeep) > 0 && !hasAnyPrefix(entry.Key, walFilterKeep))
+		for _, action := range walFilterActions {
+			if entry.Action == action {
+				drop = true
+			}
+		}
+		if drop {
+			dropped++
+			return true
+		}
+		if writeErr = writer.Write(entry); writeErr != nil {
+			return false
+		}
+		kept++
+		return true
+	})
+	if closeErr := writer.Close(); writeErr == nil {
+		writeErr = closeErr
+	}
+	if writeErr == nil {
+		writeErr = err
+	}
+	if writeErr != nil {
+		os.Remove(tmp)
+		return writeErr
+	}
+	if err := os.Rename(tmp, walFilterOut); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	fmt.Printf("Wrote %d entries to %s (%d dropped)\n", kept, walFilterOut, dropped)
+	return nil
+}
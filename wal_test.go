@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shafigh75/Memorandum/server/db"
+)
+
+// writeFixtureWAL writes a WAL with sets, a delete and an expire through a
+// store, as the server does, and points the offline tools at an empty config.
+func writeFixtureWAL(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(configFile, []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	configPath = configFile
+	t.Cleanup(func() { configPath = "" })
+	t.Setenv(db.EncryptionKeyEnv, "")
+
+	path := filepath.Join(dir, "wal.bin")
+	wal, err := db.NewWAL(path, 16, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := db.NewShardedInMemoryStore(4, wal)
+	store.Set("session:1", "alice", 0)
+	store.Set("session:2", "bob", 60)
+	store.Set("tmp:1", "scratch", 0)
+	store.Delete("tmp:1")
+	store.Expire("session:1", 30)
+	if err := wal.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// captureStdout returns what fn prints to stdout.
+func captureStdout(t *testing.T, fn func() error) (string, error) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	done := make(chan string)
+	go func() {
+		out, _ := io.ReadAll(r)
+		done <- string(out)
+	}()
+	err = fn()
+	os.Stdout = stdout
+	w.Close()
+	return <-done, err
+}
+
+func TestWALPathArg(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.json")
+	os.WriteFile(configFile, []byte("{"), 0600)
+	configPath = configFile
+	defer func() { configPath = "" }()
+
+	if path, err := walPathArg([]string{"given.bin"}); err != nil || path != "given.bin" {
+		t.Fatalf("expected the argument, got %q %v", path, err)
+	}
+	if _, err := walPathArg(nil); err == nil || !strings.Contains(err.Error(), "config could not be loaded") {
+		t.Fatalf("expected the config error, got %v", err)
+	}
+}
+
+func TestWALDump(t *testing.T) {
+	path := writeFixtureWAL(t)
+	for _, tc := range []struct {
+		name     string
+		prefix   string
+		action   string
+		noValues bool
+		limit    int
+		want     []string // action:key of each line
+	}{
+		{name: "all", want: []string{"set:session:1", "set:session:2", "set:tmp:1", "delete:tmp:1", "expire:session:1"}},
+		{name: "prefix", prefix: "tmp:", want: []string{"set:tmp:1", "delete:tmp:1"}},
+		{name: "action", action: "set", want: []string{"set:session:1", "set:session:2", "set:tmp:1"}},
+		{name: "limit", limit: 2, noValues: true, want: []string{"set:session:1", "set:session:2"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			walDumpPrefix, walDumpAction, walDumpNoValues, walDumpLimit = tc.prefix, tc.action, tc.noValues, tc.limit
+			defer func() { walDumpPrefix, walDumpAction, walDumpNoValues, walDumpLimit = "", "", false, 0 }()
+			out, err := captureStdout(t, func() error { return walDump(path) })
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+				var entry walEntryJSON
+				if err := json.Unmarshal([]byte(line), &entry); err != nil {
+					t.Fatalf("expected JSON lines, got %q: %v", line, err)
+				}
+				if !entry.Valid {
+					t.Errorf("expected a valid checksum, got %+v", entry)
+				}
+				if hasValue := entry.Value != nil; entry.Action == "set" && hasValue == tc.noValues {
+					t.Errorf("expected values only without --no-values, got %+v", entry)
+				}
+				got = append(got, entry.Action+":"+entry.Key)
+			}
+			if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Errorf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestWALVerifyAndStats(t *testing.T) {
+	path := writeFixtureWAL(t)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	truncated := filepath.Join(t.TempDir(), "truncated.bin")
+	os.WriteFile(truncated, data[:len(data)-3], 0600)
+	flipped := filepath.Join(t.TempDir(), "flipped.bin")
+	corrupt := append([]byte(nil), data...)
+	corrupt[len(corrupt)-1] ^= 0xff // the checksum of the last entry
+	os.WriteFile(flipped, corrupt, 0600)
+
+	for _, tc := range []struct {
+		name    string
+		path    string
+		wantErr bool
+		want    string
+	}{
+		{name: "intact", path: path, want: "5 entries checked, 0 bad checksums"},
+		{name: "truncated", path: truncated, wantErr: true, want: "4 entries checked"},
+		{name: "bad checksum", path: flipped, wantErr: true, want: "1 bad checksums"},
+		{name: "missing", path: filepath.Join(t.TempDir(), "none.bin"), wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := captureStdout(t, func() error { return walVerify(tc.path) })
+			if (err != nil) != tc.wantErr || !strings.Contains(out, tc.want) {
+				t.Errorf("expected error %v and %q, got %v and\n%s", tc.wantErr, tc.want, err, out)
+			}
+		})
+	}
+
+	out, err := captureStdout(t, func() error { return walStats(path) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Entries:  5", "Distinct keys: 3", "set        3", `"session:"`, `"tmp:"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected stats to contain %q, got\n%s", want, out)
+		}
+	}
+}
+
+func TestWALFilter(t *testing.T) {
+	path := writeFixtureWAL(t)
+	for _, tc := range []struct {
+		name    string
+		drop    []string
+		keep    []string
+		actions []string
+		want    []string
+	}{
+		{name: "drop prefix", drop: []string{"tmp:"}, want: []string{"set:session:1", "set:session:2", "expire:session:1"}},
+		{name: "keep prefix", keep: []string{"tmp:"}, want: []string{"set:tmp:1", "delete:tmp:1"}},
+		{name: "drop action", actions: []string{"delete", "expire"}, want: []string{"set:session:1", "set:session:2", "set:tmp:1"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			walFilterOut = filepath.Join(t.TempDir(), "filtered.bin")
+			walFilterDrop, walFilterKeep, walFilterActions = tc.drop, tc.keep, tc.actions
+			defer func() { walFilterOut, walFilterDrop, walFilterKeep, walFilterActions = "", nil, nil, nil }()
+			if _, err := captureStdout(t, func() error { return walFilter(path) }); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			if err := walEach(walFilterOut, func(record db.WALRecord) bool {
+				got = append(got, record.Entry.Action+":"+record.Entry.Key)
+				return record.Entry.ValidChecksum()
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Errorf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+
+	// A WAL that cannot be read to the end leaves no output behind.
+	data, _ := os.ReadFile(path)
+	truncated := filepath.Join(t.TempDir(), "truncated.bin")
+	os.WriteFile(truncated, data[:len(data)-3], 0600)
+	walFilterOut = filepath.Join(t.TempDir(), "filtered.bin")
+	walFilterDrop = []string{"tmp:"}
+	defer func() { walFilterOut, walFilterDrop = "", nil }()
+	if _, err := captureStdout(t, func() error { return walFilter(truncated) }); err == nil {
+		t.Fatal("expected an error for a truncated WAL")
+	}
+	if _, err := os.Stat(walFilterOut); !os.IsNotExist(err) {
+		t.Errorf("expected no output file after a failed filter, got %v", err)
+	}
+	if _, err := os.Stat(walFilterOut + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("expected the temporary file to be removed, got %v", err)
+	}
+}